@@ -53,7 +53,7 @@ var config = Config{
 		"github.com/restic/restic/internal/...",
 		"github.com/restic/restic/cmd/...",
 	},
-	MinVersion: GoVersion{Major: 1, Minor: 8, Patch: 0}, // minimum Go version supported
+	MinVersion: GoVersion{Major: 1, Minor: 18, Patch: 0}, // minimum Go version supported
 }
 
 // Config configures the build.
@@ -239,32 +239,25 @@ func verbosePrintf(message string, args ...interface{}) {
 }
 
 // cleanEnv returns a clean environment with GOPATH and GOBIN removed (if
-// present).
+// present). Module mode is disabled, the build uses the temporary GOPATH.
 func cleanEnv() (env []string) {
 	for _, v := range os.Environ() {
-		if strings.HasPrefix(v, "GOPATH=") || strings.HasPrefix(v, "GOBIN=") {
+		if strings.HasPrefix(v, "GOPATH=") || strings.HasPrefix(v, "GOBIN=") || strings.HasPrefix(v, "GO111MODULE=") {
 			continue
 		}
 
 		env = append(env, v)
 	}
 
-	return env
+	return append(env, "GO111MODULE=off")
 }
 
 // build runs "go build args..." with GOPATH set to gopath.
-func build(cwd string, ver GoVersion, goos, goarch, goarm, gopath string, args ...string) error {
+func build(cwd string, goos, goarch, goarm, gopath string, args ...string) error {
 	a := []string{"build"}
 
-	if ver.AtLeast(GoVersion{1, 10, 0}) {
-		verbosePrintf("Go version is at least 1.10, using new syntax for -gcflags\n")
-		// use new prefix
-		a = append(a, "-asmflags", fmt.Sprintf("all=-trimpath=%s", gopath))
-		a = append(a, "-gcflags", fmt.Sprintf("all=-trimpath=%s", gopath))
-	} else {
-		a = append(a, "-asmflags", fmt.Sprintf("-trimpath=%s", gopath))
-		a = append(a, "-gcflags", fmt.Sprintf("-trimpath=%s", gopath))
-	}
+	a = append(a, "-asmflags", fmt.Sprintf("all=-trimpath=%s", gopath))
+	a = append(a, "-gcflags", fmt.Sprintf("all=-trimpath=%s", gopath))
 
 	a = append(a, args...)
 	cmd := exec.Command("go", a...)
@@ -583,7 +576,7 @@ func main() {
 		"-o", output, config.Main,
 	}
 
-	err = build(filepath.Join(gopath, "src"), ver, targetGOOS, targetGOARCH, targetGOARM, gopath, args...)
+	err = build(filepath.Join(gopath, "src"), targetGOOS, targetGOARCH, targetGOARM, gopath, args...)
 	if err != nil {
 		die("build failed: %v\n", err)
 	}
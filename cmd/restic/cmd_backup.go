@@ -25,6 +25,18 @@ var cmdBackup = &cobra.Command{
 	Long: `
 The "backup" command creates a new snapshot and saves the files and directories
 given as the arguments.
+
+Files are split into chunks of variable size depending on their content. For
+large files which are modified in place, like VM disk images or database
+files, --fixed-chunk-size splits the files into chunks of a fixed size
+instead, which deduplicate well against the previous version of the file. With
+--fixed-chunk-path, this only applies to the files matching one of the
+patterns. Restoring the files does not depend on how they were split.
+
+With --read-device-contents, the content of block devices is saved like the
+content of a regular file instead of only the device node. Targets which are
+symlinks to block devices, like /dev/vg0/lv, are resolved. The content can be
+written back with "restore --device-contents".
 `,
 	PreRun: func(cmd *cobra.Command, args []string) {
 		if backupOptions.Hostname == "" {
@@ -52,20 +64,23 @@ given as the arguments.
 
 // BackupOptions bundles all options for the backup command.
 type BackupOptions struct {
-	Parent           string
-	Force            bool
-	Excludes         []string
-	ExcludeFiles     []string
-	ExcludeOtherFS   bool
-	ExcludeIfPresent []string
-	ExcludeCaches    bool
-	Stdin            bool
-	StdinFilename    string
-	Tags             []string
-	Hostname         string
-	FilesFrom        string
-	TimeStamp        string
-	WithAtime        bool
+	Parent             string
+	Force              bool
+	Excludes           []string
+	ExcludeFiles       []string
+	ExcludeOtherFS     bool
+	ExcludeIfPresent   []string
+	ExcludeCaches      bool
+	Stdin              bool
+	StdinFilename      string
+	Tags               []string
+	Hostname           string
+	FilesFrom          string
+	TimeStamp          string
+	WithAtime          bool
+	FixedChunkSize     string
+	FixedChunkPaths    []string
+	ReadDeviceContents bool
 }
 
 var backupOptions BackupOptions
@@ -88,6 +103,9 @@ func init() {
 	f.StringVar(&backupOptions.FilesFrom, "files-from", "", "read the files to backup from file (can be combined with file args)")
 	f.StringVar(&backupOptions.TimeStamp, "time", "", "time of the backup (ex. '2012-11-01 22:08:41') (default: now)")
 	f.BoolVar(&backupOptions.WithAtime, "with-atime", false, "store the atime for all files and directories")
+	f.StringVar(&backupOptions.FixedChunkSize, "fixed-chunk-size", "", "split files into chunks of a fixed `size` (e.g. 1M) instead of content defined chunks")
+	f.StringArrayVar(&backupOptions.FixedChunkPaths, "fixed-chunk-path", nil, "only use fixed-size chunks for files matching the `pattern` (can be specified multiple times)")
+	f.BoolVar(&backupOptions.ReadDeviceContents, "read-device-contents", false, "save the content of block devices instead of only the device node")
 }
 
 func newScanProgress(gopts GlobalOptions) *restic.Progress {
@@ -231,6 +249,60 @@ func filterExisting(items []string) (result []string, err error) {
 	return
 }
 
+// parseFixedChunkSize returns the size given with --fixed-chunk-size, or zero
+// if it is not set.
+func parseFixedChunkSize(opts BackupOptions) (uint, error) {
+	if opts.FixedChunkSize == "" {
+		if len(opts.FixedChunkPaths) > 0 {
+			return 0, errors.Fatal("--fixed-chunk-path can only be used together with --fixed-chunk-size")
+		}
+		return 0, nil
+	}
+
+	size, err := parseSizeStr(opts.FixedChunkSize)
+	if err != nil {
+		return 0, errors.Fatalf("invalid value for --fixed-chunk-size: %v", err)
+	}
+
+	if size < restic.MinChunkSizeLimit || size > restic.MaxChunkSizeLimit {
+		return 0, errors.Fatalf("--fixed-chunk-size must be between %v and %v",
+			formatBytes(restic.MinChunkSizeLimit), formatBytes(restic.MaxChunkSizeLimit))
+	}
+
+	return uint(size), nil
+}
+
+// resolveDeviceSymlinks replaces the items which are symlinks to block
+// devices with the path of the device.
+func resolveDeviceSymlinks(items []string) []string {
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		fi, err := fs.Lstat(item)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			result = append(result, item)
+			continue
+		}
+
+		fi, err = fs.Stat(item)
+		if err != nil || fi.Mode()&(os.ModeType|os.ModeCharDevice) != os.ModeDevice {
+			result = append(result, item)
+			continue
+		}
+
+		dev, err := filepath.EvalSymlinks(item)
+		if err != nil {
+			Warnf("unable to resolve %v: %v\n", item, err)
+			result = append(result, item)
+			continue
+		}
+
+		Verbosef("reading device %v for %v\n", dev, item)
+		result = append(result, dev)
+	}
+
+	return result
+}
+
 func readBackupFromStdin(opts BackupOptions, gopts GlobalOptions, args []string) error {
 	if len(args) != 0 {
 		return errors.Fatal("when reading from stdin, no additional files can be specified")
@@ -250,6 +322,15 @@ func readBackupFromStdin(opts BackupOptions, gopts GlobalOptions, args []string)
 		return errors.Fatal("unable to read password from stdin when data is to be read from stdin, use --password-file or $RESTIC_PASSWORD")
 	}
 
+	if len(opts.FixedChunkPaths) > 0 {
+		return errors.Fatal("--fixed-chunk-path cannot be used when reading from stdin")
+	}
+
+	fixedChunkSize, err := parseFixedChunkSize(opts)
+	if err != nil {
+		return err
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -266,10 +347,16 @@ func readBackupFromStdin(opts BackupOptions, gopts GlobalOptions, args []string)
 		return err
 	}
 
+	_, err = flushStaging(gopts, repo)
+	if err != nil {
+		return err
+	}
+
 	r := &archiver.Reader{
-		Repository: repo,
-		Tags:       opts.Tags,
-		Hostname:   opts.Hostname,
+		Repository:     repo,
+		Tags:           opts.Tags,
+		Hostname:       opts.Hostname,
+		FixedChunkSize: fixedChunkSize,
 	}
 
 	_, id, err := r.Archive(gopts.ctx, fn, os.Stdin, newArchiveStdinProgress(gopts))
@@ -328,6 +415,11 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("unable to read password from stdin when data is to be read from stdin, use --password-file or $RESTIC_PASSWORD")
 	}
 
+	fixedChunkSize, err := parseFixedChunkSize(opts)
+	if err != nil {
+		return err
+	}
+
 	fromfile, err := readLinesFromFile(opts.FilesFrom)
 	if err != nil {
 		return err
@@ -354,6 +446,10 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	if opts.ReadDeviceContents {
+		target = resolveDeviceSymlinks(target)
+	}
+
 	// rejectFuncs collect functions that can reject items from the backup
 	var rejectFuncs []RejectFunc
 
@@ -414,8 +510,23 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	_, err = flushStaging(gopts, repo)
+	if err != nil {
+		return err
+	}
+
 	var parentSnapshotID *restic.ID
 
+	if repo.WriteOnly() {
+		// the snapshots cannot be read with a write-only key, so all files
+		// need to be read again
+		if opts.Parent != "" {
+			return errors.Fatal("--parent cannot be used with a write-only key")
+		}
+		Verbosef("using a write-only key, all files will be read\n")
+		opts.Force = true
+	}
+
 	// Force using a parent
 	if !opts.Force && opts.Parent != "" {
 		id, err := restic.FindSnapshot(repo, opts.Parent)
@@ -460,6 +571,9 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, args []string) error {
 	arch.Excludes = opts.Excludes
 	arch.SelectFilter = selectFilter
 	arch.WithAccessTime = opts.WithAtime
+	arch.FixedChunkSize = fixedChunkSize
+	arch.FixedChunkPatterns = opts.FixedChunkPaths
+	arch.ReadDeviceContents = opts.ReadDeviceContents
 
 	arch.Warn = func(dir string, fi os.FileInfo, err error) {
 		// TODO: make ignoring errors configurable
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strconv"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/restic/restic/internal/backend/mirror"
 	"github.com/restic/restic/internal/checker"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
@@ -23,6 +25,14 @@ finds. It can also be used to read all data and therefore simulate a restore.
 
 By default, the "check" command will always load all data directly from the
 repository and not use a local cache.
+
+For a mirror repository, the files in all mirrored repositories are compared
+first. With --repair-mirrors, files which are missing or have the wrong size in
+some of them are copied from an intact copy in another one.
+
+If the repository was created with parity data, packs without parity data are
+reported. Damaged packs found with --read-data or --read-data-subset can be
+reconstructed from the parity data with "restic repair packs".
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -39,6 +49,7 @@ type CheckOptions struct {
 	ReadDataSubset string
 	CheckUnused    bool
 	WithCache      bool
+	RepairMirrors  bool
 }
 
 var checkOptions CheckOptions
@@ -51,6 +62,7 @@ func init() {
 	f.StringVar(&checkOptions.ReadDataSubset, "read-data-subset", "", "read subset of data packs")
 	f.BoolVar(&checkOptions.CheckUnused, "check-unused", false, "find unused blobs")
 	f.BoolVar(&checkOptions.WithCache, "with-cache", false, "use the cache")
+	f.BoolVar(&checkOptions.RepairMirrors, "repair-mirrors", false, "copy files missing in some mirrored repositories from the others")
 }
 
 func checkFlags(opts CheckOptions) error {
@@ -141,6 +153,23 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		}
 	}
 
+	errorsFound := false
+
+	mirrorBackend := findMirror(repo.Backend())
+	switch {
+	case mirrorBackend != nil:
+		Verbosef("compare mirrored repositories\n")
+		ok, err := checkMirrors(gopts.ctx, mirrorBackend, opts.RepairMirrors)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			errorsFound = true
+		}
+	case opts.RepairMirrors:
+		return errors.Fatal("--repair-mirrors can only be used with a mirror repository")
+	}
+
 	chkr := checker.New(repo)
 
 	Verbosef("load indexes\n")
@@ -165,7 +194,6 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("LoadIndex returned errors")
 	}
 
-	errorsFound := false
 	errChan := make(chan error)
 
 	Verbosef("check all packs\n")
@@ -176,6 +204,21 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 	}
 
+	if repo.Config().ParityShards > 0 {
+		Verbosef("check parity data\n")
+		errChan = make(chan error)
+		go chkr.Parity(gopts.ctx, errChan)
+
+		// packs without parity data are still intact, e.g. they may have
+		// been written by an older version of restic
+		for err := range errChan {
+			if _, ok := err.(checker.PackError); !ok {
+				errorsFound = true
+			}
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+
 	Verbosef("check snapshots, trees and blobs\n")
 	errChan = make(chan error)
 	go chkr.Structure(gopts.ctx, errChan)
@@ -199,6 +242,7 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		}
 	}
 
+	var damagedPacks restic.IDs
 	doReadData := func(bucket, totalBuckets uint) {
 		packs := restic.IDSet{}
 		for pack := range chkr.GetPacks() {
@@ -222,6 +266,9 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		for err := range errChan {
 			errorsFound = true
 			fmt.Fprintf(os.Stderr, "%v\n", err)
+			if e, ok := err.(checker.PackError); ok {
+				damagedPacks = append(damagedPacks, e.ID)
+			}
 		}
 	}
 
@@ -233,6 +280,18 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		doReadData(dataSubset[0], dataSubset[1])
 	}
 
+	if len(damagedPacks) > 0 {
+		what := "salvage the intact blobs"
+		if repo.Config().ParityShards > 0 {
+			what = "reconstruct them from the parity data"
+		}
+		ids := make([]string, 0, len(damagedPacks))
+		for _, id := range damagedPacks {
+			ids = append(ids, id.String())
+		}
+		Printf("\nrun `restic repair packs %v' to %v\n", strings.Join(ids, " "), what)
+	}
+
 	if errorsFound {
 		return errors.Fatal("repository contains errors")
 	}
@@ -241,3 +300,46 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 
 	return nil
 }
+
+// findMirror returns the mirror backend used by be, or nil.
+func findMirror(be restic.Backend) *mirror.Backend {
+	for be != nil {
+		if mb, ok := be.(*mirror.Backend); ok {
+			return mb
+		}
+		be = innerBackend(be)
+	}
+	return nil
+}
+
+// checkMirrors compares the files in all members of be and repairs the
+// divergent files if repair is true. It returns false if divergent files
+// remain.
+func checkMirrors(ctx context.Context, be *mirror.Backend, repair bool) (bool, error) {
+	divs, err := be.Compare(ctx)
+	if err != nil {
+		return false, errors.Fatalf("unable to compare mirrored repositories: %v", err)
+	}
+
+	ok := true
+	for _, d := range divs {
+		fmt.Fprintf(os.Stderr, "mirrored file differs: %v\n", d)
+		if !repair {
+			ok = false
+			continue
+		}
+
+		if err := be.Repair(ctx, d); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to repair %v: %v\n", d.Handle, err)
+			ok = false
+			continue
+		}
+		Printf("repaired %v\n", d.Handle)
+	}
+
+	if !ok && !repair {
+		Printf("\nrun `restic check --repair-mirrors' to correct this\n")
+	}
+
+	return ok, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/mirror"
+	"github.com/restic/restic/internal/cache"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestFindMirror(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	mb, err := mirror.New(mem.New(), mem.New())
+	rtest.OK(t, err)
+
+	c, err := cache.New(restic.NewRandomID().String(), dir)
+	rtest.OK(t, err)
+
+	// the backend is wrapped like in a repository opened by check --with-cache
+	be := c.Wrap(&backend.RetryBackend{Backend: mb})
+	rtest.Assert(t, findMirror(be) == mb, "mirror backend not found below the cache")
+
+	be = c.Wrap(&backend.RetryBackend{Backend: mem.New()})
+	rtest.Assert(t, findMirror(be) == nil, "mirror backend found for a non-mirror repository")
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdCopy = &cobra.Command{
+	Use:   "copy [flags] [snapshotID ...]",
+	Short: "Copy snapshots from one repository to another",
+	Long: `
+The "copy" command copies one or more snapshots from the repository given with
+--from-repo to the repository given with --repo.
+
+When no snapshot ID is given, all snapshots matching the host, tag and path
+filter criteria are copied. Snapshots which have already been copied to the
+destination repository are skipped.
+
+Only the blobs which are not yet stored in the destination repository are
+transferred. They are re-encrypted with the key of the destination repository.
+For the deduplication to work well for later backups into both repositories,
+they should use the same chunker parameters, see "restic init
+--copy-chunker-params". Both repositories must use the same chunk sizes,
+otherwise the copied data would not match the chunk sizes of the destination
+repository.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCopy(copyOptions, globalOptions, args)
+	},
+}
+
+// CopyOptions bundles all options for the copy command.
+type CopyOptions struct {
+	secondaryRepoOptions
+	Host  string
+	Tags  restic.TagLists
+	Paths []string
+}
+
+var copyOptions CopyOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdCopy)
+
+	f := cmdCopy.Flags()
+	initSecondaryRepoOptions(f, &copyOptions.secondaryRepoOptions, "from", "source")
+	f.StringVarP(&copyOptions.Host, "host", "H", "", "only consider snapshots for this `host`, when no snapshot ID is given")
+	f.Var(&copyOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot ID is given")
+	f.StringArrayVar(&copyOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot ID is given")
+}
+
+func runCopy(opts CopyOptions, gopts GlobalOptions, args []string) error {
+	srcGopts, err := fillSecondaryGlobalOpts(opts.secondaryRepoOptions, gopts, "from", "source")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	srcRepo, err := OpenRepository(srcGopts)
+	if err != nil {
+		return err
+	}
+
+	dstRepo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	srcSizes, dstSizes := srcRepo.Config().ChunkSizes(), dstRepo.Config().ChunkSizes()
+	if srcSizes != dstSizes {
+		return errors.Fatalf("chunk sizes of the source repository (%d/%d/%d) and the destination repository (%d/%d/%d) differ",
+			srcSizes.Min, srcSizes.Avg, srcSizes.Max, dstSizes.Min, dstSizes.Avg, dstSizes.Max)
+	}
+
+	if !gopts.NoLock {
+		srcLock, err := lockRepo(srcRepo)
+		defer unlockRepo(srcLock)
+		if err != nil {
+			return err
+		}
+
+		dstLock, err := lockRepo(dstRepo)
+		defer unlockRepo(dstLock)
+		if err != nil {
+			return err
+		}
+	}
+
+	Verbosef("loading index for source repository\n")
+	if err = srcRepo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	Verbosef("loading index for destination repository\n")
+	if err = dstRepo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	dstSnapshots, err := restic.LoadAllSnapshots(ctx, dstRepo)
+	if err != nil {
+		return err
+	}
+
+	// index the snapshots in the destination repository by the ID of their
+	// original snapshot
+	dstSnapshotsByOriginal := make(map[restic.ID][]*restic.Snapshot)
+	for _, sn := range dstSnapshots {
+		original := *sn.ID()
+		if sn.Original != nil {
+			original = *sn.Original
+		}
+		dstSnapshotsByOriginal[original] = append(dstSnapshotsByOriginal[original], sn)
+	}
+
+	seenTrees := restic.NewBlobSet()
+	copied := 0
+	for sn := range FindFilteredSnapshots(ctx, srcRepo, opts.Host, opts.Tags, opts.Paths, args) {
+		original := *sn.ID()
+		if sn.Original != nil {
+			original = *sn.Original
+		}
+
+		if dstSn := findCopiedSnapshot(dstSnapshotsByOriginal[original], sn); dstSn != nil {
+			Verbosef("skipping snapshot %v, already copied to snapshot %v\n", sn.ID().Str(), dstSn.ID().Str())
+			continue
+		}
+
+		Verbosef("copying snapshot %v of %v at %s\n", sn.ID().Str(), sn.Paths, sn.Time)
+
+		if sn.Tree == nil {
+			Warnf("snapshot %v has no tree, skipping\n", sn.ID().Str())
+			continue
+		}
+
+		err = copyTree(ctx, srcRepo, dstRepo, *sn.Tree, seenTrees)
+		if err != nil {
+			return err
+		}
+
+		if err = dstRepo.Flush(ctx); err != nil {
+			return err
+		}
+
+		if err = dstRepo.SaveIndex(ctx); err != nil {
+			return err
+		}
+
+		// the parent is not necessarily present in the destination repository
+		sn.Parent = nil
+		sn.Original = &original
+
+		id, err := dstRepo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+		if err != nil {
+			return err
+		}
+
+		Verbosef("snapshot %v saved\n", id.Str())
+		copied++
+	}
+
+	Verbosef("copied %d snapshots\n", copied)
+
+	return nil
+}
+
+// findCopiedSnapshot returns the snapshot from candidates which is a copy of
+// sn, or nil if no such snapshot exists.
+func findCopiedSnapshot(candidates []*restic.Snapshot, sn *restic.Snapshot) *restic.Snapshot {
+	for _, c := range candidates {
+		if !c.Time.Equal(sn.Time) || c.Hostname != sn.Hostname || c.Username != sn.Username {
+			continue
+		}
+
+		if c.Tree == nil || sn.Tree == nil || !c.Tree.Equal(*sn.Tree) {
+			continue
+		}
+
+		if !reflect.DeepEqual(c.Paths, sn.Paths) || !reflect.DeepEqual(c.Tags, sn.Tags) {
+			continue
+		}
+
+		return c
+	}
+
+	return nil
+}
+
+// copyTree copies all blobs referenced by the tree treeID which are not yet
+// stored in dstRepo from srcRepo to dstRepo. Trees in seenTrees are skipped.
+func copyTree(ctx context.Context, srcRepo, dstRepo *repository.Repository, treeID restic.ID, seenTrees restic.BlobSet) error {
+	blobs := restic.NewBlobSet()
+	err := restic.FindUsedBlobs(ctx, srcRepo, treeID, blobs, seenTrees)
+	if err != nil {
+		return err
+	}
+
+	var buf []byte
+	for h := range blobs {
+		if dstRepo.Index().Has(h.ID, h.Type) {
+			continue
+		}
+
+		size, found := srcRepo.LookupBlobSize(h.ID, h.Type)
+		if !found {
+			return errors.Errorf("blob %v not found in source repository", h)
+		}
+
+		if cap(buf) < restic.CiphertextLength(int(size)) {
+			buf = restic.NewBlobBuffer(int(size))
+		}
+
+		n, err := srcRepo.LoadBlob(ctx, h.Type, h.ID, buf)
+		if err != nil {
+			return err
+		}
+
+		debug.Log("copy blob %v (%d bytes)", h, n)
+
+		_, err = dstRepo.SaveBlob(ctx, h.Type, buf[:n], h.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
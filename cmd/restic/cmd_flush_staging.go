@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/backend/staging"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/spf13/cobra"
+)
+
+var cmdFlushStaging = &cobra.Command{
+	Use:   "flush-staging",
+	Short: "Upload files saved in the staging directory",
+	Long: `
+The "flush-staging" command uploads the files which have been saved in the
+staging directory (--staging-dir) while the repository was unreachable. Data
+files are uploaded first, followed by the indexes and the snapshots, so that
+the repository is consistent at any time. Before anything is uploaded, the
+pending indexes and snapshots are checked. When they reference pack files which
+are neither in the repository nor in the staging directory, e.g. because they
+have been removed by "prune" in the meantime, nothing is uploaded.
+
+The backup command uploads pending files automatically when the repository is
+reachable again.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runFlushStaging(globalOptions)
+	},
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdFlushStaging)
+}
+
+func runFlushStaging(gopts GlobalOptions) error {
+	if gopts.StagingDir == "" {
+		return errors.Fatal("no staging directory specified, use --staging-dir")
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockRepo(repo)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	sb := findStaging(repo.Backend())
+	if !sb.Online() {
+		return errors.Fatal("repository is unreachable, unable to upload the files in the staging directory")
+	}
+
+	err = repo.LoadIndex(gopts.ctx)
+	if err != nil {
+		return err
+	}
+
+	n, err := flushStaging(gopts, repo)
+	if err != nil {
+		return err
+	}
+
+	Verbosef("uploaded %d files\n", n)
+	return nil
+}
+
+// flushStaging uploads the pending files in the staging directory if the
+// repository is reachable. It returns the number of uploaded files. The index
+// of repo must already be loaded.
+func flushStaging(gopts GlobalOptions, repo *repository.Repository) (int, error) {
+	sb := findStaging(repo.Backend())
+	if sb == nil || !sb.Online() {
+		return 0, nil
+	}
+
+	n, err := sb.Pending()
+	if err != nil || n == 0 {
+		return 0, err
+	}
+
+	err = checkStagedPacks(gopts.ctx, repo, sb)
+	if err != nil {
+		return 0, errors.Fatalf("unable to upload files from the staging directory: %v", err)
+	}
+
+	Verbosef("uploading %d files from the staging directory\n", n)
+	n, err = sb.Flush(gopts.ctx, func(h restic.Handle) {
+		Verbosef("uploaded %v\n", h)
+	})
+	if err != nil {
+		return n, errors.Fatalf("unable to upload files from the staging directory: %v", err)
+	}
+
+	return n, nil
+}
+
+// checkStagedPacks returns an error if a pack file referenced by the pending
+// indexes or snapshots in the staging directory is neither in the repository
+// nor in the staging directory, so that uploading them would leave the
+// repository inconsistent. Write-only clients cannot read the files saved by
+// earlier runs, so they are not checked.
+func checkStagedPacks(ctx context.Context, repo *repository.Repository, sb *staging.Backend) error {
+	if repo.WriteOnly() {
+		debug.Log("write-only repository, unable to check the pending files")
+		return nil
+	}
+
+	// sb lists the packs in the backend and in the staging directory
+	packs := restic.NewIDSet()
+	err := sb.List(ctx, restic.DataFile, func(fi restic.FileInfo) error {
+		id, err := restic.ParseID(fi.Name)
+		if err != nil {
+			debug.Log("unable to parse %v as an ID", fi.Name)
+			return nil
+		}
+		packs.Insert(id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = sb.ListPending(restic.IndexFile, func(fi restic.FileInfo) error {
+		id, err := restic.ParseID(fi.Name)
+		if err != nil {
+			return err
+		}
+
+		idx, err := repository.LoadIndex(ctx, repo, id)
+		if err != nil {
+			return err
+		}
+
+		for packID := range idx.Packs() {
+			if !packs.Has(packID) {
+				return errors.Errorf("pack %v referenced by the pending index %v is missing", packID.Str(), id.Str())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sb.ListPending(restic.SnapshotFile, func(fi restic.FileInfo) error {
+		id, err := restic.ParseID(fi.Name)
+		if err != nil {
+			return err
+		}
+
+		sn, err := restic.LoadSnapshot(ctx, repo, id)
+		if err != nil {
+			return err
+		}
+
+		if sn.Tree == nil {
+			return errors.Errorf("pending snapshot %v has no tree", id.Str())
+		}
+
+		blobs := restic.NewBlobSet()
+		err = restic.FindUsedBlobs(ctx, repo, *sn.Tree, blobs, restic.NewBlobSet())
+		if err != nil {
+			return errors.Errorf("unable to load the trees of the pending snapshot %v: %v", id.Str(), err)
+		}
+
+		for h := range blobs {
+			if !blobInPacks(repo.Index(), h, packs) {
+				return errors.Errorf("blob %v referenced by the pending snapshot %v is not in any available pack", h, id.Str())
+			}
+		}
+		return nil
+	})
+}
+
+// blobInPacks returns true if the index knows the blob h in one of the packs.
+func blobInPacks(idx restic.Index, h restic.BlobHandle, packs restic.IDSet) bool {
+	pbs, found := idx.Lookup(h.ID, h.Type)
+	if !found {
+		return false
+	}
+
+	for _, pb := range pbs {
+		if packs.Has(pb.PackID) {
+			return true
+		}
+	}
+	return false
+}
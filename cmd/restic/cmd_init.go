@@ -2,8 +2,11 @@ package main
 
 import (
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/parity"
 	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
 
+	"github.com/restic/chunker"
 	"github.com/spf13/cobra"
 )
 
@@ -12,22 +15,103 @@ var cmdInit = &cobra.Command{
 	Short: "Initialize a new repository",
 	Long: `
 The "init" command initializes a new repository.
+
+Files are split into chunks of 512 KiB to 8 MiB, about 1 MiB on average
+above the minimum. The bounds can be set with --min-chunk-size,
+--avg-chunk-size and --max-chunk-size, e.g. larger chunks for VM images or
+smaller ones for many small documents. The average size must be a power of
+two. The chunk sizes cannot be changed after the repository was created.
+Custom chunk sizes require repository version 3, which cannot be opened by
+older versions of restic. Write-only keys require repository version 4.
+
+With --parity, restic writes Reed-Solomon parity data with the given number of
+parity shards for each new pack file in local and sftp repositories. Damaged
+packs can then be reconstructed with "restic repair packs". The setting is
+stored in the repository config.
+
+With --copy-chunker-params, the chunker parameters are copied from the
+repository given with --from-repo. This allows efficient deduplication of data
+copied between the two repositories with the "copy" command.
+
+The key derivation function for the password can be chosen with --kdf. Its
+parameters are calibrated for the current machine, the target time and memory
+can be set with --kdf-time and --kdf-memory. Alternatively, the parameters can
+be given explicitly with --kdf-params.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runInit(globalOptions, args)
+		initOptions.kdfOptions.setChanged(cmd.Flags())
+		return runInit(initOptions, globalOptions, args)
 	},
 }
 
+// InitOptions bundles all options for the init command.
+type InitOptions struct {
+	secondaryRepoOptions
+	RepositoryVersion uint
+	CopyChunkerParams bool
+	MinChunkSize      string
+	AvgChunkSize      string
+	MaxChunkSize      string
+	Parity            uint
+	kdfOptions
+}
+
+var initOptions InitOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdInit)
+
+	f := cmdInit.Flags()
+	f.UintVar(&initOptions.RepositoryVersion, "repository-version", 0, "repository format `version` to use, version 1 does not support compression, version 3 is required for custom chunk sizes, version 4 for write-only keys (default: 2, or 3 with custom chunk sizes)")
+	initSecondaryRepoOptions(f, &initOptions.secondaryRepoOptions, "from", "source")
+	f.BoolVar(&initOptions.CopyChunkerParams, "copy-chunker-params", false, "copy chunker parameters from the source repository (--from-repo)")
+	f.StringVar(&initOptions.MinChunkSize, "min-chunk-size", "", "minimal `size` of a chunk, e.g. 512K (default: 512K)")
+	f.StringVar(&initOptions.AvgChunkSize, "avg-chunk-size", "", "average `size` of a chunk, must be a power of two (default: 1M)")
+	f.StringVar(&initOptions.MaxChunkSize, "max-chunk-size", "", "maximal `size` of a chunk (default: 8M)")
+	f.UintVar(&initOptions.Parity, "parity", 0, "write parity data with this number of `shards` for pack files on local and sftp backends (default: 0, disabled)")
+	initKDFOptions(f, &initOptions.kdfOptions)
 }
 
-func runInit(gopts GlobalOptions, args []string) error {
+func runInit(opts InitOptions, gopts GlobalOptions, args []string) error {
+	if opts.RepositoryVersion != 0 && (opts.RepositoryVersion < restic.MinRepoVersion || opts.RepositoryVersion > restic.MaxRepoVersion) {
+		return errors.Fatalf("unsupported repository version %v, valid versions are %v to %v",
+			opts.RepositoryVersion, restic.MinRepoVersion, restic.MaxRepoVersion)
+	}
+
 	if gopts.Repo == "" {
 		return errors.Fatal("Please specify repository location (-r)")
 	}
 
+	if opts.Parity > parity.MaxParityShards {
+		return errors.Fatalf("invalid number of parity shards %d, must be between 0 and %d", opts.Parity, parity.MaxParityShards)
+	}
+
+	if err := opts.kdfOptions.apply(); err != nil {
+		return err
+	}
+
+	chunkSizes, err := parseChunkSizes(opts)
+	if err != nil {
+		return err
+	}
+
+	chunkerPolynomial, err := maybeReadChunkerParams(opts, gopts, &chunkSizes)
+	if err != nil {
+		return err
+	}
+
+	version := opts.RepositoryVersion
+	customChunkSizes := chunkSizes != (restic.ChunkSizes{}) && chunkSizes != restic.DefaultChunkSizes
+	switch {
+	case version == 0 && customChunkSizes:
+		version = restic.ChunkSizesRepoVersion
+	case version == 0:
+		version = restic.RepoVersion
+	case customChunkSizes && version < restic.ChunkSizesRepoVersion:
+		return errors.Fatalf("custom chunk sizes require repository version %v or later", restic.ChunkSizesRepoVersion)
+	}
+
 	be, err := create(gopts.Repo, gopts.extended)
 	if err != nil {
 		return errors.Fatalf("create repository at %s failed: %v\n", gopts.Repo, err)
@@ -42,7 +126,12 @@ func runInit(gopts GlobalOptions, args []string) error {
 
 	s := repository.New(be)
 
-	err = s.Init(gopts.ctx, gopts.password)
+	err = s.Init(gopts.ctx, gopts.password, repository.InitOptions{
+		Version:           version,
+		ChunkerPolynomial: chunkerPolynomial,
+		ChunkSizes:        chunkSizes,
+		ParityShards:      opts.Parity,
+	})
 	if err != nil {
 		return errors.Fatalf("create key in repository at %s failed: %v\n", gopts.Repo, err)
 	}
@@ -55,3 +144,73 @@ func runInit(gopts GlobalOptions, args []string) error {
 
 	return nil
 }
+
+// parseChunkSizes returns the chunk sizes given with --min-chunk-size,
+// --avg-chunk-size and --max-chunk-size. Sizes which are not set use the
+// default. If none is set, the zero value is returned.
+func parseChunkSizes(opts InitOptions) (restic.ChunkSizes, error) {
+	if opts.MinChunkSize == "" && opts.AvgChunkSize == "" && opts.MaxChunkSize == "" {
+		return restic.ChunkSizes{}, nil
+	}
+
+	sizes := restic.DefaultChunkSizes
+	for _, size := range []struct {
+		flag  string
+		value string
+		dst   *uint
+	}{
+		{"--min-chunk-size", opts.MinChunkSize, &sizes.Min},
+		{"--avg-chunk-size", opts.AvgChunkSize, &sizes.Avg},
+		{"--max-chunk-size", opts.MaxChunkSize, &sizes.Max},
+	} {
+		if size.value == "" {
+			continue
+		}
+
+		n, err := parseSizeStr(size.value)
+		if err != nil {
+			return restic.ChunkSizes{}, errors.Fatalf("invalid value for %v: %v", size.flag, err)
+		}
+		*size.dst = uint(n)
+	}
+
+	if err := sizes.Check(); err != nil {
+		return restic.ChunkSizes{}, errors.Fatalf("invalid chunk sizes: %v", err)
+	}
+
+	return sizes, nil
+}
+
+// maybeReadChunkerParams returns the chunker polynomial of the source
+// repository if requested with --copy-chunker-params, and nil otherwise. The
+// chunk sizes of the source repository are stored in chunkSizes, explicitly
+// given sizes must match them.
+func maybeReadChunkerParams(opts InitOptions, gopts GlobalOptions, chunkSizes *restic.ChunkSizes) (*chunker.Pol, error) {
+	if !opts.CopyChunkerParams {
+		if opts.secondaryRepoOptions.Repo != "" {
+			return nil, errors.Fatal("--from-repo is only used together with --copy-chunker-params")
+		}
+		return nil, nil
+	}
+
+	srcGopts, err := fillSecondaryGlobalOpts(opts.secondaryRepoOptions, gopts, "from", "source")
+	if err != nil {
+		return nil, err
+	}
+
+	srcRepo, err := OpenRepository(srcGopts)
+	if err != nil {
+		return nil, err
+	}
+
+	srcSizes := srcRepo.Config().ChunkSizes()
+	if *chunkSizes != (restic.ChunkSizes{}) && *chunkSizes != srcSizes {
+		return nil, errors.Fatalf("chunk sizes %d/%d/%d do not match the chunk sizes %d/%d/%d of the source repository",
+			chunkSizes.Min, chunkSizes.Avg, chunkSizes.Max, srcSizes.Min, srcSizes.Avg, srcSizes.Max)
+	}
+	*chunkSizes = srcSizes
+
+	pol := srcRepo.Config().ChunkerPolynomial
+	Verbosef("using chunker parameters from repository %v\n", srcGopts.Repo)
+	return &pol, nil
+}
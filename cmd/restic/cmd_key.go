@@ -12,25 +12,55 @@ import (
 )
 
 var cmdKey = &cobra.Command{
-	Use:   "key [list|add|remove|passwd] [ID]",
+	Use:   "key [list|add|remove|passwd|rotate] [ID]",
 	Short: "Manage keys (passwords)",
 	Long: `
 The "key" command manages keys (passwords) for accessing the repository.
+
+The "rotate" action replaces the master key of the repository. All data in the
+repository is re-encrypted with a new master key, which is saved with the
+current password. Afterwards, all other keys for the old master key are
+removed, write-only keys are kept. When the rotation is interrupted, the
+repository cannot be used until "key rotate" is run again to complete it.
+
+A key added with "add --write-only" can only be used to create new backups. It
+cannot read any data from the repository, so the data saved with it is not
+deduplicated against existing data. Write-only keys require repository version
+4, older repositories can be upgraded with "restic migrate upgrade_repo_v4".
+
+The key derivation function for new keys created by "add", "passwd" and
+"rotate" can be chosen with --kdf. Its parameters are calibrated for the
+current machine, the target time and memory can be set with --kdf-time and
+--kdf-memory. Alternatively, the parameters can be given explicitly with
+--kdf-params.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runKey(globalOptions, args)
+		keyOptions.kdfOptions.setChanged(cmd.Flags())
+		return runKey(keyOptions, globalOptions, args)
 	},
 }
 
+// KeyOptions bundles all options for the key command.
+type KeyOptions struct {
+	WriteOnly bool
+	kdfOptions
+}
+
+var keyOptions KeyOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdKey)
+
+	f := cmdKey.Flags()
+	f.BoolVar(&keyOptions.WriteOnly, "write-only", false, "add a key which can only be used to create new backups")
+	initKDFOptions(f, &keyOptions.kdfOptions)
 }
 
 func listKeys(ctx context.Context, s *repository.Repository) error {
 	tab := NewTable()
-	tab.Header = fmt.Sprintf(" %-10s  %-10s  %-10s  %s", "ID", "User", "Host", "Created")
-	tab.RowFormat = "%s%-10s  %-10s  %-10s  %s"
+	tab.Header = fmt.Sprintf(" %-10s  %-10s  %-10s  %-19s  %-8s  %s", "ID", "User", "Host", "Created", "KDF", "Type")
+	tab.RowFormat = "%s%-10s  %-10s  %-10s  %-19s  %-8s  %s"
 
 	err := s.List(ctx, restic.KeyFile, func(id restic.ID, size int64) error {
 		k, err := repository.LoadKey(ctx, s, id.String())
@@ -45,8 +75,21 @@ func listKeys(ctx context.Context, s *repository.Repository) error {
 		} else {
 			current = " "
 		}
+
+		kdf := k.KDF
+		var keyType string
+		switch {
+		case k.WriteOnly:
+			keyType = "write-only"
+		case k.Sealed != nil:
+			// the KDF parameters of sealed keys are only there for older
+			// versions of restic, sealed keys are not protected by a password
+			kdf = ""
+			keyType = "sealed"
+		}
+
 		tab.Rows = append(tab.Rows, []interface{}{current, id.Str(),
-			k.Username, k.Hostname, k.Created.Format(TimeFormat)})
+			k.Username, k.Hostname, k.Created.Format(TimeFormat), kdf, keyType})
 		return nil
 	})
 	if err != nil {
@@ -74,12 +117,27 @@ func getNewPassword(gopts GlobalOptions) (string, error) {
 		"enter password again: ")
 }
 
-func addKey(gopts GlobalOptions, repo *repository.Repository) error {
+func addKey(opts KeyOptions, gopts GlobalOptions, repo *repository.Repository) error {
+	if opts.WriteOnly && repo.Config().Version < restic.WriteOnlyRepoVersion {
+		return errors.Fatalf("write-only keys require repository version %v, run `restic migrate upgrade_repo_v4` to upgrade the repository",
+			restic.WriteOnlyRepoVersion)
+	}
+
 	pw, err := getNewPassword(gopts)
 	if err != nil {
 		return err
 	}
 
+	if opts.WriteOnly {
+		id, err := repository.AddWriteOnlyKey(gopts.ctx, repo, pw)
+		if err != nil {
+			return errors.Fatalf("creating new key failed: %v\n", err)
+		}
+
+		Verbosef("saved new write-only key as %s\n", id)
+		return nil
+	}
+
 	id, err := repository.AddKey(gopts.ctx, repo, pw, repo.Key())
 	if err != nil {
 		return errors.Fatalf("creating new key failed: %v\n", err)
@@ -95,8 +153,17 @@ func deleteKey(ctx context.Context, repo *repository.Repository, name string) er
 		return errors.Fatal("refusing to remove key currently used to access repository")
 	}
 
+	k, err := repository.LoadKey(ctx, repo, name)
+	if err != nil {
+		return err
+	}
+
+	if k.Sealed != nil {
+		return errors.Fatal("refusing to remove sealed key, it is needed to decrypt data saved by a write-only key")
+	}
+
 	h := restic.Handle{Type: restic.KeyFile, Name: name}
-	err := repo.Backend().Remove(ctx, h)
+	err = repo.Backend().Remove(ctx, h)
 	if err != nil {
 		return err
 	}
@@ -127,7 +194,79 @@ func changePassword(gopts GlobalOptions, repo *repository.Repository) error {
 	return nil
 }
 
-func runKey(gopts GlobalOptions, args []string) error {
+// applyKDFOptions configures the key derivation function for new keys. Unless
+// another one is selected with --kdf, the KDF of the key used to open repo is
+// kept.
+func applyKDFOptions(ctx context.Context, opts kdfOptions, repo *repository.Repository) error {
+	key, err := repository.LoadKey(ctx, repo, repo.KeyName())
+	if err != nil {
+		return err
+	}
+
+	if key.KDF != "" && key.KDF != repository.KDFName {
+		repository.KDFName = key.KDF
+		if repository.Params != nil && repository.Params.Name() != key.KDF {
+			// the parameters are calibrated again for the KDF of the key
+			repository.Params = nil
+		}
+	}
+
+	return opts.apply()
+}
+
+func rotateKey(ctx context.Context, opts KeyOptions, gopts GlobalOptions) error {
+	repo, err := newRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	pw, err := ReadPassword(gopts, "enter password for repository: ")
+	if err != nil {
+		return err
+	}
+
+	kr, err := repository.NewKeyRotation(ctx, repo, pw)
+	if err != nil {
+		return err
+	}
+
+	err = applyKDFOptions(ctx, opts.kdfOptions, repo)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockRepoExclusive(repo)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	if kr.Resumed() {
+		Verbosef("resuming interrupted master key rotation\n")
+	}
+
+	var packs uint64
+	err = repo.List(ctx, restic.DataFile, func(id restic.ID, size int64) error {
+		packs++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	Verbosef("re-encrypting the repository with a new master key\n")
+	bar := newProgressMax(!gopts.Quiet, packs, "packs")
+	key, err := kr.Run(ctx, bar)
+	if err != nil {
+		return err
+	}
+
+	Verbosef("saved new key as %s, the keys for the old master key have been removed\n", key)
+
+	return nil
+}
+
+func runKey(opts KeyOptions, gopts GlobalOptions, args []string) error {
 	if len(args) < 1 || (args[0] == "remove" && len(args) != 2) || (args[0] != "remove" && len(args) != 1) {
 		return errors.Fatal("wrong number of arguments")
 	}
@@ -135,11 +274,32 @@ func runKey(gopts GlobalOptions, args []string) error {
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
 
+	if opts.WriteOnly && args[0] != "add" {
+		return errors.Fatal("--write-only can only be used when adding a key")
+	}
+
+	if args[0] == "rotate" {
+		// the repository may be in the middle of an interrupted rotation, so
+		// it is opened by the rotation itself
+		return rotateKey(ctx, opts, gopts)
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
 	}
 
+	if repo.WriteOnly() && args[0] != "list" {
+		return errors.Fatal("keys cannot be managed with a write-only key")
+	}
+
+	if args[0] == "add" || args[0] == "passwd" {
+		err = applyKDFOptions(ctx, opts.kdfOptions, repo)
+		if err != nil {
+			return err
+		}
+	}
+
 	switch args[0] {
 	case "list":
 		lock, err := lockRepo(repo)
@@ -156,7 +316,7 @@ func runKey(gopts GlobalOptions, args []string) error {
 			return err
 		}
 
-		return addKey(gopts, repo)
+		return addKey(opts, gopts, repo)
 	case "remove":
 		lock, err := lockRepoExclusive(repo)
 		defer unlockRepo(lock)
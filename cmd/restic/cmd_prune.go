@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mirror"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/index"
@@ -18,7 +21,8 @@ var cmdPrune = &cobra.Command{
 	Short: "Remove unneeded data from the repository",
 	Long: `
 The "prune" command checks the repository and removes data that is not
-referenced and therefore not needed any more.
+referenced and therefore not needed any more. Temporary files left over from
+interrupted uploads to local and sftp repositories are removed as well.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -104,10 +108,42 @@ func mixedBlobs(list []restic.Blob) bool {
 	return false
 }
 
+// removeStaleTempFiles removes the temporary files left over from interrupted
+// uploads in all backends used by be which write files via temporary files.
+// The repository must be locked exclusively.
+func removeStaleTempFiles(ctx context.Context, be restic.Backend) (int, error) {
+	for be != nil {
+		switch b := be.(type) {
+		case backend.StaleTempFileRemover:
+			return b.RemoveStaleTempFiles(ctx)
+		case *mirror.Backend:
+			removed := 0
+			for _, m := range b.Members() {
+				n, err := removeStaleTempFiles(ctx, m)
+				removed += n
+				if err != nil {
+					return removed, err
+				}
+			}
+			return removed, nil
+		}
+		be = innerBackend(be)
+	}
+	return 0, nil
+}
+
 func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 	ctx := gopts.ctx
 
-	err := repo.LoadIndex(ctx)
+	removed, err := removeStaleTempFiles(ctx, repo.Backend())
+	if err != nil {
+		Warnf("unable to remove stale temporary files: %v\n", err)
+	}
+	if removed > 0 {
+		Verbosef("removed %d stale temporary files\n", removed)
+	}
+
+	err = repo.LoadIndex(ctx)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/checker"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdRepairPacks = &cobra.Command{
+	Use:   "packs [packIDs...]",
+	Short: "Salvage damaged pack files",
+	Long: `
+The "repair packs" command repairs damaged pack files, as reported by "check
+--read-data". Packs with parity data (see the --parity option of "restic init")
+are reconstructed from the parity data first.
+
+For packs which cannot be reconstructed, the command salvages the intact
+blobs. All blobs which can still be decrypted and match their ID are saved to
+new pack files. Afterwards, the damaged packs are removed from the index and
+the repository.
+
+The blobs are located using the header of the pack file. If the header is
+damaged, the pack file is scanned for the blobs the index expects in it. Only
+blobs which match their ID are saved.
+
+If blobs were lost, run "repair snapshots" afterwards to repair the snapshots
+referencing them.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRepairPacks(globalOptions, args)
+	},
+}
+
+func init() {
+	cmdRepair.AddCommand(cmdRepairPacks)
+}
+
+func runRepairPacks(gopts GlobalOptions, args []string) error {
+	if len(args) == 0 {
+		return errors.Fatal("no pack IDs given")
+	}
+
+	ids := restic.NewIDSet()
+	for _, arg := range args {
+		id, err := restic.ParseID(arg)
+		if err != nil {
+			return errors.Fatalf("invalid pack ID %q: %v", arg, err)
+		}
+		ids.Insert(id)
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		Verbosef("create exclusive lock for repository\n")
+		lock, err := lockRepoExclusive(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	for id := range ids {
+		err = checker.RepairPack(ctx, repo.Backend(), id)
+		switch {
+		case err == nil:
+			Printf("reconstructed pack %v from parity data\n", id.Str())
+			ids.Delete(id)
+		case err == checker.ErrNoParity:
+			Verbosef("pack %v has no parity data\n", id.Str())
+		default:
+			Warnf("unable to reconstruct pack %v from parity data: %v\n", id.Str(), err)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	lost := restic.NewBlobSet()
+	for id := range ids {
+		Printf("salvaging pack %v\n", id.Str())
+
+		salvaged, packLost, err := repository.SalvagePack(ctx, repo, id)
+		if err != nil {
+			return errors.Fatalf("unable to salvage pack %v: %v", id.Str(), err)
+		}
+
+		Printf("  salvaged %d blobs, lost %d blobs\n", len(salvaged), len(packLost))
+		for h := range packLost {
+			Printf("  lost blob %v\n", h)
+		}
+		lost.Merge(packLost)
+	}
+
+	// save the salvaged blobs before the damaged packs are removed
+	if err = repo.Flush(ctx); err != nil {
+		return err
+	}
+
+	if err = rebuildIndex(ctx, repo, ids); err != nil {
+		return err
+	}
+
+	for id := range ids {
+		h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+		if err = repo.Backend().Remove(ctx, h); err != nil {
+			return errors.Fatalf("unable to remove pack %v: %v", id.Str(), err)
+		}
+		Verbosef("removed damaged pack %v\n", id.Str())
+	}
+
+	if len(lost) > 0 {
+		Warnf("%d blobs were lost, run \"restic repair snapshots\" to repair the affected snapshots\n", len(lost))
+	}
+
+	return nil
+}
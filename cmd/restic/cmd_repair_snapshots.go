@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdRepairSnapshots = &cobra.Command{
+	Use:   "snapshots [flags] [snapshotID ...]",
+	Short: "Repair snapshots which reference missing data",
+	Long: `
+The "repair snapshots" command creates new snapshots for all snapshots which
+reference data that is missing in the repository. Run "rebuild-index" first,
+so that the index only contains the data which is actually available.
+
+The snapshots are repaired as follows:
+
+* Files with missing content are truncated before the first missing blob.
+  Files of which no content is left are removed.
+* Directories which cannot be loaded are replaced with empty directories.
+
+Every modified file and directory is reported. The new snapshots are tagged
+with "repaired" and reference the damaged snapshot in the "original" field.
+The damaged snapshots are kept unless --forget is given.
+
+When no snapshot ID is given, all snapshots matching the host, tag and path
+filter criteria are repaired.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRepairSnapshots(repairSnapshotsOptions, globalOptions, args)
+	},
+}
+
+// RepairSnapshotsOptions bundles all options for the repair snapshots command.
+type RepairSnapshotsOptions struct {
+	Host   string
+	Tags   restic.TagLists
+	Paths  []string
+	Forget bool
+	DryRun bool
+}
+
+var repairSnapshotsOptions RepairSnapshotsOptions
+
+// repairedTag is added to all snapshots created by repair snapshots.
+const repairedTag = "repaired"
+
+func init() {
+	cmdRepair.AddCommand(cmdRepairSnapshots)
+
+	f := cmdRepairSnapshots.Flags()
+	f.BoolVar(&repairSnapshotsOptions.Forget, "forget", false, "remove the damaged snapshots after creating the repaired ones")
+	f.BoolVarP(&repairSnapshotsOptions.DryRun, "dry-run", "n", false, "do not modify the repository, just print what would be done")
+
+	f.StringVarP(&repairSnapshotsOptions.Host, "host", "H", "", "only consider snapshots for this `host`, when no snapshot ID is given")
+	f.Var(&repairSnapshotsOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot ID is given")
+	f.StringArrayVar(&repairSnapshotsOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot ID is given")
+}
+
+// repairNode returns the node to use instead of node, which is located at path
+// in the snapshot. The content of files is truncated before the first blob
+// which is missing in the index of repo, files without any content left are
+// removed.
+func repairNode(repo *repository.Repository, node *restic.Node, path string) *restic.Node {
+	if node.Type != "file" {
+		return node
+	}
+
+	var size uint64
+	for i, id := range node.Content {
+		blobSize, found := repo.LookupBlobSize(id, restic.DataBlob)
+		if found {
+			size += uint64(blobSize)
+			continue
+		}
+
+		if i == 0 {
+			Printf("  file %q: removed, content is missing\n", path)
+			return nil
+		}
+
+		Printf("  file %q: truncated from %d to %d bytes, content is missing\n", path, node.Size, size)
+		n := *node
+		n.Content = node.Content[:i:i]
+		n.Size = size
+		return &n
+	}
+
+	return node
+}
+
+func runRepairSnapshots(opts RepairSnapshotsOptions, gopts GlobalOptions, args []string) error {
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock && !opts.DryRun {
+		var lock *restic.Lock
+		if opts.Forget {
+			Verbosef("create exclusive lock for repository\n")
+			lock, err = lockRepoExclusive(repo)
+		} else {
+			lock, err = lockRepo(repo)
+		}
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	rewriter := newTreeRewriter(repo, opts.DryRun, func(node *restic.Node, path string) *restic.Node {
+		return repairNode(repo, node, path)
+	})
+	rewriter.replaceTree = func(path string, id restic.ID, err error) (*restic.Tree, error) {
+		if path == string(filepath.Separator) {
+			// an empty snapshot is of no use
+			return nil, errors.Errorf("the root tree %v cannot be loaded: %v", id.Str(), err)
+		}
+
+		Printf("  dir %q: replaced with empty directory, tree %v cannot be loaded: %v\n", path, id.Str(), err)
+		return restic.NewTree(), nil
+	}
+
+	// collect the snapshots first, so that the new snapshots are not
+	// processed again
+	var snapshots restic.Snapshots
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, args) {
+		snapshots = append(snapshots, sn)
+	}
+
+	repaired, failed := 0, 0
+	for _, sn := range snapshots {
+		Printf("snapshot %v of %v at %s\n", sn.ID().Str(), sn.Paths, sn.Time)
+
+		if sn.Tree == nil {
+			Warnf("snapshot %v has no tree, it cannot be repaired\n", sn.ID().Str())
+			failed++
+			continue
+		}
+
+		treeID, err := rewriter.RewriteTree(ctx, string(filepath.Separator), *sn.Tree)
+		if err != nil {
+			Warnf("snapshot %v cannot be repaired: %v\n", sn.ID().Str(), err)
+			failed++
+			continue
+		}
+
+		ok, err := saveRewrittenSnapshot(ctx, repo, sn, treeID, []string{repairedTag}, opts.Forget, opts.DryRun)
+		if err != nil {
+			return errors.Fatalf("unable to save repaired snapshot for %v: %v", sn.ID().Str(), err)
+		}
+		if ok {
+			repaired++
+		}
+	}
+
+	if opts.DryRun {
+		Printf("would have repaired %d snapshots\n", repaired)
+	} else {
+		Printf("repaired %d snapshots\n", repaired)
+	}
+
+	if failed > 0 {
+		return errors.Fatalf("%d snapshots could not be repaired, remove them with \"forget\"", failed)
+	}
+
+	return nil
+}
@@ -18,6 +18,11 @@ a directory.
 
 The special snapshot "latest" can be used to restore the latest snapshot in the
 repository.
+
+With --device-contents, only the content of the given file from the snapshot is
+written to --target, which is a block device or an image file. This restores
+devices saved with "backup --read-device-contents". A block device must be at
+least as large as the saved content, an image file is created or overwritten.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -33,6 +38,8 @@ type RestoreOptions struct {
 	Host    string
 	Paths   []string
 	Tags    restic.TagLists
+
+	DeviceContents string
 }
 
 var restoreOptions RestoreOptions
@@ -44,6 +51,7 @@ func init() {
 	flags.StringArrayVarP(&restoreOptions.Exclude, "exclude", "e", nil, "exclude a `pattern` (can be specified multiple times)")
 	flags.StringArrayVarP(&restoreOptions.Include, "include", "i", nil, "include a `pattern`, exclude everything else (can be specified multiple times)")
 	flags.StringVarP(&restoreOptions.Target, "target", "t", "", "directory to extract data to")
+	flags.StringVar(&restoreOptions.DeviceContents, "device-contents", "", "write the content of the file or device `path` in the snapshot to --target, which is a block device or an image file")
 
 	flags.StringVarP(&restoreOptions.Host, "host", "H", "", `only consider snapshots for this host when the snapshot ID is "latest"`)
 	flags.Var(&restoreOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
@@ -68,6 +76,10 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("exclude and include patterns are mutually exclusive")
 	}
 
+	if opts.DeviceContents != "" && (len(opts.Exclude) > 0 || len(opts.Include) > 0) {
+		return errors.Fatal("--device-contents cannot be combined with exclude or include patterns")
+	}
+
 	snapshotIDString := args[0]
 
 	debug.Log("restore %v to %v", snapshotIDString, opts.Target)
@@ -109,6 +121,11 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		Exitf(2, "creating restorer failed: %v\n", err)
 	}
 
+	if opts.DeviceContents != "" {
+		Verbosef("restoring %v from %s to %s\n", opts.DeviceContents, res.Snapshot(), opts.Target)
+		return res.RestoreFileContents(ctx, opts.DeviceContents, opts.Target)
+	}
+
 	totalErrors := 0
 	res.Error = func(dir string, node *restic.Node, err error) error {
 		Warnf("ignoring error for %s: %s\n", dir, err)
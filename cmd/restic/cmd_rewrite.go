@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/filter"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdRewrite = &cobra.Command{
+	Use:   "rewrite [flags] [snapshotID ...]",
+	Short: "Rewrite snapshots to exclude unwanted files",
+	Long: `
+The "rewrite" command excludes files from existing snapshots. For each snapshot
+which contains files matching one of the exclude patterns, a new snapshot
+without these files is created. The new snapshot references the snapshot it
+was created from in the "original" field.
+
+The patterns are matched against the paths of the files within the snapshot,
+as printed by the "ls" command, e.g. "/work/.env" for the file ".env" in the
+directory "work" which was given to the backup command.
+
+When no snapshot ID is given, all snapshots matching the host, tag and path
+filter criteria are rewritten.
+
+The old snapshots are kept unless --forget is given. Afterwards, the data which
+is no longer referenced by any snapshot can be removed with the "prune"
+command.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRewrite(rewriteOptions, globalOptions, args)
+	},
+}
+
+// RewriteOptions bundles all options for the rewrite command.
+type RewriteOptions struct {
+	Host         string
+	Tags         restic.TagLists
+	Paths        []string
+	Excludes     []string
+	ExcludeFiles []string
+	Forget       bool
+	DryRun       bool
+}
+
+var rewriteOptions RewriteOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdRewrite)
+
+	f := cmdRewrite.Flags()
+	f.StringArrayVarP(&rewriteOptions.Excludes, "exclude", "e", nil, "exclude a `pattern` (can be specified multiple times)")
+	f.StringArrayVar(&rewriteOptions.ExcludeFiles, "exclude-file", nil, "read exclude patterns from a `file` (can be specified multiple times)")
+	f.BoolVar(&rewriteOptions.Forget, "forget", false, "remove the old snapshots after creating the rewritten ones")
+	f.BoolVarP(&rewriteOptions.DryRun, "dry-run", "n", false, "do not modify the repository, just print what would be done")
+
+	f.StringVarP(&rewriteOptions.Host, "host", "H", "", "only consider snapshots for this `host`, when no snapshot ID is given")
+	f.Var(&rewriteOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot ID is given")
+	f.StringArrayVar(&rewriteOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot ID is given")
+}
+
+// treeRewriter rewrites trees recursively. The nodes are passed through
+// rewriteNode, and all trees which have been modified are saved to the
+// repository.
+type treeRewriter struct {
+	repo   *repository.Repository
+	dryRun bool
+
+	// rewriteNode returns the node to store in the new tree instead of node,
+	// which is located at path in the snapshot. When nil is returned, the node
+	// is removed. The node must not be modified in place, a modified copy
+	// needs to be returned instead.
+	rewriteNode func(node *restic.Node, path string) *restic.Node
+
+	// replaceTree is called when the tree id at path cannot be loaded and
+	// returns the tree to use instead. When replaceTree is nil, the error
+	// is returned.
+	replaceTree func(path string, id restic.ID, err error) (*restic.Tree, error)
+
+	// rewritten caches the results for trees which have already been
+	// processed, the result depends on the path of the tree within the
+	// snapshot
+	rewritten map[rewrittenTreeKey]restic.ID
+}
+
+type rewrittenTreeKey struct {
+	path string
+	id   restic.ID
+}
+
+func newTreeRewriter(repo *repository.Repository, dryRun bool, rewriteNode func(*restic.Node, string) *restic.Node) *treeRewriter {
+	return &treeRewriter{
+		repo:        repo,
+		dryRun:      dryRun,
+		rewriteNode: rewriteNode,
+		rewritten:   make(map[rewrittenTreeKey]restic.ID),
+	}
+}
+
+// RewriteTree rewrites the tree id located at path in the snapshot. It
+// returns the ID of the new tree, which equals id if nothing was changed.
+func (t *treeRewriter) RewriteTree(ctx context.Context, path string, id restic.ID) (restic.ID, error) {
+	key := rewrittenTreeKey{path: path, id: id}
+	if newID, ok := t.rewritten[key]; ok {
+		return newID, nil
+	}
+
+	tree, err := t.repo.LoadTree(ctx, id)
+	if err != nil {
+		if t.replaceTree == nil {
+			return restic.ID{}, err
+		}
+
+		tree, err = t.replaceTree(path, id, err)
+		if err != nil {
+			return restic.ID{}, err
+		}
+
+		newID, err := t.saveTree(ctx, tree)
+		if err != nil {
+			return restic.ID{}, err
+		}
+
+		t.rewritten[key] = newID
+		return newID, nil
+	}
+
+	changed := false
+	newTree := restic.NewTree()
+	for _, node := range tree.Nodes {
+		p := filepath.Join(path, node.Name)
+
+		newNode := t.rewriteNode(node, p)
+		if newNode != node {
+			changed = true
+		}
+		if newNode == nil {
+			continue
+		}
+		node = newNode
+
+		if node.Type == "dir" && node.Subtree != nil {
+			subtreeID, err := t.RewriteTree(ctx, p, *node.Subtree)
+			if err != nil {
+				return restic.ID{}, err
+			}
+
+			if !subtreeID.Equal(*node.Subtree) {
+				// do not modify the node of the cached tree
+				n := *node
+				n.Subtree = &subtreeID
+				node = &n
+				changed = true
+			}
+		}
+
+		err = newTree.Insert(node)
+		if err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	newID := id
+	if changed {
+		newID, err = t.saveTree(ctx, newTree)
+		if err != nil {
+			return restic.ID{}, err
+		}
+		debug.Log("rewrote tree %v at %v as %v", id.Str(), path, newID.Str())
+	}
+
+	t.rewritten[key] = newID
+	return newID, nil
+}
+
+// saveTree saves tree to the repository and returns its ID. In dry run mode,
+// only the ID is computed.
+func (t *treeRewriter) saveTree(ctx context.Context, tree *restic.Tree) (restic.ID, error) {
+	if !t.dryRun {
+		return t.repo.SaveTree(ctx, tree)
+	}
+
+	buf, err := json.Marshal(tree)
+	if err != nil {
+		return restic.ID{}, errors.Wrap(err, "MarshalJSON")
+	}
+	return restic.Hash(append(buf, '\n')), nil
+}
+
+func runRewrite(opts RewriteOptions, gopts GlobalOptions, args []string) error {
+	if len(opts.ExcludeFiles) > 0 {
+		opts.Excludes = append(opts.Excludes, readExcludePatternsFromFiles(opts.ExcludeFiles)...)
+	}
+
+	if len(opts.Excludes) == 0 {
+		return errors.Fatal("nothing to do, no exclude patterns given")
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock && !opts.DryRun {
+		var lock *restic.Lock
+		if opts.Forget {
+			Verbosef("create exclusive lock for repository\n")
+			lock, err = lockRepoExclusive(repo)
+		} else {
+			lock, err = lockRepo(repo)
+		}
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	rewriter := newTreeRewriter(repo, opts.DryRun, func(node *restic.Node, path string) *restic.Node {
+		matched, _, err := filter.List(opts.Excludes, path)
+		if err != nil {
+			Warnf("error for exclude pattern: %v\n", err)
+		}
+
+		if !matched {
+			return node
+		}
+
+		if opts.DryRun {
+			Printf("would remove %v\n", path)
+		} else {
+			Verbosef("removing %v\n", path)
+		}
+		return nil
+	})
+
+	// collect the snapshots first, so that the new snapshots are not
+	// processed again
+	var snapshots restic.Snapshots
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, args) {
+		snapshots = append(snapshots, sn)
+	}
+
+	changed := 0
+	for _, sn := range snapshots {
+		Verbosef("processing snapshot %v of %v at %s\n", sn.ID().Str(), sn.Paths, sn.Time)
+
+		if sn.Tree == nil {
+			Warnf("snapshot %v has no tree, skipping\n", sn.ID().Str())
+			continue
+		}
+
+		treeID, err := rewriter.RewriteTree(ctx, string(filepath.Separator), *sn.Tree)
+		if err != nil {
+			return errors.Fatalf("unable to rewrite snapshot %v: %v", sn.ID().Str(), err)
+		}
+
+		ok, err := saveRewrittenSnapshot(ctx, repo, sn, treeID, nil, opts.Forget, opts.DryRun)
+		if err != nil {
+			return errors.Fatalf("unable to rewrite snapshot %v: %v", sn.ID().Str(), err)
+		}
+		if ok {
+			changed++
+		}
+	}
+
+	if opts.DryRun {
+		Verbosef("would have rewritten %d snapshots\n", changed)
+	} else {
+		Verbosef("rewrote %d snapshots\n", changed)
+	}
+
+	return nil
+}
+
+// saveRewrittenSnapshot saves a copy of sn which references the tree
+// treeID, and adds the tags addTags. When treeID equals the tree of sn, nothing
+// is saved. The old snapshot is removed if forget is true. It returns true if
+// a new snapshot was saved (or would have been saved in dry run mode).
+func saveRewrittenSnapshot(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, treeID restic.ID, addTags []string, forget, dryRun bool) (bool, error) {
+	if treeID.Equal(*sn.Tree) {
+		Verbosef("snapshot %v not modified\n", sn.ID().Str())
+		return false, nil
+	}
+
+	if dryRun {
+		Printf("would save a new version of snapshot %v\n", sn.ID().Str())
+		return true, nil
+	}
+
+	oldID := *sn.ID()
+	if sn.Original == nil {
+		sn.Original = &oldID
+	}
+	sn.Tree = &treeID
+	sn.AddTags(addTags)
+	// the summary describes the original backup, not the rewritten snapshot
+	sn.Summary = nil
+
+	// save all new trees and the index before the snapshot references them
+	err := repo.Flush(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if err = repo.SaveIndex(ctx); err != nil {
+		return false, err
+	}
+
+	id, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+	if err != nil {
+		return false, err
+	}
+
+	Verbosef("saved snapshot %v as %v\n", oldID.Str(), id.Str())
+
+	if forget {
+		h := restic.Handle{Type: restic.SnapshotFile, Name: oldID.String()}
+		if err = repo.Backend().Remove(ctx, h); err != nil {
+			return false, err
+		}
+		Verbosef("removed old snapshot %v\n", oldID.Str())
+	}
+
+	return true, nil
+}
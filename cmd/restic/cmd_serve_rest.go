@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/restserver"
+)
+
+var cmdServeREST = &cobra.Command{
+	Use:   "serve-rest [flags]",
+	Short: "Serve the repository via the REST protocol",
+	Long: `
+The "serve-rest" command serves the repository location given with --repo over
+the REST protocol, so that clients can access it with the rest backend. Any
+backend restic can open can be served. The repository is not decrypted, the
+server does not need the password.
+
+Additional repositories below the location are served at the corresponding
+paths, e.g. "rest:http://host:8000/foo" accesses the repository at the
+location given with --repo followed by "/foo".
+
+With --append-only, files cannot be removed or overwritten, except for locks.
+This allows clients to create new backups, but not to remove existing ones.
+The repository must not be modified by other processes while it is served in
+this mode, as files created by them could be overwritten.
+
+With --htpasswd-file, clients must authenticate with a user name and password
+from the given file, created e.g. with "htpasswd -B". With --private-repos,
+users can only access the repositories below the path with their user name.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runServeREST(serveRESTOptions, globalOptions, args)
+	},
+}
+
+// ServeRESTOptions collects all options for the serve-rest command.
+type ServeRESTOptions struct {
+	Listen       string
+	AppendOnly   bool
+	HtpasswdFile string
+	PrivateRepos bool
+	TLSCert      string
+	TLSKey       string
+}
+
+var serveRESTOptions ServeRESTOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdServeREST)
+
+	f := cmdServeREST.Flags()
+	f.StringVar(&serveRESTOptions.Listen, "listen", ":8000", "listen on this `address`")
+	f.BoolVar(&serveRESTOptions.AppendOnly, "append-only", false, "refuse to remove or overwrite files, except for locks")
+	f.StringVar(&serveRESTOptions.HtpasswdFile, "htpasswd-file", "", "authenticate users with the user names and passwords in `file`")
+	f.BoolVar(&serveRESTOptions.PrivateRepos, "private-repos", false, "users can only access the repositories below the path with their user name")
+	f.StringVar(&serveRESTOptions.TLSCert, "tls-cert", "", "serve via HTTPS with the certificate in `file`")
+	f.StringVar(&serveRESTOptions.TLSKey, "tls-key", "", "serve via HTTPS with the private key in `file`")
+}
+
+func runServeREST(opts ServeRESTOptions, gopts GlobalOptions, args []string) error {
+	if len(args) > 0 {
+		return errors.Fatal("the serve-rest command expects no arguments, only options - please see `restic help serve-rest` for usage and flags")
+	}
+
+	if gopts.Repo == "" {
+		return errors.Fatal("Please specify repository location (-r)")
+	}
+
+	if opts.PrivateRepos && opts.HtpasswdFile == "" {
+		return errors.Fatal("--private-repos requires --htpasswd-file")
+	}
+
+	if (opts.TLSCert == "") != (opts.TLSKey == "") {
+		return errors.Fatal("--tls-cert and --tls-key must be given together")
+	}
+
+	cfg := restserver.Config{
+		AppendOnly:   opts.AppendOnly,
+		PrivateRepos: opts.PrivateRepos,
+	}
+
+	if opts.HtpasswdFile != "" {
+		users, err := restserver.ReadHtpasswd(opts.HtpasswdFile)
+		if err != nil {
+			return errors.Fatalf("unable to read htpasswd file: %v", err)
+		}
+		cfg.Users = users
+	} else {
+		Warnf("no --htpasswd-file given, all clients can access the repositories\n")
+	}
+
+	base := strings.TrimRight(gopts.Repo, "/")
+	openRepo := func(ctx context.Context, path string, createRepo bool) (restic.Backend, error) {
+		loc := base
+		if path != "" {
+			loc = base + "/" + path
+		}
+
+		if !createRepo {
+			return open(loc, gopts, gopts.extended)
+		}
+
+		Verbosef("creating repository at %v\n", loc)
+		be, err := create(loc, gopts.extended)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = be.Close(); err != nil {
+			return nil, err
+		}
+
+		// open the new backend like an existing one, the client saves the
+		// config afterwards
+		return openBackend(loc, gopts, gopts.extended)
+	}
+
+	srv, err := restserver.New(openRepo, cfg)
+	if err != nil {
+		return err
+	}
+
+	AddCleanupHandler(srv.Close)
+
+	Verbosef("serving %v on %v\n", gopts.Repo, opts.Listen)
+	if opts.TLSCert != "" {
+		err = http.ListenAndServeTLS(opts.Listen, opts.TLSCert, opts.TLSKey, srv)
+	} else {
+		err = http.ListenAndServe(opts.Listen, srv)
+	}
+
+	return errors.Fatal(err.Error())
+}
@@ -130,9 +130,14 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, compact bool) {
 		return list[i].Time.Before(list[j].Time)
 	})
 
-	// Determine the max widths for host and tag.
+	// Determine the max widths for host and tag, and whether any snapshot
+	// contains a backup summary.
 	maxHost, maxTag := 10, 6
+	hasSummary := false
 	for _, sn := range list {
+		if sn.Summary != nil {
+			hasSummary = true
+		}
 		if len(sn.Hostname) > maxHost {
 			maxHost = len(sn.Hostname)
 		}
@@ -144,7 +149,10 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, compact bool) {
 	}
 
 	tab := NewTable()
-	if !compact {
+	if !compact && hasSummary {
+		tab.Header = fmt.Sprintf("%-8s  %-19s  %-*s  %-*s  %12s  %-3s %s", "ID", "Date", -maxHost, "Host", -maxTag, "Tags", "Size", "", "Directory")
+		tab.RowFormat = fmt.Sprintf("%%-8s  %%-19s  %%%ds  %%%ds  %%12s  %%-3s %%s", -maxHost, -maxTag)
+	} else if !compact {
 		tab.Header = fmt.Sprintf("%-8s  %-19s  %-*s  %-*s  %-3s %s", "ID", "Date", -maxHost, "Host", -maxTag, "Tags", "", "Directory")
 		tab.RowFormat = fmt.Sprintf("%%-8s  %%-19s  %%%ds  %%%ds  %%-3s %%s", -maxHost, -maxTag)
 	} else {
@@ -172,7 +180,13 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, compact bool) {
 			treeElement = "┌──"
 		}
 
-		if !compact {
+		if !compact && hasSummary {
+			size := ""
+			if sn.Summary != nil {
+				size = formatBytes(sn.Summary.TotalBytesProcessed)
+			}
+			tab.Rows = append(tab.Rows, []interface{}{sn.ID().Str(), sn.Time.Format(TimeFormat), sn.Hostname, firstTag, size, treeElement, sn.Paths[0]})
+		} else if !compact {
 			tab.Rows = append(tab.Rows, []interface{}{sn.ID().Str(), sn.Time.Format(TimeFormat), sn.Hostname, firstTag, treeElement, sn.Paths[0]})
 		} else {
 			allTags := ""
@@ -203,7 +217,11 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, compact bool) {
 				treeElement = "└──"
 			}
 
-			tab.Rows = append(tab.Rows, []interface{}{"", "", "", tag, treeElement, path})
+			if hasSummary {
+				tab.Rows = append(tab.Rows, []interface{}{"", "", "", tag, "", treeElement, path})
+			} else {
+				tab.Rows = append(tab.Rows, []interface{}{"", "", "", tag, treeElement, path})
+			}
 		}
 	}
 
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walk"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdStats = &cobra.Command{
+	Use:   "stats [flags] [snapshotID ...]",
+	Short: "Scan the repository and show basic statistics",
+	Long: `
+The "stats" command walks one or multiple snapshots in a repository and
+accumulates statistics about the data stored therein. It reports on the number
+of unique files and their sizes, according to one of the counting modes as
+given by the --mode flag.
+
+When no snapshot ID is given, all snapshots matching the host, tag and path
+filter criteria are considered.
+
+The modes are:
+
+* restore-size: (default) Counts the size of the restored files.
+* files-by-contents: Counts total size of files, where a file is considered
+  unique if it has unique contents.
+* raw-data: Counts the size of the blobs in the repository, regardless of how
+  many files reference them.
+* blobs-per-file: A combination of files-by-contents and raw-data.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runStats(statsOptions, globalOptions, args)
+	},
+}
+
+// StatsOptions bundles all options for the stats command.
+type StatsOptions struct {
+	Host  string
+	Tags  restic.TagLists
+	Paths []string
+	Mode  string
+}
+
+var statsOptions StatsOptions
+
+// The counting modes supported by the stats command.
+const (
+	countModeRestoreSize           = "restore-size"
+	countModeUniqueFilesByContents = "files-by-contents"
+	countModeRawData               = "raw-data"
+	countModeBlobsPerFile          = "blobs-per-file"
+)
+
+func init() {
+	cmdRoot.AddCommand(cmdStats)
+
+	f := cmdStats.Flags()
+	f.StringVar(&statsOptions.Mode, "mode", countModeRestoreSize, "counting mode: restore-size (default), files-by-contents, blobs-per-file or raw-data")
+	f.StringVarP(&statsOptions.Host, "host", "H", "", "only consider snapshots for this `host`, when no snapshot ID is given")
+	f.Var(&statsOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot ID is given")
+	f.StringArrayVar(&statsOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot ID is given")
+}
+
+// statsContainer accumulates the statistics for the selected snapshots.
+type statsContainer struct {
+	TotalSize      uint64 `json:"total_size"`
+	TotalFileCount uint64 `json:"total_file_count"`
+	TotalBlobCount uint64 `json:"total_blob_count,omitempty"`
+	SnapshotsCount int    `json:"snapshots_count"`
+
+	// blobs is used to count each blob only once in the raw-data and
+	// blobs-per-file modes
+	blobs restic.BlobSet
+
+	// fileContents is used to count each file only once in the
+	// files-by-contents and blobs-per-file modes, keyed by the list of
+	// content blob IDs
+	fileContents map[string]struct{}
+
+	// seenTrees holds the trees which have already been processed in the
+	// raw-data mode
+	seenTrees restic.BlobSet
+}
+
+func newStatsContainer() *statsContainer {
+	return &statsContainer{
+		blobs:        restic.NewBlobSet(),
+		fileContents: make(map[string]struct{}),
+		seenTrees:    restic.NewBlobSet(),
+	}
+}
+
+func runStats(opts StatsOptions, gopts GlobalOptions, args []string) error {
+	switch opts.Mode {
+	case countModeRestoreSize, countModeUniqueFilesByContents, countModeRawData, countModeBlobsPerFile:
+	default:
+		return errors.Fatalf("unknown counting mode: %q (use the --help flag to view a list of supported modes)", opts.Mode)
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	if !gopts.JSON {
+		Printf("scanning...\n")
+	}
+
+	stats := newStatsContainer()
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, args) {
+		if sn.Tree == nil {
+			return errors.Errorf("snapshot %v has no tree", sn.ID().Str())
+		}
+
+		err = statsWalkSnapshot(ctx, repo, opts.Mode, sn, stats)
+		if err != nil {
+			return errors.Fatalf("error walking snapshot %v: %v", sn.ID().Str(), err)
+		}
+		stats.SnapshotsCount++
+	}
+
+	if opts.Mode == countModeRawData || opts.Mode == countModeBlobsPerFile {
+		// the blob sizes are only summed up once all snapshots have been
+		// processed, so that blobs shared by several snapshots are only
+		// counted once
+		for h := range stats.blobs {
+			size, found := repo.LookupBlobSize(h.ID, h.Type)
+			if !found {
+				return errors.Errorf("blob %v not found in the index", h)
+			}
+			stats.TotalSize += uint64(size)
+			stats.TotalBlobCount++
+		}
+	}
+
+	if gopts.JSON {
+		err = json.NewEncoder(gopts.stdout).Encode(stats)
+		if err != nil {
+			return errors.Fatalf("encoding output: %v", err)
+		}
+		return nil
+	}
+
+	Printf("Stats for %d snapshots in %s mode:\n", stats.SnapshotsCount, opts.Mode)
+	if stats.TotalBlobCount > 0 {
+		Printf("   Total Blob Count:  %d\n", stats.TotalBlobCount)
+	}
+	if stats.TotalFileCount > 0 {
+		Printf("   Total File Count:  %d\n", stats.TotalFileCount)
+	}
+	Printf("         Total Size:  %-5s\n", formatBytes(stats.TotalSize))
+
+	return nil
+}
+
+// statsWalkSnapshot adds the statistics for the snapshot sn to stats.
+func statsWalkSnapshot(ctx context.Context, repo *repository.Repository, mode string, sn *restic.Snapshot, stats *statsContainer) error {
+	if mode == countModeRawData {
+		// only the blobs are relevant, so there is no need to walk the tree
+		// node by node
+		return restic.FindUsedBlobs(ctx, repo, *sn.Tree, stats.blobs, stats.seenTrees)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan walk.TreeJob)
+	go walk.Tree(ctx, repo, *sn.Tree, jobs)
+
+	for job := range jobs {
+		if job.Error != nil {
+			return errors.Wrapf(job.Error, "walk %v", job.Path)
+		}
+
+		if job.Node == nil || job.Node.Type != "file" {
+			continue
+		}
+
+		node := job.Node
+		switch mode {
+		case countModeRestoreSize:
+			stats.TotalFileCount++
+			stats.TotalSize += node.Size
+
+		case countModeUniqueFilesByContents, countModeBlobsPerFile:
+			key := makeFileContentsKey(node.Content)
+			if _, ok := stats.fileContents[key]; ok {
+				continue
+			}
+			stats.fileContents[key] = struct{}{}
+			stats.TotalFileCount++
+
+			if mode == countModeUniqueFilesByContents {
+				stats.TotalSize += node.Size
+				continue
+			}
+
+			for _, id := range node.Content {
+				stats.blobs.Insert(restic.BlobHandle{ID: id, Type: restic.DataBlob})
+			}
+		}
+	}
+
+	return nil
+}
+
+// makeFileContentsKey returns a key which uniquely identifies a file by the
+// list of blobs it consists of.
+func makeFileContentsKey(content restic.IDs) string {
+	buf := make([]byte, 0, len(content)*len(restic.ID{}))
+	for _, id := range content {
+		buf = append(buf, id[:]...)
+	}
+	return string(buf)
+}
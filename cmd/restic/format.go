@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 )
 
@@ -26,6 +29,40 @@ func formatBytes(c uint64) string {
 	}
 }
 
+// parseSizeStr parses a size in bytes, which may have one of the suffixes
+// K, M, G or T (case insensitive) for KiB, MiB, GiB and TiB.
+func parseSizeStr(s string) (uint64, error) {
+	var unit uint64 = 1
+	if len(s) > 0 {
+		switch strings.ToUpper(s[len(s)-1:]) {
+		case "K":
+			unit = 1 << 10
+		case "M":
+			unit = 1 << 20
+		case "G":
+			unit = 1 << 30
+		case "T":
+			unit = 1 << 40
+		}
+	}
+
+	num := s
+	if unit != 1 {
+		num = s[:len(s)-1]
+	}
+
+	value, err := strconv.ParseUint(num, 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid size %q", s)
+	}
+
+	if value > (1<<64-1)/unit {
+		return 0, errors.Errorf("size %q is too large", s)
+	}
+
+	return value * unit, nil
+}
+
 func formatSeconds(sec uint64) string {
 	hours := sec / 3600
 	sec -= hours * 3600
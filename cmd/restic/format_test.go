@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseSizeStr(t *testing.T) {
+	var tests = []struct {
+		input string
+		size  uint64
+	}{
+		{"1024", 1024},
+		{"512K", 512 << 10},
+		{"512k", 512 << 10},
+		{"8M", 8 << 20},
+		{"2G", 2 << 30},
+		{"1T", 1 << 40},
+	}
+
+	for _, test := range tests {
+		size, err := parseSizeStr(test.input)
+		if err != nil {
+			t.Errorf("parseSizeStr(%q) returned error: %v", test.input, err)
+			continue
+		}
+
+		if size != test.size {
+			t.Errorf("parseSizeStr(%q) = %d, want %d", test.input, size, test.size)
+		}
+	}
+
+	for _, input := range []string{"", "K", "1.5M", "-1", "10X", "99999999999T"} {
+		_, err := parseSizeStr(input)
+		if err == nil {
+			t.Errorf("parseSizeStr(%q) did not return an error", input)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -18,15 +19,21 @@ import (
 	"github.com/restic/restic/internal/backend/gs"
 	"github.com/restic/restic/internal/backend/local"
 	"github.com/restic/restic/internal/backend/location"
+	"github.com/restic/restic/internal/backend/mirror"
+	"github.com/restic/restic/internal/backend/rclone"
 	"github.com/restic/restic/internal/backend/rest"
 	"github.com/restic/restic/internal/backend/s3"
 	"github.com/restic/restic/internal/backend/sftp"
+	"github.com/restic/restic/internal/backend/staging"
 	"github.com/restic/restic/internal/backend/swift"
+	"github.com/restic/restic/internal/backend/webdav"
 	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/limiter"
+	"github.com/restic/restic/internal/metrics"
 	"github.com/restic/restic/internal/options"
+	"github.com/restic/restic/internal/parity"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 
@@ -53,8 +60,22 @@ type GlobalOptions struct {
 	LimitUploadKb   int
 	LimitDownloadKb int
 
+	PackSize uint
+
+	RetryMaxTries       uint
+	RetryMaxTime        time.Duration
+	RetryMinBackoff     time.Duration
+	RetryMaxBackoff     time.Duration
+	RetryCircuitBreaker time.Duration
+
+	StagingDir string
+
+	MetricsFile   string
+	MetricsListen string
+
 	ctx      context.Context
 	password string
+	metrics  *metrics.Metrics
 	stdout   io.Writer
 	stderr   io.Writer
 
@@ -90,10 +111,36 @@ func init() {
 	f.IntVar(&globalOptions.LimitUploadKb, "limit-upload", 0, "limits uploads to a maximum rate in KiB/s. (default: unlimited)")
 	f.IntVar(&globalOptions.LimitDownloadKb, "limit-download", 0, "limits downloads to a maximum rate in KiB/s. (default: unlimited)")
 	f.StringSliceVarP(&globalOptions.Options, "option", "o", []string{}, "set extended option (`key=value`, can be specified multiple times)")
+	f.UintVar(&globalOptions.PackSize, "pack-size", envUint("RESTIC_PACK_SIZE"), "set target pack `size` in MiB, between 1 and 128 (default: $RESTIC_PACK_SIZE or 4)")
+	f.UintVar(&globalOptions.RetryMaxTries, "retry-max-tries", defaultRetryMaxTries, "retry a failed backend request at most `n` times, 0 means no limit")
+	f.DurationVar(&globalOptions.RetryMaxTime, "retry-max-time", 15*time.Minute, "stop retrying a failed backend request after `duration`")
+	f.DurationVar(&globalOptions.RetryMinBackoff, "retry-min-backoff", 500*time.Millisecond, "wait at least `duration` before retrying a failed backend request")
+	f.DurationVar(&globalOptions.RetryMaxBackoff, "retry-max-backoff", time.Minute, "wait at most `duration` before retrying a failed backend request")
+	f.DurationVar(&globalOptions.RetryCircuitBreaker, "retry-circuit-breaker", 5*time.Minute, "abort when all backend requests failed for `duration`, 0 disables it")
+	f.StringVar(&globalOptions.MetricsFile, "metrics-file", "", "write metrics about the backend requests in Prometheus text format to `file` at exit")
+	f.StringVar(&globalOptions.MetricsListen, "metrics-listen", "", "serve metrics about the backend requests in Prometheus text format at `address`")
+	f.StringVar(&globalOptions.StagingDir, "staging-dir", os.Getenv("RESTIC_STAGING_DIR"), "save new files in `directory` while the repository is unreachable (default: $RESTIC_STAGING_DIR)")
 
 	restoreTerminal()
 }
 
+// envUint returns the value of the environment variable name parsed as an
+// unsigned integer. If the variable is not set or invalid, zero is returned.
+func envUint(name string) uint {
+	s := os.Getenv(name)
+	if s == "" {
+		return 0
+	}
+
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value %q for %v: %v\n", s, name, err)
+		return 0
+	}
+
+	return uint(v)
+}
+
 // checkErrno returns nil when err is set to syscall.Errno(0), since this is no
 // error condition.
 func checkErrno(err error) error {
@@ -319,23 +366,72 @@ func ReadPasswordTwice(gopts GlobalOptions, prompt1, prompt2 string) (string, er
 
 const maxKeys = 20
 
-// OpenRepository reads the password and opens the repository.
-func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
+// defaultRetryMaxTries is the default number of retries for a failed backend
+// request.
+const defaultRetryMaxTries = 10
+
+// retryPolicy returns the policy for retrying failed backend requests.
+func retryPolicy(opts GlobalOptions) backend.RetryPolicy {
+	return backend.RetryPolicy{
+		MaxTries:       int(opts.RetryMaxTries),
+		MaxElapsedTime: opts.RetryMaxTime,
+		MinBackoff:     opts.RetryMinBackoff,
+		MaxBackoff:     opts.RetryMaxBackoff,
+		BreakAfter:     opts.RetryCircuitBreaker,
+	}
+}
+
+// newRetryBackend wraps be so that failed requests are retried.
+func newRetryBackend(be restic.Backend, opts GlobalOptions) restic.Backend {
+	return backend.NewRetryBackend(be, retryPolicy(opts), func(msg string, err error, d time.Duration) {
+		Warnf("%v returned error, retrying after %v: %v\n", msg, d, err)
+	})
+}
+
+// newRepository opens the backend and returns a repository for it, the
+// repository still needs to be opened with a key.
+func newRepository(opts GlobalOptions) (*repository.Repository, error) {
 	if opts.Repo == "" {
 		return nil, errors.Fatal("Please specify repository location (-r)")
 	}
 
-	be, err := open(opts.Repo, opts, opts.extended)
+	var be restic.Backend
+	var err error
+	if opts.StagingDir != "" {
+		be, err = openStaging(opts.Repo, opts, opts.extended)
+	} else {
+		be, err = open(opts.Repo, opts, opts.extended)
+		if err == nil && opts.metrics != nil {
+			be = metrics.NewBackend(be, opts.metrics)
+		}
+		if err == nil {
+			be = newRetryBackend(be, opts)
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	be = backend.NewRetryBackend(be, 10, func(msg string, err error, d time.Duration) {
-		Warnf("%v returned error, retrying after %v: %v\n", msg, d, err)
-	})
-
 	s := repository.New(be)
 
+	if opts.PackSize != 0 {
+		err = s.SetPackSize(opts.PackSize * 1024 * 1024)
+		if err != nil {
+			return nil, errors.Fatalf("invalid pack size %d MiB, must be between %d and %d MiB",
+				opts.PackSize, repository.MinPackSize/(1024*1024), repository.MaxPackSize/(1024*1024))
+		}
+	}
+
+	return s, nil
+}
+
+// OpenRepository reads the password and opens the repository.
+func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
+	s, err := newRepository(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	opts.password, err = ReadPassword(opts, "enter password for repository: ")
 	if err != nil {
 		return nil, err
@@ -350,6 +446,10 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 		Verbosef("password is correct\n")
 	}
 
+	if shards := s.Config().ParityShards; shards > 0 && !setParityShards(s.Backend(), int(shards)) {
+		Warnf("the repository uses parity data, which is only written for local and sftp backends\n")
+	}
+
 	if opts.NoCache {
 		return s, nil
 	}
@@ -523,6 +623,27 @@ func parseConfig(loc location.Location, opts options.Options) (interface{}, erro
 
 		debug.Log("opening rest repository at %#v", cfg)
 		return cfg, nil
+	case "rclone":
+		cfg := loc.Config.(rclone.Config)
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening rclone repository at %#v", cfg)
+		return cfg, nil
+	case "webdav":
+		cfg := loc.Config.(webdav.Config)
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening webdav repository at %#v", cfg)
+		return cfg, nil
+	case "mirror":
+		// the options are applied to the locations of the members
+		cfg := loc.Config.(mirror.Config)
+		debug.Log("opening mirror repository at %#v", cfg)
+		return cfg, nil
 	}
 
 	return nil, errors.Fatalf("invalid backend: %q", loc.Scheme)
@@ -530,6 +651,120 @@ func parseConfig(loc location.Location, opts options.Options) (interface{}, erro
 
 // Open the backend specified by a location config.
 func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend, error) {
+	be, err := openBackend(s, gopts, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return checkConfig(s, be)
+}
+
+// checkConfig checks that be contains a repository config. If not, be is
+// closed and an error is returned.
+func checkConfig(s string, be restic.Backend) (restic.Backend, error) {
+	fi, err := be.Stat(globalOptions.ctx, restic.Handle{Type: restic.ConfigFile})
+	if err != nil {
+		_ = be.Close()
+		return nil, errors.Fatalf("unable to open config file: %v\nIs there a repository at the following location?\n%v", err, s)
+	}
+
+	if fi.Size == 0 {
+		_ = be.Close()
+		return nil, errors.New("config file has zero size, invalid repository?")
+	}
+
+	return be, nil
+}
+
+// openStaging opens the backend specified by s like open, but wraps it so
+// that new files are saved in the staging directory while the backend is
+// unreachable. Whether the backend is reachable is tested once without
+// retries, afterwards requests to the backend are retried before the staging
+// directory is used.
+func openStaging(s string, gopts GlobalOptions, opts options.Options) (restic.Backend, error) {
+	var probe restic.Backend
+	be, err := openBackend(s, gopts, opts)
+	if err != nil {
+		Warnf("%v\n", err)
+		be = staging.Unreachable(s, err)
+	} else {
+		probe = be
+
+		// record metrics only for the requests which reach the backend
+		if gopts.metrics != nil {
+			be = metrics.NewBackend(be, gopts.metrics)
+		}
+		be = newRetryBackend(be, gopts)
+	}
+
+	sb, err := staging.New(gopts.ctx, be, probe, gopts.StagingDir, s)
+	if err != nil {
+		_ = be.Close()
+		return nil, errors.Fatalf("unable to use staging directory: %v", err)
+	}
+
+	if !sb.Online() {
+		Warnf("repository is unreachable, new files are saved in the staging directory %v\n", gopts.StagingDir)
+	}
+
+	return checkConfig(s, sb)
+}
+
+// innerBackend returns the backend wrapped by be, or nil if be does not wrap
+// another backend.
+func innerBackend(be restic.Backend) restic.Backend {
+	switch b := be.(type) {
+	case *backend.RetryBackend:
+		return b.Backend
+	case *cache.Backend:
+		return b.Backend
+	case *staging.Backend:
+		return b.Backend
+	case *metrics.Backend:
+		return b.Backend
+	case *parity.Backend:
+		return b.Backend
+	}
+	return limiter.Unwrap(be)
+}
+
+// setParityShards sets the number of parity shards for all parity backends
+// used by be, including those of mirrored repositories. It returns false if
+// no parity backend was found.
+func setParityShards(be restic.Backend, shards int) bool {
+	for be != nil {
+		switch b := be.(type) {
+		case *parity.Backend:
+			b.ParityShards = shards
+			return true
+		case *mirror.Backend:
+			found := false
+			for _, m := range b.Members() {
+				if setParityShards(m, shards) {
+					found = true
+				}
+			}
+			return found
+		}
+		be = innerBackend(be)
+	}
+	return false
+}
+
+// findStaging returns the staging backend used by be, or nil.
+func findStaging(be restic.Backend) *staging.Backend {
+	for be != nil {
+		if sb, ok := be.(*staging.Backend); ok {
+			return sb
+		}
+		be = innerBackend(be)
+	}
+	return nil
+}
+
+// openBackend opens the backend specified by s without checking that it
+// contains a repository.
+func openBackend(s string, gopts GlobalOptions, opts options.Options) (restic.Backend, error) {
 	debug.Log("parsing location %v", s)
 	loc, err := location.Parse(s)
 	if err != nil {
@@ -560,10 +795,18 @@ func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend,
 		be, err = local.Open(cfg.(local.Config))
 		// wrap the backend in a LimitBackend so that the throughput is limited
 		be = limiter.LimitBackend(be, limiter.NewStaticLimiter(gopts.LimitUploadKb, gopts.LimitDownloadKb))
+		if err == nil {
+			// the number of parity shards is set from the config once the
+			// repository is opened
+			be, err = parity.NewBackend(be, 0)
+		}
 	case "sftp":
 		be, err = sftp.Open(cfg.(sftp.Config))
 		// wrap the backend in a LimitBackend so that the throughput is limited
 		be = limiter.LimitBackend(be, limiter.NewStaticLimiter(gopts.LimitUploadKb, gopts.LimitDownloadKb))
+		if err == nil {
+			be, err = parity.NewBackend(be, 0)
+		}
 	case "s3":
 		be, err = s3.Open(cfg.(s3.Config), rt)
 	case "gs":
@@ -576,6 +819,12 @@ func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend,
 		be, err = b2.Open(globalOptions.ctx, cfg.(b2.Config), rt)
 	case "rest":
 		be, err = rest.Open(cfg.(rest.Config), rt)
+	case "rclone":
+		be, err = rclone.Open(cfg.(rclone.Config))
+	case "webdav":
+		be, err = webdav.Open(cfg.(webdav.Config), rt)
+	case "mirror":
+		be, err = openMirror(cfg.(mirror.Config), gopts, opts)
 
 	default:
 		return nil, errors.Fatalf("invalid backend: %q", loc.Scheme)
@@ -585,17 +834,41 @@ func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend,
 		return nil, errors.Fatalf("unable to open repo at %v: %v", s, err)
 	}
 
-	// check if config is there
-	fi, err := be.Stat(globalOptions.ctx, restic.Handle{Type: restic.ConfigFile})
-	if err != nil {
-		return nil, errors.Fatalf("unable to open config file: %v\nIs there a repository at the following location?\n%v", err, s)
+	return be, nil
+}
+
+// openMirror opens the backends for all locations in cfg.
+func openMirror(cfg mirror.Config, gopts GlobalOptions, opts options.Options) (restic.Backend, error) {
+	var members []restic.Backend
+	for _, loc := range cfg.Locations {
+		be, err := openBackend(loc, gopts, opts)
+		if err != nil {
+			for _, m := range members {
+				_ = m.Close()
+			}
+			return nil, err
+		}
+		members = append(members, be)
 	}
 
-	if fi.Size == 0 {
-		return nil, errors.New("config file has zero size, invalid repository?")
+	return mirror.New(members...)
+}
+
+// createMirror creates the backends for all locations in cfg.
+func createMirror(cfg mirror.Config, opts options.Options) (restic.Backend, error) {
+	var members []restic.Backend
+	for _, loc := range cfg.Locations {
+		be, err := create(loc, opts)
+		if err != nil {
+			for _, m := range members {
+				_ = m.Close()
+			}
+			return nil, errors.Fatalf("unable to create repo at %v: %v", loc, err)
+		}
+		members = append(members, be)
 	}
 
-	return be, nil
+	return mirror.New(members...)
 }
 
 // Create the backend specified by URI.
@@ -637,6 +910,12 @@ func create(s string, opts options.Options) (restic.Backend, error) {
 		return b2.Create(globalOptions.ctx, cfg.(b2.Config), rt)
 	case "rest":
 		return rest.Create(cfg.(rest.Config), rt)
+	case "rclone":
+		return rclone.Create(cfg.(rclone.Config))
+	case "webdav":
+		return webdav.Create(cfg.(webdav.Config), rt)
+	case "mirror":
+		return createMirror(cfg.(mirror.Config), opts)
 	}
 
 	debug.Log("invalid repository scheme: %v", s)
@@ -215,6 +215,8 @@ func withTestEnvironment(t testing.TB) (env *testEnvironment, cleanup func()) {
 		stdout:   os.Stdout,
 		stderr:   os.Stderr,
 		extended: make(options.Options),
+		// use the default of the flag, zero would retry without a limit
+		RetryMaxTries: defaultRetryMaxTries,
 	}
 
 	// always overwrite global options
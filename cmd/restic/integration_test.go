@@ -17,6 +17,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
@@ -43,13 +44,113 @@ func parseIDsFromReader(t testing.TB, rd io.Reader) restic.IDs {
 }
 
 func testRunInit(t testing.TB, opts GlobalOptions) {
+	testRunInitVersion(t, restic.RepoVersion, opts)
+}
+
+func testRunInitVersion(t testing.TB, version uint, opts GlobalOptions) {
 	repository.TestUseLowSecurityKDFParameters(t)
 	restic.TestSetLockTimeout(t, 0)
 
-	rtest.OK(t, runInit(opts, nil))
+	rtest.OK(t, runInit(InitOptions{RepositoryVersion: version}, opts, nil))
 	t.Logf("repository initialized at %v", opts.Repo)
 }
 
+func TestInitCopyChunkerParams(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	testRunInit(t, env2.gopts)
+
+	initOpts := InitOptions{
+		secondaryRepoOptions: secondaryRepoOptions{
+			Repo:     env2.gopts.Repo,
+			password: env2.gopts.password,
+		},
+		RepositoryVersion: restic.RepoVersion,
+	}
+
+	// the source repository is only allowed together with --copy-chunker-params
+	rtest.Assert(t, runInit(initOpts, env.gopts, nil) != nil, "expected init to fail without --copy-chunker-params")
+
+	initOpts.CopyChunkerParams = true
+	rtest.OK(t, runInit(initOpts, env.gopts, nil))
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+
+	otherRepo, err := OpenRepository(env2.gopts)
+	rtest.OK(t, err)
+
+	rtest.Assert(t, repo.Config().ChunkerPolynomial == otherRepo.Config().ChunkerPolynomial,
+		"expected equal chunker polynomials, got %v expected %v", repo.Config().ChunkerPolynomial,
+		otherRepo.Config().ChunkerPolynomial)
+	rtest.Assert(t, repo.Config().ID != otherRepo.Config().ID, "expected different repository IDs")
+}
+
+func TestInitChunkSizes(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+	env3, cleanup3 := withTestEnvironment(t)
+	defer cleanup3()
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestSetLockTimeout(t, 0)
+
+	initOpts := InitOptions{
+		AvgChunkSize: "3M",
+	}
+	rtest.Assert(t, runInit(initOpts, env.gopts, nil) != nil, "expected init to fail for an invalid average chunk size")
+
+	// custom chunk sizes are not allowed with older repository versions
+	initOpts.MinChunkSize = "2M"
+	initOpts.AvgChunkSize = "4M"
+	initOpts.MaxChunkSize = "32M"
+	initOpts.RepositoryVersion = 2
+	rtest.Assert(t, runInit(initOpts, env.gopts, nil) != nil, "expected init to fail for repository version 2")
+
+	initOpts.RepositoryVersion = 0
+	rtest.OK(t, runInit(initOpts, env.gopts, nil))
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.ChunkSizes{Min: 2 << 20, Avg: 4 << 20, Max: 32 << 20}, repo.Config().ChunkSizes())
+	rtest.Equals(t, uint(restic.ChunkSizesRepoVersion), repo.Config().Version)
+
+	// explicit chunk sizes must match the source repository
+	initOpts = InitOptions{
+		secondaryRepoOptions: secondaryRepoOptions{
+			Repo:     env.gopts.Repo,
+			password: env.gopts.password,
+		},
+		CopyChunkerParams: true,
+		MaxChunkSize:      "32M",
+	}
+	rtest.Assert(t, runInit(initOpts, env2.gopts, nil) != nil, "expected init to fail for mismatching chunk sizes")
+
+	initOpts.MinChunkSize = "2M"
+	initOpts.AvgChunkSize = "4M"
+	rtest.OK(t, runInit(initOpts, env2.gopts, nil))
+
+	otherRepo, err := OpenRepository(env2.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, repo.Config().ChunkSizes(), otherRepo.Config().ChunkSizes())
+
+	// copying between repositories with different chunk sizes is rejected
+	testRunInit(t, env3.gopts)
+	copyOpts := CopyOptions{
+		secondaryRepoOptions: secondaryRepoOptions{
+			Repo:     env.gopts.Repo,
+			password: env.gopts.password,
+		},
+	}
+	rtest.Assert(t, runCopy(copyOpts, env3.gopts, nil) != nil, "expected copy to fail for different chunk sizes")
+	rtest.OK(t, runCopy(copyOpts, env2.gopts, nil))
+}
+
 func testRunBackup(t testing.TB, target []string, opts BackupOptions, gopts GlobalOptions) {
 	t.Logf("backing up %v", target)
 	rtest.OK(t, runBackup(opts, gopts, target))
@@ -199,6 +300,33 @@ func testRunPrune(t testing.TB, gopts GlobalOptions) {
 	rtest.OK(t, runPrune(gopts))
 }
 
+func TestInitParity(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestSetLockTimeout(t, 0)
+
+	rtest.Assert(t, runInit(InitOptions{Parity: 100}, env.gopts, nil) != nil, "expected init to fail for too many parity shards")
+	rtest.OK(t, runInit(InitOptions{Parity: 2}, env.gopts, nil))
+
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(env.testdata, "file"), rtest.Random(23, 5<<20), 0644))
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	// the number of parity shards is taken from the config, no option is needed
+	parityFiles := 0
+	rtest.OK(t, filepath.Walk(filepath.Join(env.repo, "parity"), func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			parityFiles++
+		}
+		return err
+	}))
+	rtest.Assert(t, parityFiles > 0, "no parity files were written")
+
+	testRunCheck(t, env.gopts)
+}
+
 func TestBackup(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -753,7 +881,7 @@ func testRunKeyListOtherIDs(t testing.TB, gopts GlobalOptions) []string {
 		globalOptions.stdout = os.Stdout
 	}()
 
-	rtest.OK(t, runKey(gopts, []string{"list"}))
+	rtest.OK(t, runKey(KeyOptions{}, gopts, []string{"list"}))
 
 	scanner := bufio.NewScanner(buf)
 	exp := regexp.MustCompile(`^ ([a-f0-9]+) `)
@@ -774,7 +902,7 @@ func testRunKeyAddNewKey(t testing.TB, newPassword string, gopts GlobalOptions)
 		testKeyNewPassword = ""
 	}()
 
-	rtest.OK(t, runKey(gopts, []string{"add"}))
+	rtest.OK(t, runKey(KeyOptions{}, gopts, []string{"add"}))
 }
 
 func testRunKeyPasswd(t testing.TB, newPassword string, gopts GlobalOptions) {
@@ -783,13 +911,13 @@ func testRunKeyPasswd(t testing.TB, newPassword string, gopts GlobalOptions) {
 		testKeyNewPassword = ""
 	}()
 
-	rtest.OK(t, runKey(gopts, []string{"passwd"}))
+	rtest.OK(t, runKey(KeyOptions{}, gopts, []string{"passwd"}))
 }
 
 func testRunKeyRemove(t testing.TB, gopts GlobalOptions, IDs []string) {
 	t.Logf("remove %d keys: %q\n", len(IDs), IDs)
 	for _, id := range IDs {
-		rtest.OK(t, runKey(gopts, []string{"remove", id}))
+		rtest.OK(t, runKey(KeyOptions{}, gopts, []string{"remove", id}))
 	}
 }
 
@@ -817,8 +945,118 @@ func TestKeyAddRemove(t *testing.T) {
 
 	env.gopts.password = passwordList[len(passwordList)-1]
 	t.Logf("testing access with last password %q\n", env.gopts.password)
-	rtest.OK(t, runKey(env.gopts, []string{"list"}))
+	rtest.OK(t, runKey(KeyOptions{}, env.gopts, []string{"list"}))
+	testRunCheck(t, env.gopts)
+}
+
+func TestKeyAddArgon2id(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	oldParams := repository.Params
+	defer func() {
+		repository.Params = oldParams
+	}()
+
+	testRunInit(t, env.gopts)
+
+	testKeyNewPassword = "argon2id password"
+	defer func() {
+		testKeyNewPassword = ""
+	}()
+
+	opts := KeyOptions{kdfOptions: kdfOptions{KDF: "argon2id", Params: "t=1,m=64,p=1", changed: true}}
+	rtest.OK(t, runKey(opts, env.gopts, []string{"add"}))
+
+	opts = KeyOptions{kdfOptions: kdfOptions{KDF: "argon2id", Params: "t=1,x=64", changed: true}}
+	rtest.Assert(t, runKey(opts, env.gopts, []string{"add"}) != nil, "expected an error for invalid KDF parameters")
+
+	env.gopts.password = "argon2id password"
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	rtest.OK(t, runKey(KeyOptions{}, env.gopts, []string{"list"}))
+	rtest.Assert(t, regexp.MustCompile(`(?m)^\*.*argon2id`).MatchString(buf.String()),
+		"current key does not use argon2id:\n%s", buf.String())
+	rtest.Assert(t, strings.Contains(buf.String(), "scrypt"),
+		"key created by init does not use scrypt:\n%s", buf.String())
+}
+
+func TestKeyRotate(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunKeyAddNewKey(t, "other password", env.gopts)
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	oldKey := repo.Key()
+
+	rtest.OK(t, runKey(KeyOptions{}, env.gopts, []string{"rotate"}))
+
+	rtest.Equals(t, 1, len(testRunList(t, "keys", env.gopts)))
+	rtest.Equals(t, 2, len(testRunList(t, "snapshots", env.gopts)))
+
+	repo, err = OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.Assert(t, repo.Key().EncryptionKey != oldKey.EncryptionKey, "master key was not replaced")
+
 	testRunCheck(t, env.gopts)
+
+	snapshotID := testRunList(t, "snapshots", env.gopts)[0]
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotID)
+	rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, "testdata")),
+		"directories are not equal")
+}
+
+func TestBackupWriteOnlyKey(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	testKeyNewPassword = "write-only password"
+	defer func() {
+		testKeyNewPassword = ""
+	}()
+
+	err := runKey(KeyOptions{WriteOnly: true}, env.gopts, []string{"add"})
+	rtest.Assert(t, err != nil, "adding a write-only key to a version 2 repository succeeded")
+
+	testRunMigrate(t, env.gopts, "upgrade_repo_v4")
+	rtest.OK(t, runKey(KeyOptions{WriteOnly: true}, env.gopts, []string{"add"}))
+
+	wopts := env.gopts
+	wopts.password = "write-only password"
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, wopts)
+
+	_, err = testRunCheckOutput(wopts)
+	rtest.Assert(t, err != nil, "check succeeded with a write-only key")
+
+	testRunCheck(t, env.gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Equals(t, 2, len(snapshotIDs))
+	for i, snapshotID := range snapshotIDs {
+		restoredir := filepath.Join(env.base, fmt.Sprintf("restore%d", i))
+		testRunRestore(t, env.gopts, restoredir, snapshotID)
+		rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, "testdata")),
+			"directories are not equal")
+	}
 }
 
 func testFileSize(filename string, size int64) error {
@@ -1214,6 +1452,143 @@ func TestPrune(t *testing.T) {
 	testRunCheck(t, env.gopts)
 }
 
+func TestPruneStaleTempFiles(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	// an upload which is still running and one which has been interrupted
+	running := backend.TempFilename(filepath.Join(env.repo, "index", restic.NewRandomID().String()))
+	rtest.OK(t, ioutil.WriteFile(running, []byte("foo"), 0600))
+	stale := backend.TempFilename(filepath.Join(env.repo, "index", restic.NewRandomID().String()))
+	rtest.OK(t, ioutil.WriteFile(stale, []byte("foo"), 0600))
+	old := time.Now().Add(-2 * backend.StaleTempFileAge)
+	rtest.OK(t, os.Chtimes(stale, old, old))
+
+	// only prune removes the stale temporary file
+	testRunCheck(t, env.gopts)
+	_, err := os.Stat(stale)
+	rtest.OK(t, err)
+
+	testRunPrune(t, env.gopts)
+	_, err = os.Stat(stale)
+	rtest.Assert(t, os.IsNotExist(err), "stale temporary file was not removed")
+	_, err = os.Stat(running)
+	rtest.OK(t, err)
+}
+
+func testRunMigrate(t testing.TB, gopts GlobalOptions, args ...string) {
+	rtest.OK(t, runMigrate(MigrateOptions{}, gopts, args))
+}
+
+func TestMigrateUpgradeRepoV2(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	fd, err := os.Open(datafile)
+	if os.IsNotExist(errors.Cause(err)) {
+		t.Skipf("unable to find data file %q, skipping", datafile)
+		return
+	}
+	rtest.OK(t, err)
+	rtest.OK(t, fd.Close())
+
+	testRunInitVersion(t, 1, env.gopts)
+
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	opts := BackupOptions{}
+
+	testRunBackup(t, []string{filepath.Join(env.testdata, "0", "0")}, opts, env.gopts)
+	testRunMigrate(t, env.gopts, "upgrade_repo_v2")
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(2), repo.Config().Version)
+
+	// the second backup adds compressed blobs to the repository
+	testRunBackup(t, []string{env.testdata}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2,
+		"expected two snapshots, got %v", snapshotIDs)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestoreLatest(t, env.gopts, restoredir, []string{env.testdata}, "")
+	rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, "testdata")),
+		"directories are not equal")
+}
+
+func testRunCopy(t testing.TB, srcGopts GlobalOptions, dstGopts GlobalOptions) {
+	copyOpts := CopyOptions{
+		secondaryRepoOptions: secondaryRepoOptions{
+			Repo:     srcGopts.Repo,
+			password: srcGopts.password,
+		},
+	}
+
+	rtest.OK(t, runCopy(copyOpts, dstGopts, nil))
+}
+
+func TestCopy(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	fd, err := os.Open(datafile)
+	if os.IsNotExist(errors.Cause(err)) {
+		t.Skipf("unable to find data file %q, skipping", datafile)
+		return
+	}
+	rtest.OK(t, err)
+	rtest.OK(t, fd.Close())
+
+	testRunInit(t, env.gopts)
+	testRunInit(t, env2.gopts)
+
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	opts := BackupOptions{}
+
+	testRunBackup(t, []string{filepath.Join(env.testdata, "0", "0")}, opts, env.gopts)
+	testRunBackup(t, []string{filepath.Join(env.testdata, "0", "0", "1")}, opts, env.gopts)
+	testRunBackup(t, []string{env.testdata}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	testRunCopy(t, env.gopts, env2.gopts)
+	testRunCheck(t, env2.gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	copiedSnapshotIDs := testRunList(t, "snapshots", env2.gopts)
+	rtest.Assert(t, len(snapshotIDs) == len(copiedSnapshotIDs),
+		"expected %v snapshots, found %v", len(snapshotIDs), len(copiedSnapshotIDs))
+
+	// all copied snapshots must point to their originals
+	originals := restic.NewIDSet(snapshotIDs...)
+	for _, id := range copiedSnapshotIDs {
+		repo, err := OpenRepository(env2.gopts)
+		rtest.OK(t, err)
+		sn, err := restic.LoadSnapshot(env2.gopts.ctx, repo, id)
+		rtest.OK(t, err)
+		rtest.Assert(t, sn.Original != nil && originals.Has(*sn.Original),
+			"copied snapshot %v does not reference an original snapshot", id.Str())
+	}
+
+	restoredir := filepath.Join(env2.base, "restore")
+	testRunRestoreLatest(t, env2.gopts, restoredir, []string{env.testdata}, "")
+	rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, "testdata")),
+		"directories are not equal")
+
+	// copying again must not create new snapshots
+	testRunCopy(t, env.gopts, env2.gopts)
+	copiedSnapshotIDs = testRunList(t, "snapshots", env2.gopts)
+	rtest.Assert(t, len(snapshotIDs) == len(copiedSnapshotIDs),
+		"expected %v snapshots after second copy, found %v", len(snapshotIDs), len(copiedSnapshotIDs))
+}
+
 func TestHardLink(t *testing.T) {
 	// this test assumes a test set with a single directory containing hard linked files
 	env, cleanup := withTestEnvironment(t)
@@ -1311,3 +1686,255 @@ func linkEqual(source, dest []string) bool {
 
 	return true
 }
+
+func testRunStats(t testing.TB, mode string, gopts GlobalOptions) statsContainer {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	globalOptions.JSON = true
+	defer func() {
+		globalOptions.stdout = os.Stdout
+		globalOptions.JSON = gopts.JSON
+	}()
+
+	opts := StatsOptions{Mode: mode}
+	rtest.OK(t, runStats(opts, globalOptions, nil))
+
+	var stats statsContainer
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &stats))
+	return stats
+}
+
+func TestStats(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	dir := filepath.Join(env.testdata, "dir")
+	rtest.OK(t, os.MkdirAll(dir, 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(dir, "a"), 5000))
+	rtest.OK(t, appendRandomData(filepath.Join(dir, "b"), 3000))
+
+	// c has the same contents as a
+	data, err := ioutil.ReadFile(filepath.Join(dir, "a"))
+	rtest.OK(t, err)
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(dir, "c"), data, 0644))
+
+	testRunBackup(t, []string{dir}, BackupOptions{}, env.gopts)
+	testRunBackup(t, []string{dir}, BackupOptions{}, env.gopts)
+
+	stats := testRunStats(t, countModeRestoreSize, env.gopts)
+	rtest.Equals(t, 2, stats.SnapshotsCount)
+	rtest.Equals(t, uint64(6), stats.TotalFileCount)
+	rtest.Equals(t, uint64(2*13000), stats.TotalSize)
+
+	stats = testRunStats(t, countModeUniqueFilesByContents, env.gopts)
+	rtest.Equals(t, uint64(2), stats.TotalFileCount)
+	rtest.Equals(t, uint64(8000), stats.TotalSize)
+
+	stats = testRunStats(t, countModeBlobsPerFile, env.gopts)
+	rtest.Equals(t, uint64(2), stats.TotalFileCount)
+	rtest.Equals(t, uint64(8000), stats.TotalSize)
+	rtest.Equals(t, uint64(2), stats.TotalBlobCount)
+
+	// raw-data also counts the tree blobs
+	stats = testRunStats(t, countModeRawData, env.gopts)
+	rtest.Assert(t, stats.TotalBlobCount > 2, "expected tree blobs to be counted, got %d blobs", stats.TotalBlobCount)
+	rtest.Assert(t, stats.TotalSize > 8000, "expected tree blobs to be counted, got %d bytes", stats.TotalSize)
+}
+
+func testRunRewrite(t testing.TB, opts RewriteOptions, gopts GlobalOptions) {
+	rtest.OK(t, runRewrite(opts, gopts, nil))
+}
+
+func TestRewrite(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	dir := filepath.Join(env.testdata, "dir")
+	rtest.OK(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(dir, "file"), 1000))
+	rtest.OK(t, appendRandomData(filepath.Join(dir, "secret.env"), 100))
+	rtest.OK(t, appendRandomData(filepath.Join(dir, "sub", "secret.env"), 100))
+
+	testRunBackup(t, []string{dir}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	containsSecrets := func(id restic.ID) bool {
+		for _, line := range testRunLs(t, env.gopts, id.String()) {
+			if strings.HasSuffix(line, ".env") {
+				return true
+			}
+		}
+		return false
+	}
+	rtest.Assert(t, containsSecrets(snapshotIDs[0]), "secrets missing in the original snapshot")
+
+	// a dry run must not modify the repository
+	testRunRewrite(t, RewriteOptions{Excludes: []string{"*.env"}, DryRun: true}, env.gopts)
+	rtest.Equals(t, snapshotIDs, testRunList(t, "snapshots", env.gopts))
+
+	testRunRewrite(t, RewriteOptions{Excludes: []string{"*.env"}, Forget: true}, env.gopts)
+	newSnapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(newSnapshotIDs) == 1, "expected one snapshot, got %v", newSnapshotIDs)
+	rtest.Assert(t, !newSnapshotIDs[0].Equal(snapshotIDs[0]), "old snapshot was not removed")
+	rtest.Assert(t, !containsSecrets(newSnapshotIDs[0]), "secrets still present in the rewritten snapshot")
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	sn, err := restic.LoadSnapshot(env.gopts.ctx, repo, newSnapshotIDs[0])
+	rtest.OK(t, err)
+	rtest.Assert(t, sn.Original != nil && sn.Original.Equal(snapshotIDs[0]),
+		"rewritten snapshot does not reference the original snapshot")
+
+	// the blobs of the removed files are still stored in the repository
+	rtest.OK(t, runCheck(CheckOptions{ReadData: true}, env.gopts, nil))
+}
+
+func testRunRepairSnapshots(t testing.TB, opts RepairSnapshotsOptions, gopts GlobalOptions) {
+	globalOptions.stdout = ioutil.Discard
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	rtest.OK(t, runRepairSnapshots(opts, gopts, nil))
+}
+
+func TestRepairSnapshots(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	dir := filepath.Join(env.testdata, "dir")
+	rtest.OK(t, os.MkdirAll(dir, 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(dir, "a"), 1000))
+	testRunBackup(t, []string{dir}, BackupOptions{}, env.gopts)
+	firstSnapshotIDs := testRunList(t, "snapshots", env.gopts)
+
+	rtest.OK(t, appendRandomData(filepath.Join(dir, "b"), 1000))
+	testRunBackup(t, []string{dir}, BackupOptions{}, env.gopts)
+
+	// remove the pack which contains the content of the new file
+	data, err := ioutil.ReadFile(filepath.Join(dir, "b"))
+	rtest.OK(t, err)
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(env.gopts.ctx))
+	blobs, found := repo.Index().Lookup(restic.Hash(data), restic.DataBlob)
+	rtest.Assert(t, found, "blob for file b not found in the index")
+	h := restic.Handle{Type: restic.DataFile, Name: blobs[0].PackID.String()}
+	rtest.OK(t, repo.Backend().Remove(env.gopts.ctx, h))
+
+	testRunRebuildIndex(t, env.gopts)
+	testRunRepairSnapshots(t, RepairSnapshotsOptions{Forget: true}, env.gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots, got %v", snapshotIDs)
+
+	for _, id := range snapshotIDs {
+		sn, err := restic.LoadSnapshot(env.gopts.ctx, repo, id)
+		rtest.OK(t, err)
+
+		if id.Equal(firstSnapshotIDs[0]) {
+			// the first snapshot is not damaged
+			rtest.Assert(t, !sn.HasTags([]string{repairedTag}), "undamaged snapshot was repaired")
+			continue
+		}
+
+		rtest.Assert(t, sn.HasTags([]string{repairedTag}), "repaired snapshot is not tagged: %v", sn.Tags)
+		rtest.Assert(t, sn.Original != nil, "repaired snapshot does not reference the original snapshot")
+
+		files := testRunLs(t, env.gopts, id.String())
+		rtest.Assert(t, includes(files, filepath.FromSlash("/dir/a")), "file a missing in repaired snapshot: %v", files)
+		rtest.Assert(t, !includes(files, filepath.FromSlash("/dir/b")), "file b still present in repaired snapshot: %v", files)
+	}
+
+	rtest.OK(t, runCheck(CheckOptions{ReadData: true}, env.gopts, nil))
+}
+
+func TestRepairPacks(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	dir := filepath.Join(env.testdata, "dir")
+	rtest.OK(t, os.MkdirAll(dir, 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(dir, "a"), 1000))
+	rtest.OK(t, appendRandomData(filepath.Join(dir, "b"), 1000))
+	testRunBackup(t, []string{dir}, BackupOptions{}, env.gopts)
+
+	// damage the header of the pack which contains the file contents
+	data, err := ioutil.ReadFile(filepath.Join(dir, "a"))
+	rtest.OK(t, err)
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(env.gopts.ctx))
+	blobs, found := repo.Index().Lookup(restic.Hash(data), restic.DataBlob)
+	rtest.Assert(t, found, "blob for file a not found in the index")
+	packID := blobs[0].PackID
+
+	h := restic.Handle{Type: restic.DataFile, Name: packID.String()}
+	buf, err := backend.LoadAll(env.gopts.ctx, repo.Backend(), h)
+	rtest.OK(t, err)
+	buf[len(buf)-10] ^= 0xff
+	rtest.OK(t, repo.Backend().Remove(env.gopts.ctx, h))
+	rtest.OK(t, repo.Backend().Save(env.gopts.ctx, h, restic.NewByteReader(buf)))
+
+	_, err = testRunCheckOutput(env.gopts)
+	rtest.Assert(t, err != nil, "check did not detect the damaged pack")
+
+	globalOptions.stdout = ioutil.Discard
+	err = runRepairPacks(env.gopts, []string{packID.String()})
+	globalOptions.stdout = os.Stdout
+	rtest.OK(t, err)
+
+	packs := restic.NewIDSet(testRunList(t, "packs", env.gopts)...)
+	rtest.Assert(t, !packs.Has(packID), "damaged pack %v was not removed", packID.Str())
+
+	// all blobs were salvaged
+	testRunCheck(t, env.gopts)
+}
+
+func TestFlushStagingMissingPack(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	sopts := env.gopts
+	sopts.StagingDir = filepath.Join(env.base, "staging")
+
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "file1"), 1024*1024))
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, sopts)
+
+	// save a second snapshot in the staging directory while the repository
+	// is unreachable, it references the packs of the first one
+	moved := env.repo + "-moved"
+	rtest.OK(t, os.Rename(env.repo, moved))
+	rtest.OK(t, ioutil.WriteFile(env.repo, []byte("unreachable"), 0600))
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "file2"), 1024*1024))
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, sopts)
+	rtest.OK(t, os.Remove(env.repo))
+	rtest.OK(t, os.Rename(moved, env.repo))
+
+	// remove the packs of the first snapshot, like prune run by another host
+	err := filepath.Walk(filepath.Join(env.repo, "data"), func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		return os.Remove(p)
+	})
+	rtest.OK(t, err)
+
+	err = runFlushStaging(sopts)
+	rtest.Assert(t, err != nil, "flushing snapshots which reference removed packs succeeded")
+
+	// nothing has been uploaded
+	rtest.Equals(t, 1, len(testRunList(t, "snapshots", env.gopts)))
+	rtest.Equals(t, 1, len(testRunList(t, "index", env.gopts)))
+}
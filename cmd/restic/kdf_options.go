@@ -0,0 +1,134 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/spf13/pflag"
+)
+
+// kdfOptions selects the key derivation function and its parameters for new
+// keys.
+type kdfOptions struct {
+	KDF    string
+	Params string
+	Time   time.Duration
+	Memory int
+
+	// changed is set when one of the options was given on the command line
+	changed bool
+}
+
+// kdfFlags are the names of the flags added by initKDFOptions.
+var kdfFlags = []string{"kdf", "kdf-params", "kdf-time", "kdf-memory"}
+
+// initKDFOptions adds the flags for the key derivation function to f.
+func initKDFOptions(f *pflag.FlagSet, opts *kdfOptions) {
+	f.StringVar(&opts.KDF, "kdf", "", "key derivation `function` for the new key, scrypt or argon2id (default: the KDF of the current key, scrypt for new repositories)")
+	f.StringVar(&opts.Params, "kdf-params", "", "use the KDF `parameters` instead of calibrating them, e.g. \"N=32768,r=8,p=1\" for scrypt or \"t=3,m=65536,p=4\" for argon2id")
+	f.DurationVar(&opts.Time, "kdf-time", 0, "calibrate the KDF parameters so that deriving the key takes about `duration` (default: 500ms)")
+	f.IntVar(&opts.Memory, "kdf-memory", 0, "calibrate the KDF parameters to use at most `MiB` of memory (default: 60)")
+}
+
+// setChanged records whether one of the KDF flags was given in f.
+func (opts *kdfOptions) setChanged(f *pflag.FlagSet) {
+	for _, name := range kdfFlags {
+		if f.Changed(name) {
+			opts.changed = true
+		}
+	}
+}
+
+// apply configures the KDF used for new keys. When no option was given, the
+// parameters are left unchanged. Options with a zero value keep their
+// defaults, the KDF defaults to repository.KDFName.
+func (opts kdfOptions) apply() error {
+	if !opts.changed {
+		return nil
+	}
+
+	name := opts.KDF
+	if name == "" {
+		name = repository.KDFName
+	}
+
+	if name != crypto.KDFScrypt && name != crypto.KDFArgon2id {
+		return errors.Fatalf("unsupported KDF %q, valid values are %q and %q", name, crypto.KDFScrypt, crypto.KDFArgon2id)
+	}
+
+	if opts.Params != "" {
+		params, err := parseKDFParams(name, opts.Params)
+		if err != nil {
+			return err
+		}
+
+		repository.Params = &params
+		return nil
+	}
+
+	if opts.Time < 0 || opts.Memory < 0 {
+		return errors.Fatal("--kdf-time and --kdf-memory must not be negative")
+	}
+
+	repository.KDFName = name
+	if opts.Time > 0 {
+		repository.KDFTimeout = opts.Time
+	}
+	if opts.Memory > 0 {
+		repository.KDFMemory = opts.Memory
+	}
+
+	// calibrate the parameters for the new settings
+	repository.Params = nil
+	return nil
+}
+
+// parseKDFParams parses explicit parameters for the KDF name, given as a list
+// of comma separated "key=value" pairs. For scrypt, the keys are "N", "r" and
+// "p". For Argon2id, the keys are "t" (passes), "m" (memory in KiB) and "p"
+// (threads).
+func parseKDFParams(name, s string) (crypto.Params, error) {
+	params := crypto.Params{KDF: name}
+
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return crypto.Params{}, errors.Fatalf("invalid KDF parameter %q, expected key=value", field)
+		}
+
+		value, err := strconv.ParseUint(kv[1], 10, 32)
+		if err != nil {
+			return crypto.Params{}, errors.Fatalf("invalid value for KDF parameter %q: %v", kv[0], err)
+		}
+
+		switch {
+		case name == crypto.KDFScrypt && kv[0] == "N":
+			params.N = int(value)
+		case name == crypto.KDFScrypt && kv[0] == "r":
+			params.R = int(value)
+		case name == crypto.KDFScrypt && kv[0] == "p":
+			params.P = int(value)
+		case name == crypto.KDFArgon2id && kv[0] == "t":
+			params.Time = uint32(value)
+		case name == crypto.KDFArgon2id && kv[0] == "m":
+			params.Memory = uint32(value)
+		case name == crypto.KDFArgon2id && kv[0] == "p":
+			if value > 255 {
+				return crypto.Params{}, errors.Fatalf("argon2id supports at most 255 threads, got %d", value)
+			}
+			params.Threads = uint8(value)
+		default:
+			return crypto.Params{}, errors.Fatalf("unknown parameter %q for KDF %v", kv[0], name)
+		}
+	}
+
+	if err := params.Check(); err != nil {
+		return crypto.Params{}, errors.Fatalf("invalid KDF parameters %q: %v", s, err)
+	}
+
+	return params, nil
+}
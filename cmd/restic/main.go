@@ -44,6 +44,15 @@ directories in an encrypted repository stored on different backends.
 		}
 		globalOptions.password = pwd
 
+		// the metrics are shared by all repositories opened by the command,
+		// e.g. by copy
+		if globalOptions.MetricsFile != "" || globalOptions.MetricsListen != "" {
+			globalOptions.metrics, err = setupMetrics(globalOptions)
+			if err != nil {
+				return err
+			}
+		}
+
 		// run the debug functions for all subcommands (if build tag "debug" is
 		// enabled)
 		if err := runDebug(); err != nil {
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/metrics"
+)
+
+// setupMetrics returns a new collection of metrics for the backend requests.
+// The metrics are served via HTTP at gopts.MetricsListen while restic is
+// running and written to gopts.MetricsFile when it exits, so it must only be
+// called once for each run of restic.
+func setupMetrics(gopts GlobalOptions) (*metrics.Metrics, error) {
+	m := metrics.New()
+
+	if gopts.MetricsListen != "" {
+		ln, err := net.Listen("tcp", gopts.MetricsListen)
+		if err != nil {
+			return nil, errors.Fatalf("unable to serve metrics: %v", err)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m)
+		srv := &http.Server{Handler: mux}
+
+		go func() {
+			err := srv.Serve(ln)
+			debug.Log("metrics server stopped: %v", err)
+		}()
+		AddCleanupHandler(srv.Close)
+
+		Verbosef("serving metrics at http://%v/metrics\n", ln.Addr())
+	}
+
+	if gopts.MetricsFile != "" {
+		AddCleanupHandler(func() error {
+			return m.WriteFile(gopts.MetricsFile)
+		})
+	}
+
+	return m, nil
+}
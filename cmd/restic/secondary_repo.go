@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/spf13/pflag"
+)
+
+// secondaryRepoOptions holds the location and password source of a second
+// repository, e.g. the source repository for the copy command.
+type secondaryRepoOptions struct {
+	Repo         string
+	PasswordFile string
+
+	// password is only used in tests
+	password string
+}
+
+// initSecondaryRepoOptions adds the flags for a second repository to f. The
+// flags and environment variables are prefixed with prefix, e.g. "from".
+func initSecondaryRepoOptions(f *pflag.FlagSet, opts *secondaryRepoOptions, prefix, usage string) {
+	env := "RESTIC_" + toEnvName(prefix)
+	f.StringVar(&opts.Repo, prefix+"-repo", os.Getenv(env+"_REPOSITORY"), usage+" `repository` (default: $"+env+"_REPOSITORY)")
+	f.StringVar(&opts.PasswordFile, prefix+"-password-file", os.Getenv(env+"_PASSWORD_FILE"), "read the "+usage+" repository password from a `file` (default: $"+env+"_PASSWORD_FILE)")
+}
+
+// toEnvName converts a flag prefix to the upper case form used in environment
+// variables.
+func toEnvName(prefix string) string {
+	return strings.ToUpper(strings.Replace(prefix, "-", "_", -1))
+}
+
+// fillSecondaryGlobalOpts returns a copy of gopts which can be used to open
+// the second repository described by opts. The password is resolved from the
+// password file or the environment, otherwise the user is prompted for it.
+func fillSecondaryGlobalOpts(opts secondaryRepoOptions, gopts GlobalOptions, prefix, usage string) (GlobalOptions, error) {
+	if opts.Repo == "" {
+		return GlobalOptions{}, errors.Fatalf("Please specify the %v repository location (--%v-repo)", usage, prefix)
+	}
+
+	var err error
+	secGopts := gopts
+	secGopts.Repo = opts.Repo
+	// the staging directory belongs to the main repository
+	secGopts.StagingDir = ""
+	secGopts.PasswordFile = opts.PasswordFile
+	secGopts.password = opts.password
+	if secGopts.password == "" {
+		secGopts.password, err = resolvePassword(secGopts, "RESTIC_"+toEnvName(prefix)+"_PASSWORD")
+		if err != nil {
+			return GlobalOptions{}, err
+		}
+	}
+
+	secGopts.password, err = ReadPassword(secGopts, "enter password for "+usage+" repository: ")
+	if err != nil {
+		return GlobalOptions{}, err
+	}
+
+	return secGopts, nil
+}
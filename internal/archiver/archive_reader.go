@@ -9,8 +9,6 @@ import (
 	"github.com/restic/restic/internal/restic"
 
 	"github.com/restic/restic/internal/errors"
-
-	"github.com/restic/chunker"
 )
 
 // Reader allows saving a stream of data to the repository.
@@ -19,6 +17,10 @@ type Reader struct {
 
 	Tags     []string
 	Hostname string
+
+	// FixedChunkSize is the size of the chunks the data is split into. When
+	// zero, content defined chunking is used.
+	FixedChunkSize uint
 }
 
 // Archive reads data from the reader and saves it to the repo.
@@ -36,8 +38,17 @@ func (r *Reader) Archive(ctx context.Context, name string, rd io.Reader, p *rest
 	p.Start()
 	defer p.Done()
 
+	summary := &restic.SnapshotSummary{
+		BackupStart:         time.Now(),
+		FilesNew:            1,
+		TotalFilesProcessed: 1,
+	}
+
 	repo := r.Repository
-	chnker := chunker.New(rd, repo.Config().ChunkerPolynomial)
+	var chnker splitter = repo.Config().NewChunker(rd)
+	if r.FixedChunkSize > 0 {
+		chnker = newFixedChunker(rd, r.FixedChunkSize)
+	}
 
 	ids := restic.IDs{}
 	var fileSize uint64
@@ -60,6 +71,8 @@ func (r *Reader) Archive(ctx context.Context, name string, rd io.Reader, p *rest
 				return nil, restic.ID{}, err
 			}
 			debug.Log("saved blob %v (%d bytes)\n", id, chunk.Length)
+			summary.DataBlobs++
+			summary.DataAdded += uint64(chunk.Length)
 		} else {
 			debug.Log("blob %v already saved in the repo\n", id)
 		}
@@ -96,6 +109,11 @@ func (r *Reader) Archive(ctx context.Context, name string, rd io.Reader, p *rest
 	sn.Tree = &treeID
 	debug.Log("tree saved as %v", treeID)
 
+	summary.TreeBlobs = 1
+	summary.TotalBytesProcessed = fileSize
+	summary.BackupEnd = time.Now()
+	sn.Summary = summary
+
 	id, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
 	if err != nil {
 		return nil, restic.ID{}, err
@@ -42,11 +42,33 @@ type Archiver struct {
 
 	blobToken chan struct{}
 
+	summary struct {
+		restic.SnapshotSummary
+		sync.Mutex
+	}
+
 	Warn         func(dir string, fi os.FileInfo, err error)
 	SelectFilter pipe.SelectFunc
 	Excludes     []string
 
 	WithAccessTime bool
+
+	// FixedChunkSize is the size of the chunks for files which are split
+	// into fixed-size chunks instead of using content defined chunking. When
+	// FixedChunkPatterns is empty, this applies to all files, otherwise only
+	// to the files which match one of the patterns. Zero disables fixed-size
+	// chunking.
+	FixedChunkSize     uint
+	FixedChunkPatterns []string
+
+	// ReadDeviceContents selects whether the content of block devices is
+	// saved like the content of a regular file instead of only the device
+	// node.
+	ReadDeviceContents bool
+
+	// IsBlockDevice returns true if fi describes a block device, it is used
+	// when ReadDeviceContents is set.
+	IsBlockDevice func(fi os.FileInfo) bool
 }
 
 // New returns a new archiver.
@@ -68,6 +90,7 @@ func New(repo restic.Repository) *Archiver {
 
 	arch.Warn = archiverPrintWarnings
 	arch.SelectFilter = archiverAllowAllFiles
+	arch.IsBlockDevice = isBlockDevice
 
 	return arch
 }
@@ -108,6 +131,11 @@ func (arch *Archiver) Save(ctx context.Context, t restic.BlobType, data []byte,
 		return err
 	}
 
+	arch.updateSummary(func(s *restic.SnapshotSummary) {
+		s.DataBlobs++
+		s.DataAdded += uint64(len(data))
+	})
+
 	debug.Log("Save(%v, %v): new blob\n", t, id)
 	return nil
 }
@@ -126,7 +154,47 @@ func (arch *Archiver) SaveTreeJSON(ctx context.Context, tree *restic.Tree) (rest
 		return id, nil
 	}
 
-	return arch.repo.SaveBlob(ctx, restic.TreeBlob, data, id)
+	id, err = arch.repo.SaveBlob(ctx, restic.TreeBlob, data, id)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	arch.updateSummary(func(s *restic.SnapshotSummary) {
+		s.TreeBlobs++
+		s.DataAdded += uint64(len(data))
+	})
+
+	return id, nil
+}
+
+// updateSummary calls fn with the summary of the current backup while holding
+// the lock.
+func (arch *Archiver) updateSummary(fn func(*restic.SnapshotSummary)) {
+	arch.summary.Lock()
+	fn(&arch.summary.SnapshotSummary)
+	arch.summary.Unlock()
+}
+
+// countFile adds the regular file node to the summary. A file is unmodified
+// if its content was taken from the parent snapshot, changed if it was
+// present in the parent snapshot, and new otherwise.
+func (arch *Archiver) countFile(node *restic.Node, unmodified, changed bool) {
+	if node.Type != "file" {
+		return
+	}
+
+	arch.updateSummary(func(s *restic.SnapshotSummary) {
+		switch {
+		case unmodified:
+			s.FilesUnmodified++
+		case changed:
+			s.FilesChanged++
+		default:
+			s.FilesNew++
+		}
+		s.TotalFilesProcessed++
+		s.TotalBytesProcessed += node.Size
+	})
 }
 
 func (arch *Archiver) reloadFileIfChanged(node *restic.Node, file fs.File) (*restic.Node, error) {
@@ -139,7 +207,8 @@ func (arch *Archiver) reloadFileIfChanged(node *restic.Node, file fs.File) (*res
 		return nil, errors.Wrap(err, "restic.Stat")
 	}
 
-	if fi.ModTime().Equal(node.ModTime) {
+	// the content of devices is saved regardless of the modification time
+	if fi.ModTime().Equal(node.ModTime) || (arch.ReadDeviceContents && arch.IsBlockDevice(fi)) {
 		return node, nil
 	}
 
@@ -232,7 +301,8 @@ func (arch *Archiver) SaveFile(ctx context.Context, p *restic.Progress, node *re
 		return node, err
 	}
 
-	chnker := chunker.New(file, arch.repo.Config().ChunkerPolynomial)
+	// holes in sparse files are not read from disk
+	chnker := arch.newSplitter(node.Path, fs.NewSparseReader(file))
 	resultChannels := [](<-chan saveResult){}
 
 	for {
@@ -295,7 +365,19 @@ func (arch *Archiver) fileWorker(ctx context.Context, wg *sync.WaitGroup, p *res
 				node.AccessTime = node.ModTime
 			}
 
+			if arch.ReadDeviceContents && arch.IsBlockDevice(e.Info()) {
+				debug.Log("   %v is a block device, reading its content", e.Path())
+				err = convertDeviceNode(node)
+				if err != nil {
+					arch.Warn(e.Fullpath(), e.Info(), err)
+					e.Result() <- nil
+					p.Report(restic.Stat{Errors: 1})
+					continue
+				}
+			}
+
 			// try to use old node, if present
+			unmodified := false
 			if e.Node != nil {
 				debug.Log("   %v use old data", e.Path())
 
@@ -312,6 +394,7 @@ func (arch *Archiver) fileWorker(ctx context.Context, wg *sync.WaitGroup, p *res
 
 				if !contentMissing {
 					node.Content = oldNode.Content
+					unmodified = true
 					debug.Log("   %v content is complete", e.Path())
 				}
 			} else {
@@ -336,6 +419,7 @@ func (arch *Archiver) fileWorker(ctx context.Context, wg *sync.WaitGroup, p *res
 			}
 
 			debug.Log("   processed %v, %d blobs", e.Path(), len(node.Content))
+			arch.countFile(node, unmodified, e.Changed)
 			e.Result() <- node
 			p.Report(restic.Stat{Files: 1})
 		case <-ctx.Done():
@@ -460,6 +544,8 @@ func (arch *Archiver) dirWorker(ctx context.Context, wg *sync.WaitGroup, p *rest
 type archivePipe struct {
 	Old <-chan walk.TreeJob
 	New <-chan pipe.Job
+
+	isBlockDevice func(os.FileInfo) bool
 }
 
 func copyJobs(ctx context.Context, in <-chan pipe.Job, out chan<- pipe.Job) {
@@ -495,6 +581,8 @@ type archiveJob struct {
 	hasOld bool
 	old    walk.TreeJob
 	new    pipe.Job
+
+	isBlockDevice func(os.FileInfo) bool
 }
 
 func (a *archivePipe) compare(ctx context.Context, out chan<- pipe.Job) {
@@ -520,7 +608,7 @@ func (a *archivePipe) compare(ctx context.Context, out chan<- pipe.Job) {
 
 				// handle remaining newJob
 				if !loadNew {
-					out <- archiveJob{new: newJob}.Copy()
+					out <- archiveJob{new: newJob, isBlockDevice: a.isBlockDevice}.Copy()
 				}
 
 				copyJobs(ctx, a.New, out)
@@ -555,7 +643,7 @@ func (a *archivePipe) compare(ctx context.Context, out chan<- pipe.Job) {
 			debug.Log("    same filename %q", file1)
 
 			// send job
-			out <- archiveJob{hasOld: true, old: oldJob, new: newJob}.Copy()
+			out <- archiveJob{hasOld: true, old: oldJob, new: newJob, isBlockDevice: a.isBlockDevice}.Copy()
 			loadOld = true
 			loadNew = true
 			continue
@@ -563,7 +651,7 @@ func (a *archivePipe) compare(ctx context.Context, out chan<- pipe.Job) {
 			debug.Log("    %q < %q, file %q added", dir1, dir2, file2)
 			// file is new, send new job and load new
 			loadNew = true
-			out <- archiveJob{new: newJob}.Copy()
+			out <- archiveJob{new: newJob, isBlockDevice: a.isBlockDevice}.Copy()
 			continue
 		} else if dir1 == dir2 {
 			if file1 < file2 {
@@ -575,7 +663,7 @@ func (a *archivePipe) compare(ctx context.Context, out chan<- pipe.Job) {
 				debug.Log("    %q > %q, file %q added", file1, file2, file2)
 				// file is new, send new job and load new
 				loadNew = true
-				out <- archiveJob{new: newJob}.Copy()
+				out <- archiveJob{new: newJob, isBlockDevice: a.isBlockDevice}.Copy()
 				continue
 			}
 		}
@@ -591,8 +679,9 @@ func (j archiveJob) Copy() pipe.Job {
 		return j.new
 	}
 
-	// handle files
-	if isRegularFile(j.new.Info()) {
+	// handle files, the content of devices is always read again
+	isDevice := j.isBlockDevice != nil && j.isBlockDevice(j.new.Info())
+	if isRegularFile(j.new.Info()) && !isDevice {
 		debug.Log("   job %v is file", j.new.Path())
 
 		// if type has changed, return new job directly
@@ -603,7 +692,9 @@ func (j archiveJob) Copy() pipe.Job {
 		// if file is newer, return the new job
 		if j.old.Node.IsNewer(j.new.Fullpath(), j.new.Info()) {
 			debug.Log("   job %v is newer", j.new.Path())
-			return j.new
+			e := j.new.(pipe.Entry)
+			e.Changed = true
+			return e
 		}
 
 		debug.Log("   job %v add old data", j.new.Path())
@@ -671,7 +762,7 @@ func (p baseNameSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 // Snapshot creates a snapshot of the given paths. If parentrestic.ID is set, this is
 // used to compare the files to the ones archived at the time this snapshot was
 // taken.
-func (arch *Archiver) Snapshot(ctx context.Context, p *restic.Progress, paths, tags []string, hostname string, parentID *restic.ID, time time.Time) (*restic.Snapshot, restic.ID, error) {
+func (arch *Archiver) Snapshot(ctx context.Context, p *restic.Progress, paths, tags []string, hostname string, parentID *restic.ID, timestamp time.Time) (*restic.Snapshot, restic.ID, error) {
 	paths = unique(paths)
 	sort.Sort(baseNameSlice(paths))
 
@@ -686,13 +777,17 @@ func (arch *Archiver) Snapshot(ctx context.Context, p *restic.Progress, paths, t
 	defer p.Done()
 
 	// create new snapshot
-	sn, err := restic.NewSnapshot(paths, tags, hostname, time)
+	sn, err := restic.NewSnapshot(paths, tags, hostname, timestamp)
 	if err != nil {
 		return nil, restic.ID{}, err
 	}
 	sn.Excludes = arch.Excludes
 
-	jobs := archivePipe{}
+	arch.updateSummary(func(s *restic.SnapshotSummary) {
+		*s = restic.SnapshotSummary{BackupStart: time.Now()}
+	})
+
+	jobs := archivePipe{isBlockDevice: arch.IsBlockDevice}
 
 	// use parent snapshot (if some was given)
 	if parentID != nil {
@@ -794,6 +889,12 @@ func (arch *Archiver) Snapshot(ctx context.Context, p *restic.Progress, paths, t
 
 	debug.Log("saved indexes")
 
+	arch.updateSummary(func(s *restic.SnapshotSummary) {
+		s.BackupEnd = time.Now()
+		summary := *s
+		sn.Summary = &summary
+	})
+
 	// save snapshot
 	id, err := arch.repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
 	if err != nil {
@@ -813,6 +914,40 @@ func isRegularFile(fi os.FileInfo) bool {
 	return fi.Mode()&(os.ModeType|os.ModeCharDevice) == 0
 }
 
+// isBlockDevice returns true if fi describes a block device.
+func isBlockDevice(fi os.FileInfo) bool {
+	if fi == nil {
+		return false
+	}
+
+	return fi.Mode()&(os.ModeType|os.ModeCharDevice) == os.ModeDevice
+}
+
+// convertDeviceNode turns the node for a block device into a node for a
+// regular file, so that the content of the device is saved. The size is
+// determined by seeking to the end of the device.
+func convertDeviceNode(node *restic.Node) error {
+	f, err := fs.Open(node.Path)
+	if err != nil {
+		return errors.Wrap(err, "Open")
+	}
+
+	size, err := f.Seek(0, io.SeekEnd)
+	closeErr := f.Close()
+	if err != nil {
+		return errors.Wrap(err, "Seek")
+	}
+	if closeErr != nil {
+		return errors.Wrap(closeErr, "Close")
+	}
+
+	node.Type = "file"
+	node.Mode &^= os.ModeType | os.ModeCharDevice
+	node.Device = 0
+	node.Size = uint64(size)
+	return nil
+}
+
 // Scan traverses the dirs to collect restic.Stat information while emitting progress
 // information with p.
 func Scan(dirs []string, filter pipe.SelectFunc, p *restic.Progress) (restic.Stat, error) {
@@ -79,7 +79,7 @@ func testArchiverDuplication(t *testing.T) {
 
 	repo := repository.New(forgetfulBackend())
 
-	err = repo.Init(context.TODO(), "foo")
+	err = repo.Init(context.TODO(), "foo", repository.InitOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
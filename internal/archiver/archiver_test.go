@@ -369,3 +369,176 @@ func TestArchiveNameCollision(t *testing.T) {
 		t.Fatalf("tree has %d nodes, wanted 2: %v", len(tree.Nodes), tree.Nodes)
 	}
 }
+
+func TestArchiveSummary(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(dir, "unmodified"), []byte("unmodified file"), 0644))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(dir, "changed"), []byte("changed file"), 0644))
+
+	sn, id, err := archiver.New(repo).Snapshot(context.TODO(), nil, []string{dir}, nil, "localhost", nil, time.Now())
+	rtest.OK(t, err)
+
+	rtest.Assert(t, sn.Summary != nil, "snapshot has no summary")
+	rtest.Equals(t, uint(2), sn.Summary.FilesNew)
+	rtest.Equals(t, uint(0), sn.Summary.FilesChanged)
+	rtest.Equals(t, uint(0), sn.Summary.FilesUnmodified)
+	rtest.Equals(t, uint(2), sn.Summary.TotalFilesProcessed)
+	rtest.Equals(t, uint64(27), sn.Summary.TotalBytesProcessed)
+	rtest.Equals(t, 2, sn.Summary.DataBlobs)
+	rtest.Assert(t, sn.Summary.TreeBlobs > 0, "no tree blobs counted")
+	rtest.Assert(t, !sn.Summary.BackupEnd.Before(sn.Summary.BackupStart), "backup ended before it started")
+
+	// the summary must be saved with the snapshot
+	loaded, err := restic.LoadSnapshot(context.TODO(), repo, id)
+	rtest.OK(t, err)
+	rtest.Equals(t, sn.Summary.FilesNew, loaded.Summary.FilesNew)
+	rtest.Equals(t, sn.Summary.DataAdded, loaded.Summary.DataAdded)
+
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(dir, "changed"), []byte("the file has changed"), 0644))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(dir, "new"), []byte("new file"), 0644))
+
+	sn, _, err = archiver.New(repo).Snapshot(context.TODO(), nil, []string{dir}, nil, "localhost", &id, time.Now())
+	rtest.OK(t, err)
+
+	rtest.Equals(t, uint(1), sn.Summary.FilesNew)
+	rtest.Equals(t, uint(1), sn.Summary.FilesChanged)
+	rtest.Equals(t, uint(1), sn.Summary.FilesUnmodified)
+	rtest.Equals(t, uint(3), sn.Summary.TotalFilesProcessed)
+	rtest.Equals(t, 2, sn.Summary.DataBlobs)
+}
+
+// loadFileNode returns the node for the file name in the directory dir, which
+// was saved in the snapshot sn.
+func loadFileNode(t *testing.T, repo restic.Repository, sn *restic.Snapshot, dir, name string) *restic.Node {
+	find := func(id restic.ID, name string) *restic.Node {
+		tree, err := repo.LoadTree(context.TODO(), id)
+		rtest.OK(t, err)
+
+		for _, node := range tree.Nodes {
+			if node.Name == name {
+				return node
+			}
+		}
+
+		t.Fatalf("%v not found in tree %v", name, id.Str())
+		return nil
+	}
+
+	dirNode := find(*sn.Tree, filepath.Base(dir))
+	return find(*dirNode.Subtree, name)
+}
+
+func TestArchiveFixedChunkSize(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	const blockSize = 64 * 1024
+	image := rtest.Random(23, 3*blockSize+100)
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(dir, "disk.img"), image, 0644))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(dir, "other"), rtest.Random(42, 2*blockSize), 0644))
+
+	newArchiver := func() *archiver.Archiver {
+		arch := archiver.New(repo)
+		arch.FixedChunkSize = blockSize
+		arch.FixedChunkPatterns = []string{"*.img"}
+		return arch
+	}
+
+	sn, id, err := newArchiver().Snapshot(context.TODO(), nil, []string{dir}, nil, "localhost", nil, time.Now())
+	rtest.OK(t, err)
+
+	node := loadFileNode(t, repo, sn, dir, "disk.img")
+	rtest.Equals(t, 4, len(node.Content))
+	for i, blobID := range node.Content {
+		size, found := repo.LookupBlobSize(blobID, restic.DataBlob)
+		rtest.Assert(t, found, "blob %v not found", blobID.Str())
+
+		expected := uint(blockSize)
+		if i == len(node.Content)-1 {
+			expected = 100
+		}
+		rtest.Equals(t, expected, size)
+	}
+
+	// the file which does not match the pattern uses content defined chunking
+	node = loadFileNode(t, repo, sn, dir, "other")
+	rtest.Equals(t, 1, len(node.Content))
+
+	// modifying a block in place only adds a single new blob
+	image[blockSize+10] ^= 0xff
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(dir, "disk.img"), image, 0644))
+
+	sn, _, err = newArchiver().Snapshot(context.TODO(), nil, []string{dir}, nil, "localhost", &id, time.Now())
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, sn.Summary.DataBlobs)
+
+	checker.TestCheckRepo(t, repo)
+}
+
+func TestArchiveDeviceContents(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	// treat regular files named "*.dev" as block devices
+	isBlockDevice := func(fi os.FileInfo) bool {
+		return fi != nil && fi.Mode().IsRegular() && filepath.Ext(fi.Name()) == ".dev"
+	}
+
+	data := rtest.Random(23, 3*1024*1024)
+	filename := filepath.Join(dir, "disk.dev")
+	rtest.OK(t, ioutil.WriteFile(filename, data, 0600))
+	fi, err := os.Stat(filename)
+	rtest.OK(t, err)
+
+	snapshot := func(parent *restic.ID) (*restic.Snapshot, restic.ID) {
+		arch := archiver.New(repo)
+		arch.ReadDeviceContents = true
+		arch.IsBlockDevice = isBlockDevice
+		sn, id, err := arch.Snapshot(context.TODO(), nil, []string{dir}, nil, "localhost", parent, time.Now())
+		rtest.OK(t, err)
+		return sn, id
+	}
+
+	checkContent := func(sn *restic.Snapshot) {
+		node := loadFileNode(t, repo, sn, dir, "disk.dev")
+		rtest.Equals(t, "file", node.Type)
+		rtest.Equals(t, uint64(len(data)), node.Size)
+
+		var buf []byte
+		for _, id := range node.Content {
+			size, found := repo.LookupBlobSize(id, restic.DataBlob)
+			rtest.Assert(t, found, "blob %v not found", id.Str())
+
+			blob := restic.NewBlobBuffer(int(size))
+			n, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, blob)
+			rtest.OK(t, err)
+			buf = append(buf, blob[:n]...)
+		}
+		rtest.Assert(t, bytes.Equal(data, buf), "wrong content for device")
+	}
+
+	sn, id := snapshot(nil)
+	checkContent(sn)
+
+	// the modification time of a device does not change when its content is
+	// written, so the content must be read again with a parent snapshot
+	data[1234] ^= 0xff
+	rtest.OK(t, ioutil.WriteFile(filename, data, 0600))
+	rtest.OK(t, os.Chtimes(filename, fi.ModTime(), fi.ModTime()))
+
+	sn, _ = snapshot(&id)
+	checkContent(sn)
+
+	checker.TestCheckRepo(t, repo)
+}
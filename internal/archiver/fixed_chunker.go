@@ -0,0 +1,76 @@
+package archiver
+
+import (
+	"io"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/filter"
+
+	"github.com/restic/chunker"
+)
+
+// splitter splits the data of a file into chunks.
+type splitter interface {
+	Next(data []byte) (chunker.Chunk, error)
+}
+
+// fixedChunker splits the data read from rd into chunks of a fixed size,
+// only the last chunk may be smaller.
+type fixedChunker struct {
+	rd   io.Reader
+	size uint
+	pos  uint
+}
+
+func newFixedChunker(rd io.Reader, size uint) *fixedChunker {
+	return &fixedChunker{rd: rd, size: size}
+}
+
+// Next returns the next chunk, the data is stored in data if it is large
+// enough. At the end of the data, io.EOF is returned.
+func (c *fixedChunker) Next(data []byte) (chunker.Chunk, error) {
+	if uint(cap(data)) < c.size {
+		data = make([]byte, c.size)
+	}
+	data = data[:c.size]
+
+	n, err := io.ReadFull(c.rd, data)
+	if err == io.EOF {
+		return chunker.Chunk{}, io.EOF
+	}
+
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return chunker.Chunk{}, errors.Wrap(err, "ReadFull")
+	}
+
+	chunk := chunker.Chunk{
+		Start:  c.pos,
+		Length: uint(n),
+		Data:   data[:n],
+	}
+	c.pos += uint(n)
+
+	return chunk, nil
+}
+
+// newSplitter returns the splitter for the file at path, which is read from
+// rd. Files matched by FixedChunkPatterns are split into chunks of
+// FixedChunkSize, all other files are split with content defined chunking.
+func (arch *Archiver) newSplitter(path string, rd io.Reader) splitter {
+	if arch.FixedChunkSize == 0 {
+		return arch.repo.Config().NewChunker(rd)
+	}
+
+	if len(arch.FixedChunkPatterns) > 0 {
+		matched, _, err := filter.List(arch.FixedChunkPatterns, path)
+		if err != nil {
+			arch.Warn(path, nil, errors.Wrap(err, "fixed chunk pattern"))
+		}
+
+		if !matched {
+			return arch.repo.Config().NewChunker(rd)
+		}
+	}
+
+	return newFixedChunker(rd, arch.FixedChunkSize)
+}
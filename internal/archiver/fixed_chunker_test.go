@@ -0,0 +1,38 @@
+package archiver
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestFixedChunker(t *testing.T) {
+	for _, size := range []int{0, 1, 4095, 4096, 4097, 3*4096 + 17} {
+		data := rtest.Random(size, size)
+		c := newFixedChunker(bytes.NewReader(data), 4096)
+
+		var buf []byte
+		var chunks int
+		for {
+			chunk, err := c.Next(buf)
+			if err == io.EOF {
+				break
+			}
+			rtest.OK(t, err)
+
+			start := chunks * 4096
+			rtest.Equals(t, uint(start), chunk.Start)
+			rtest.Assert(t, chunk.Length == 4096 || int(chunk.Start+chunk.Length) == size,
+				"chunk %d of %d bytes is too short (%d bytes)", chunks, size, chunk.Length)
+			rtest.Assert(t, bytes.Equal(data[start:start+int(chunk.Length)], chunk.Data),
+				"chunk %d of %d bytes has wrong data", chunks, size)
+
+			buf = chunk.Data
+			chunks++
+		}
+
+		rtest.Equals(t, (size+4095)/4096, chunks)
+	}
+}
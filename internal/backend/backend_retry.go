@@ -4,37 +4,145 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff"
 	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 )
 
+// RetryPolicy configures how failed operations are retried. Zero values
+// select the defaults.
+type RetryPolicy struct {
+	// MaxTries is the maximum number of retries after the first try of an
+	// operation, zero means that the number is unlimited.
+	MaxTries int
+
+	// MaxElapsedTime is the maximum time spent retrying an operation
+	// (default: 15 minutes).
+	MaxElapsedTime time.Duration
+
+	// MinBackoff and MaxBackoff bound the time between two tries, which is
+	// increased exponentially (default: 500ms and one minute).
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// BreakAfter is the duration after which all operations are aborted when
+	// all requests to the backend failed since then. Afterwards, all further
+	// operations fail immediately for the same duration, then the backend is
+	// tried again. Zero disables the circuit breaker.
+	BreakAfter time.Duration
+}
+
 // RetryBackend retries operations on the backend in case of an error with a
-// backoff.
+// backoff. Errors marked with Permanent are not retried.
 type RetryBackend struct {
 	restic.Backend
-	MaxTries int
-	Report   func(string, error, time.Duration)
+	RetryPolicy
+	Report func(string, error, time.Duration)
+
+	m            sync.Mutex
+	failingSince time.Time
+	lastErr      error
+	brokenUntil  time.Time
 }
 
 // statically ensure that RetryBackend implements restic.Backend.
 var _ restic.Backend = &RetryBackend{}
 
 // NewRetryBackend wraps be with a backend that retries operations after a
-// backoff. report is called with a description and the error, if one occurred.
-func NewRetryBackend(be restic.Backend, maxTries int, report func(string, error, time.Duration)) *RetryBackend {
+// backoff according to policy. report is called with a description and the
+// error, if one occurred.
+func NewRetryBackend(be restic.Backend, policy RetryPolicy, report func(string, error, time.Duration)) *RetryBackend {
 	return &RetryBackend{
-		Backend:  be,
-		MaxTries: maxTries,
-		Report:   report,
+		Backend:     be,
+		RetryPolicy: policy,
+		Report:      report,
+	}
+}
+
+// breakerError returns an error if the circuit breaker has stopped all
+// operations.
+func (be *RetryBackend) breakerError() error {
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	if be.brokenUntil.IsZero() || time.Now().After(be.brokenUntil) {
+		return nil
+	}
+
+	return errors.Fatalf("all requests to the backend failed for more than %v, giving up: %v", be.BreakAfter, be.lastErr)
+}
+
+// record updates the state of the circuit breaker with the result of a
+// request. Permanent errors and missing files show that the backend is
+// reachable.
+func (be *RetryBackend) record(err error) {
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	if err == nil || IsPermanent(err) || be.Backend.IsNotExist(err) {
+		be.failingSince = time.Time{}
+		be.brokenUntil = time.Time{}
+		return
+	}
+
+	be.lastErr = err
+	if be.failingSince.IsZero() {
+		be.failingSince = time.Now()
+		return
+	}
+
+	// after the cool-down, the breaker trips again on the first failure
+	if be.BreakAfter > 0 && time.Since(be.failingSince) > be.BreakAfter {
+		debug.Log("all requests failed since %v, stopping all operations for %v", be.failingSince, be.BreakAfter)
+		be.brokenUntil = time.Now().Add(be.BreakAfter)
+	}
+}
+
+func (be *RetryBackend) newBackOff() backoff.BackOff {
+	bo := backoff.NewExponentialBackOff()
+	if be.MinBackoff > 0 {
+		bo.InitialInterval = be.MinBackoff
+	}
+	if be.MaxBackoff > 0 {
+		bo.MaxInterval = be.MaxBackoff
 	}
+	if be.MaxElapsedTime > 0 {
+		bo.MaxElapsedTime = be.MaxElapsedTime
+	}
+	bo.Reset()
+
+	return backoff.WithMaxTries(bo, uint64(be.MaxTries))
 }
 
 func (be *RetryBackend) retry(ctx context.Context, msg string, f func() error) error {
-	err := backoff.RetryNotify(f,
-		backoff.WithContext(backoff.WithMaxTries(backoff.NewExponentialBackOff(), uint64(be.MaxTries)), ctx),
+	err := backoff.RetryNotify(
+		func() error {
+			if err := be.breakerError(); err != nil {
+				return backoff.Permanent(err)
+			}
+
+			err := f()
+			if err == nil || ctx.Err() != nil {
+				return err
+			}
+
+			be.record(err)
+			if IsPermanent(err) {
+				debug.Log("%v returned permanent error: %v", msg, err)
+				return backoff.Permanent(err)
+			}
+
+			if berr := be.breakerError(); berr != nil {
+				return backoff.Permanent(berr)
+			}
+
+			return err
+		},
+		backoff.WithContext(be.newBackOff(), ctx),
 		func(err error, d time.Duration) {
 			if be.Report != nil {
 				be.Report(msg, err, d)
@@ -42,6 +150,10 @@ func (be *RetryBackend) retry(ctx context.Context, msg string, f func() error) e
 		},
 	)
 
+	if err == nil {
+		be.record(nil)
+	}
+
 	return err
 }
 
@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/mock"
@@ -247,3 +248,130 @@ func TestBackendLoadRetry(t *testing.T) {
 	test.Equals(t, data, buf)
 	test.Equals(t, 2, attempt)
 }
+
+func TestBackendRetryPermanent(t *testing.T) {
+	attempt := 0
+	be := mock.NewBackend()
+	be.StatFn = func(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+		attempt++
+		return restic.FileInfo{}, errors.Wrap(Permanent(errors.New("access denied")), "Stat")
+	}
+
+	retryBackend := RetryBackend{
+		Backend: be,
+	}
+
+	_, err := retryBackend.Stat(context.TODO(), restic.Handle{})
+	test.Assert(t, err != nil, "expected an error")
+	test.Assert(t, IsPermanent(err), "error %v is not permanent", err)
+	test.Equals(t, "access denied", errors.Cause(err).Error())
+	test.Equals(t, 1, attempt)
+}
+
+func TestBackendRetryPolicy(t *testing.T) {
+	attempt := 0
+	be := mock.NewBackend()
+	be.StatFn = func(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+		attempt++
+		return restic.FileInfo{}, errors.New("connection refused")
+	}
+
+	retryBackend := NewRetryBackend(be, RetryPolicy{
+		MaxTries:   2,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+	}, nil)
+
+	// the first try and two retries
+	_, err := retryBackend.Stat(context.TODO(), restic.Handle{})
+	test.Assert(t, err != nil, "expected an error")
+	test.Equals(t, 3, attempt)
+}
+
+func TestBackendRetryCircuitBreaker(t *testing.T) {
+	attempt := 0
+	be := mock.NewBackend()
+	be.StatFn = func(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+		attempt++
+		return restic.FileInfo{}, errors.New("connection refused")
+	}
+
+	retryBackend := NewRetryBackend(be, RetryPolicy{
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+		BreakAfter: 20 * time.Millisecond,
+	}, nil)
+
+	// the operation is retried until the circuit breaker stops it
+	_, err := retryBackend.Stat(context.TODO(), restic.Handle{})
+	test.Assert(t, errors.IsFatal(errors.Cause(err)), "expected a fatal error, got %v", err)
+	test.Assert(t, attempt > 1, "operation was not retried")
+
+	// all further operations fail without a request to the backend
+	attempt = 0
+	_, err = retryBackend.Stat(context.TODO(), restic.Handle{})
+	test.Assert(t, errors.IsFatal(errors.Cause(err)), "expected a fatal error, got %v", err)
+	test.Equals(t, 0, attempt)
+
+	// after the cool-down, a failed request trips the breaker again at once
+	time.Sleep(30 * time.Millisecond)
+	_, err = retryBackend.Stat(context.TODO(), restic.Handle{})
+	test.Assert(t, errors.IsFatal(errors.Cause(err)), "expected a fatal error, got %v", err)
+	test.Equals(t, 1, attempt)
+
+	// the backend is used again once it is reachable
+	time.Sleep(30 * time.Millisecond)
+	be.StatFn = func(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+		return restic.FileInfo{}, nil
+	}
+	_, err = retryBackend.Stat(context.TODO(), restic.Handle{})
+	test.OK(t, err)
+	_, err = retryBackend.Stat(context.TODO(), restic.Handle{})
+	test.OK(t, err)
+}
+
+func TestBackendRetryCircuitBreakerReset(t *testing.T) {
+	attempt := 0
+	be := mock.NewBackend()
+	be.StatFn = func(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+		attempt++
+		if attempt%3 == 0 {
+			return restic.FileInfo{}, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+		return restic.FileInfo{}, errors.New("connection reset")
+	}
+
+	retryBackend := NewRetryBackend(be, RetryPolicy{
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+		BreakAfter: 40 * time.Millisecond,
+	}, nil)
+
+	// a successful request in between resets the circuit breaker
+	for i := 0; i < 5; i++ {
+		_, err := retryBackend.Stat(context.TODO(), restic.Handle{})
+		test.OK(t, err)
+	}
+}
+
+func TestClassifyHTTPError(t *testing.T) {
+	for _, test := range []struct {
+		code      int
+		permanent bool
+	}{
+		{400, true},
+		{401, true},
+		{403, true},
+		{404, false},
+		{408, false},
+		{429, false},
+		{500, false},
+		{503, false},
+	} {
+		err := ClassifyHTTPError(test.code, errors.New("error"))
+		if IsPermanent(err) != test.permanent {
+			t.Errorf("status %v: want permanent %v, got %v", test.code, test.permanent, IsPermanent(err))
+		}
+	}
+}
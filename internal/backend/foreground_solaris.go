@@ -1,4 +1,4 @@
-package sftp
+package backend
 
 import (
 	"os/exec"
@@ -7,7 +7,7 @@ import (
 	"github.com/restic/restic/internal/errors"
 )
 
-func startForeground(cmd *exec.Cmd) (bg func() error, err error) {
+func StartForeground(cmd *exec.Cmd) (bg func() error, err error) {
 	// run the command in it's own process group so that SIGINT
 	// is not sent to it.
 	cmd.SysProcAttr = &syscall.SysProcAttr{
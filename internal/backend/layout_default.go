@@ -9,7 +9,8 @@ import (
 // DefaultLayout implements the default layout for local and sftp backends, as
 // described in the Design document. The `data` directory has one level of
 // subdirs, two characters each (taken from the first two characters of the
-// file name).
+// file name). The optional `parity` directory is structured like the `data`
+// directory and is only created when the first parity file is saved.
 type DefaultLayout struct {
 	Path string
 	Join func(...string) string
@@ -21,6 +22,7 @@ var defaultLayoutPaths = map[restic.FileType]string{
 	restic.IndexFile:    "index",
 	restic.LockFile:     "locks",
 	restic.KeyFile:      "keys",
+	restic.ParityFile:   "parity",
 }
 
 func (l *DefaultLayout) String() string {
@@ -36,7 +38,7 @@ func (l *DefaultLayout) Name() string {
 func (l *DefaultLayout) Dirname(h restic.Handle) string {
 	p := defaultLayoutPaths[h.Type]
 
-	if h.Type == restic.DataFile && len(h.Name) > 2 {
+	if (h.Type == restic.DataFile || h.Type == restic.ParityFile) && len(h.Name) > 2 {
 		p = l.Join(p, h.Name[:2]) + "/"
 	}
 
@@ -55,7 +57,10 @@ func (l *DefaultLayout) Filename(h restic.Handle) string {
 
 // Paths returns all directory names needed for a repo.
 func (l *DefaultLayout) Paths() (dirs []string) {
-	for _, p := range defaultLayoutPaths {
+	for t, p := range defaultLayoutPaths {
+		if t == restic.ParityFile {
+			continue
+		}
 		dirs = append(dirs, l.Join(l.Path, p))
 	}
 
@@ -70,7 +75,7 @@ func (l *DefaultLayout) Paths() (dirs []string) {
 
 // Basedir returns the base dir name for type t.
 func (l *DefaultLayout) Basedir(t restic.FileType) (dirname string, subdirs bool) {
-	if t == restic.DataFile {
+	if t == restic.DataFile || t == restic.ParityFile {
 		subdirs = true
 	}
 
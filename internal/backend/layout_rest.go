@@ -42,7 +42,10 @@ func (l *RESTLayout) Filename(h restic.Handle) string {
 
 // Paths returns all directory names
 func (l *RESTLayout) Paths() (dirs []string) {
-	for _, p := range restLayoutPaths {
+	for t, p := range restLayoutPaths {
+		if t == restic.ParityFile {
+			continue
+		}
 		dirs = append(dirs, l.URL+l.Join(l.Path, p))
 	}
 	return dirs
@@ -16,6 +16,7 @@ var s3LayoutPaths = map[restic.FileType]string{
 	restic.IndexFile:    "index",
 	restic.LockFile:     "lock",
 	restic.KeyFile:      "key",
+	restic.ParityFile:   "parity",
 }
 
 func (l *S3LegacyLayout) String() string {
@@ -65,7 +66,10 @@ func (l *S3LegacyLayout) Filename(h restic.Handle) string {
 
 // Paths returns all directory names
 func (l *S3LegacyLayout) Paths() (dirs []string) {
-	for _, p := range s3LayoutPaths {
+	for t, p := range s3LayoutPaths {
+		if t == restic.ParityFile {
+			continue
+		}
 		dirs = append(dirs, l.Join(l.Path, p))
 	}
 	return dirs
@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
@@ -22,6 +23,7 @@ type Local struct {
 
 // ensure statically that *Local implements restic.Backend.
 var _ restic.Backend = &Local{}
+var _ backend.StaleTempFileRemover = &Local{}
 
 const defaultLayout = "default"
 
@@ -97,17 +99,20 @@ func (b *Local) IsNotExist(err error) bool {
 	return os.IsNotExist(errors.Cause(err))
 }
 
-// Save stores data in the backend at the handle.
-func (b *Local) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+// Save stores data in the backend at the handle. The data is written to a
+// temporary file first, which is synced to disk and then renamed, so that a
+// crash never leaves an incomplete file under the final name.
+func (b *Local) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) (err error) {
 	debug.Log("Save %v", h)
 	if err := h.Valid(); err != nil {
 		return err
 	}
 
 	filename := b.Filename(h)
+	tmpname := backend.TempFilename(filename)
 
-	// create new file
-	f, err := fs.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, backend.Modes.File)
+	// create new temporary file
+	f, err := fs.OpenFile(tmpname, os.O_CREATE|os.O_EXCL|os.O_WRONLY, backend.Modes.File)
 
 	if b.IsNotExist(err) {
 		debug.Log("error %v: creating dir", err)
@@ -118,7 +123,7 @@ func (b *Local) Save(ctx context.Context, h restic.Handle, rd restic.RewindReade
 			debug.Log("error creating dir %v: %v", filepath.Dir(filename), mkdirErr)
 		} else {
 			// try again
-			f, err = fs.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, backend.Modes.File)
+			f, err = fs.OpenFile(tmpname, os.O_CREATE|os.O_EXCL|os.O_WRONLY, backend.Modes.File)
 		}
 	}
 
@@ -126,6 +131,15 @@ func (b *Local) Save(ctx context.Context, h restic.Handle, rd restic.RewindReade
 		return errors.Wrap(err, "OpenFile")
 	}
 
+	defer func() {
+		if err != nil {
+			// remove the incomplete temporary file
+			if rerr := fs.Remove(tmpname); rerr != nil {
+				debug.Log("unable to remove %v: %v", tmpname, rerr)
+			}
+		}
+	}()
+
 	// save data, then sync
 	_, err = io.Copy(f, rd)
 	if err != nil {
@@ -143,9 +157,48 @@ func (b *Local) Save(ctx context.Context, h restic.Handle, rd restic.RewindReade
 		return errors.Wrap(err, "Close")
 	}
 
+	if err = commitFile(tmpname, filename); err != nil {
+		return err
+	}
+
+	// sync the directory so that the rename is persisted
+	if err = syncDir(filepath.Dir(filename)); err != nil {
+		return errors.Wrap(err, "Sync")
+	}
+
 	return setNewFileMode(filename, backend.Modes.File)
 }
 
+// commitFile moves the temporary file tmpname to filename, but never replaces
+// an existing file. The file is linked to the new name, which fails if it
+// exists already. For file systems which do not support hard links, the file
+// is renamed if no file with the name exists.
+func commitFile(tmpname, filename string) error {
+	err := fs.Link(tmpname, filename)
+	if err == nil {
+		if err := fs.Remove(tmpname); err != nil {
+			// the file has been saved, the temporary file is removed by prune
+			debug.Log("unable to remove %v: %v", tmpname, err)
+		}
+		return nil
+	}
+
+	if os.IsExist(err) {
+		return errors.Wrap(err, "Link")
+	}
+
+	debug.Log("Link(%v) failed, renaming the file: %v", filename, err)
+	_, err = fs.Lstat(filename)
+	if err == nil {
+		return errors.Errorf("file %v already exists", filename)
+	}
+	if !os.IsNotExist(err) {
+		return errors.Wrap(err, "Lstat")
+	}
+
+	return errors.Wrap(fs.Rename(tmpname, filename), "Rename")
+}
+
 // Load runs fn with a reader that yields the contents of the file at h at the
 // given offset.
 func (b *Local) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
@@ -249,6 +302,12 @@ func (b *Local) List(ctx context.Context, t restic.FileType, fn func(restic.File
 			return nil
 		}
 
+		if backend.IsTempFilename(fi.Name()) {
+			// ignore temporary files, the ones left over from interrupted
+			// uploads are removed by RemoveStaleTempFiles
+			return nil
+		}
+
 		if fi.IsDir() && !subdirs {
 			return filepath.SkipDir
 		}
@@ -273,6 +332,32 @@ func (b *Local) List(ctx context.Context, t restic.FileType, fn func(restic.File
 	})
 }
 
+// RemoveStaleTempFiles removes the temporary files left over from interrupted
+// uploads and returns the number of removed files. It must only be called
+// while no other process writes to the repository.
+func (b *Local) RemoveStaleTempFiles(ctx context.Context) (int, error) {
+	removed := 0
+	err := fs.Walk(b.Path, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !isFile(fi) || !backend.IsTempFilename(fi.Name()) || time.Since(fi.ModTime()) < backend.StaleTempFileAge {
+			return ctx.Err()
+		}
+
+		debug.Log("remove stale temporary file %v", path)
+		if err := fs.Remove(path); err != nil {
+			return errors.Wrap(err, "Remove")
+		}
+		removed++
+
+		return ctx.Err()
+	})
+
+	return removed, err
+}
+
 // Delete removes the repository and all files.
 func (b *Local) Delete(ctx context.Context) error {
 	debug.Log("Delete()")
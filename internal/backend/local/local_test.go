@@ -1,13 +1,17 @@
 package local_test
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/backend/local"
 	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
 )
@@ -134,3 +138,91 @@ func TestOpenNotExistingDirectory(t *testing.T) {
 	removeAll(t, filepath.Join(dir, "data"))
 	empty(t, dir)
 }
+
+// errorReader returns an error after the first read.
+type errorReader struct {
+	restic.RewindReader
+}
+
+func (rd errorReader) Read(p []byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestSaveIncomplete(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	be, err := local.Create(local.Config{Path: dir})
+	rtest.OK(t, err)
+
+	h := restic.Handle{Type: restic.SnapshotFile, Name: restic.NewRandomID().String()}
+	err = be.Save(context.TODO(), h, errorReader{restic.NewByteReader([]byte("foo"))})
+	rtest.Assert(t, err != nil, "expected an error")
+
+	// neither the file nor the temporary file must be left over
+	found, err := be.Test(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, !found, "incomplete file was saved")
+	empty(t, filepath.Join(dir, "snapshots"))
+}
+
+func TestListTempFiles(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	be, err := local.Create(local.Config{Path: dir})
+	rtest.OK(t, err)
+
+	h := restic.Handle{Type: restic.IndexFile, Name: restic.NewRandomID().String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader([]byte("foo"))))
+
+	// an upload which is still running and one which has been interrupted
+	running := backend.TempFilename(be.Filename(h))
+	rtest.OK(t, ioutil.WriteFile(running, []byte("fo"), 0600))
+	stale := backend.TempFilename(be.Filename(h))
+	rtest.OK(t, ioutil.WriteFile(stale, []byte("f"), 0600))
+	old := time.Now().Add(-2 * backend.StaleTempFileAge)
+	rtest.OK(t, os.Chtimes(stale, old, old))
+
+	var names []string
+	rtest.OK(t, be.List(context.TODO(), restic.IndexFile, func(fi restic.FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	}))
+	rtest.Equals(t, []string{h.Name}, names)
+
+	// listing the files must not remove anything
+	_, err = os.Stat(stale)
+	rtest.OK(t, err)
+
+	removed, err := be.RemoveStaleTempFiles(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, removed)
+
+	_, err = os.Stat(running)
+	rtest.OK(t, err)
+	_, err = os.Stat(stale)
+	rtest.Assert(t, os.IsNotExist(err), "stale temporary file was not removed")
+}
+
+func TestSaveExisting(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	be, err := local.Create(local.Config{Path: dir})
+	rtest.OK(t, err)
+
+	h := restic.Handle{Type: restic.SnapshotFile, Name: restic.NewRandomID().String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader([]byte("foo"))))
+
+	// an existing file must not be replaced
+	err = be.Save(context.TODO(), h, restic.NewByteReader([]byte("bar")))
+	rtest.Assert(t, err != nil, "expected an error for an existing file")
+
+	buf, err := ioutil.ReadFile(be.Filename(h))
+	rtest.OK(t, err)
+	rtest.Equals(t, "foo", string(buf))
+
+	// the temporary file must be removed
+	rtest.Equals(t, []string{h.Name}, readdirnames(t, filepath.Join(dir, "snapshots")))
+}
@@ -4,6 +4,7 @@ package local
 
 import (
 	"os"
+	"syscall"
 
 	"github.com/restic/restic/internal/fs"
 )
@@ -12,3 +13,23 @@ import (
 func setNewFileMode(f string, mode os.FileMode) error {
 	return fs.Chmod(f, mode)
 }
+
+// syncDir flushes the directory entries of dir to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	err = d.Sync()
+	if pe, ok := err.(*os.PathError); ok && (pe.Err == syscall.EINVAL || pe.Err == syscall.ENOTSUP) {
+		// some filesystems do not support syncing directories
+		err = nil
+	}
+
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
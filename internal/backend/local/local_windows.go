@@ -10,3 +10,8 @@ import (
 func setNewFileMode(f string, mode os.FileMode) error {
 	return nil
 }
+
+// syncDir does nothing on windows, directories cannot be synced there.
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,198 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Divergence describes a file which is missing in some members, or which has
+// a different size.
+type Divergence struct {
+	Handle restic.Handle
+
+	// Sizes contains the size of the file in each member, or -1 if the file
+	// is missing.
+	Sizes []int64
+
+	// Locations contains the location of each member.
+	Locations []string
+}
+
+func (d Divergence) String() string {
+	var msgs []string
+	for i, size := range d.Sizes {
+		if size < 0 {
+			msgs = append(msgs, fmt.Sprintf("missing in %v", d.Locations[i]))
+		} else {
+			msgs = append(msgs, fmt.Sprintf("%d bytes in %v", size, d.Locations[i]))
+		}
+	}
+
+	return fmt.Sprintf("%v: %v", d.Handle, strings.Join(msgs, ", "))
+}
+
+// compareTypes are the file types which are compared. Locks are only
+// relevant while restic is running, and parity files are configured for
+// each member separately.
+var compareTypes = []restic.FileType{
+	restic.ConfigFile,
+	restic.KeyFile,
+	restic.SnapshotFile,
+	restic.IndexFile,
+	restic.DataFile,
+}
+
+// Compare lists the files in all members and returns the files which are
+// missing in some members or have different sizes. The content of the files
+// is not compared.
+func (be *Backend) Compare(ctx context.Context) ([]Divergence, error) {
+	locations := make([]string, 0, len(be.members))
+	for _, m := range be.members {
+		locations = append(locations, m.Location())
+	}
+
+	var result []Divergence
+	for _, t := range compareTypes {
+		// sizes maps the file names to the sizes in each member
+		sizes := make(map[string][]int64)
+		add := func(i int, name string, size int64) {
+			if _, ok := sizes[name]; !ok {
+				sizes[name] = make([]int64, len(be.members))
+				for j := range sizes[name] {
+					sizes[name][j] = -1
+				}
+			}
+			sizes[name][i] = size
+		}
+
+		for i, m := range be.members {
+			if t == restic.ConfigFile {
+				fi, err := m.Stat(ctx, restic.Handle{Type: t})
+				if err != nil && !m.IsNotExist(err) {
+					return nil, errors.Wrap(err, m.Location())
+				}
+				if err == nil {
+					add(i, "", fi.Size)
+				}
+				continue
+			}
+
+			err := m.List(ctx, t, func(fi restic.FileInfo) error {
+				add(i, fi.Name, fi.Size)
+				return nil
+			})
+			if err != nil {
+				return nil, errors.Wrap(err, m.Location())
+			}
+		}
+
+		names := make([]string, 0, len(sizes))
+		for name := range sizes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			s := sizes[name]
+			for _, size := range s[1:] {
+				if size != s[0] {
+					debug.Log("%v/%v diverges: %v", t, name, s)
+					result = append(result, Divergence{
+						Handle:    restic.Handle{Type: t, Name: name},
+						Sizes:     s,
+						Locations: locations,
+					})
+					break
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// loadIntact returns the content of the file d.Handle from the first member
+// which contains an intact copy. For files other than the config, the hash of
+// the content must match the file name.
+func (be *Backend) loadIntact(ctx context.Context, d Divergence) ([]byte, error) {
+	var config []byte
+	for i, m := range be.members {
+		if d.Sizes[i] < 0 {
+			continue
+		}
+
+		var buf []byte
+		err := m.Load(ctx, d.Handle, 0, 0, func(rd io.Reader) (ierr error) {
+			buf, ierr = ioutil.ReadAll(rd)
+			return ierr
+		})
+		if err != nil {
+			debug.Log("unable to load %v from %v: %v", d.Handle, m.Location(), err)
+			continue
+		}
+
+		if d.Handle.Type == restic.ConfigFile {
+			// the config file cannot be verified, so all copies must match
+			if config != nil && !bytes.Equal(config, buf) {
+				return nil, errors.Errorf("%v differs between the members, unable to decide which one is correct", d.Handle)
+			}
+			config = buf
+			continue
+		}
+
+		id, err := restic.ParseID(d.Handle.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if restic.Hash(buf).Equal(id) {
+			return buf, nil
+		}
+
+		debug.Log("copy of %v in %v is damaged", d.Handle, m.Location())
+	}
+
+	if config != nil {
+		return config, nil
+	}
+
+	return nil, errors.Errorf("no intact copy of %v found", d.Handle)
+}
+
+// Repair copies an intact version of the file described by d to the members
+// where it is missing or has the wrong size.
+func (be *Backend) Repair(ctx context.Context, d Divergence) error {
+	buf, err := be.loadIntact(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	for i, m := range be.members {
+		if d.Sizes[i] == int64(len(buf)) {
+			continue
+		}
+
+		if d.Sizes[i] >= 0 {
+			debug.Log("remove damaged %v from %v", d.Handle, m.Location())
+			if err := m.Remove(ctx, d.Handle); err != nil {
+				return errors.Wrap(err, m.Location())
+			}
+		}
+
+		debug.Log("copy %v to %v", d.Handle, m.Location())
+		if err := m.Save(ctx, d.Handle, restic.NewByteReader(buf)); err != nil {
+			return errors.Wrap(err, m.Location())
+		}
+	}
+
+	return nil
+}
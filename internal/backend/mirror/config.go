@@ -0,0 +1,40 @@
+package mirror
+
+import (
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// Config contains the locations of the mirrored repositories.
+type Config struct {
+	Locations []string
+}
+
+// ParseConfig parses the string s and extracts the locations of the mirrored
+// repositories, which are separated by commas, e.g.
+// "mirror:/srv/restic,sftp:host:/srv/restic".
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "mirror:") {
+		return nil, errors.New("invalid mirror backend specification")
+	}
+
+	var cfg Config
+	for _, loc := range strings.Split(s[7:], ",") {
+		if loc == "" {
+			return nil, errors.New("mirror backend specification contains an empty location")
+		}
+
+		if strings.HasPrefix(loc, "mirror:") {
+			return nil, errors.New("mirror backends cannot be nested")
+		}
+
+		cfg.Locations = append(cfg.Locations, loc)
+	}
+
+	if len(cfg.Locations) < 2 {
+		return nil, errors.New("mirror backend needs at least two locations")
+	}
+
+	return cfg, nil
+}
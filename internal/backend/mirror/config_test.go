@@ -0,0 +1,36 @@
+package mirror
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	var tests = []struct {
+		s   string
+		cfg Config
+	}{
+		{"mirror:/srv/a,/srv/b", Config{Locations: []string{"/srv/a", "/srv/b"}}},
+		{"mirror:/srv/a,sftp:host:/srv/b,rest:http://host:8000/",
+			Config{Locations: []string{"/srv/a", "sftp:host:/srv/b", "rest:http://host:8000/"}}},
+	}
+
+	for i, test := range tests {
+		cfg, err := ParseConfig(test.s)
+		if err != nil {
+			t.Errorf("test %d:%s failed: %v", i, test.s, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(cfg, test.cfg) {
+			t.Errorf("test %d:\ninput:\n  %s\n wrong config, want:\n  %v\ngot:\n  %v",
+				i, test.s, test.cfg, cfg)
+		}
+	}
+
+	for _, s := range []string{"mirror:", "mirror:/srv/a", "mirror:/srv/a,", "mirror:/srv/a,mirror:/b,/c", "/srv/a,/srv/b"} {
+		if _, err := ParseConfig(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+// Package mirror implements a backend which stores all files in several
+// backends at once.
+package mirror
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Backend saves and removes files in all member backends and reads from the
+// first member which returns the data successfully.
+type Backend struct {
+	members []restic.Backend
+}
+
+// make sure that *Backend implements restic.Backend
+var _ restic.Backend = &Backend{}
+
+// New returns a backend which mirrors all files to members.
+func New(members ...restic.Backend) (*Backend, error) {
+	if len(members) < 2 {
+		return nil, errors.New("mirror backend needs at least two members")
+	}
+
+	return &Backend{members: members}, nil
+}
+
+// Members returns the member backends.
+func (be *Backend) Members() []restic.Backend {
+	return be.members
+}
+
+// Location returns the locations of all members.
+func (be *Backend) Location() string {
+	locations := make([]string, 0, len(be.members))
+	for _, m := range be.members {
+		locations = append(locations, m.Location())
+	}
+	return "mirror:" + strings.Join(locations, ",")
+}
+
+// Test returns true if any member contains the file.
+func (be *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	var firstErr error
+	for _, m := range be.members {
+		found, err := m.Test(ctx, h)
+		if err != nil {
+			debug.Log("Test(%v) failed for %v: %v", h, m.Location(), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if found {
+			return true, nil
+		}
+	}
+
+	if firstErr != nil {
+		return false, firstErr
+	}
+
+	return false, nil
+}
+
+// Remove removes the file from all members. It is not an error if some
+// members do not contain the file.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	var firstErr, notExistErr error
+	removed := false
+	for _, m := range be.members {
+		err := m.Remove(ctx, h)
+		switch {
+		case err == nil:
+			removed = true
+		case m.IsNotExist(err):
+			notExistErr = err
+		default:
+			debug.Log("Remove(%v) failed for %v: %v", h, m.Location(), err)
+			if firstErr == nil {
+				firstErr = errors.Wrap(err, m.Location())
+			}
+		}
+	}
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	if !removed {
+		return notExistErr
+	}
+
+	return nil
+}
+
+// Close closes all members.
+func (be *Backend) Close() error {
+	var firstErr error
+	for _, m := range be.members {
+		if err := m.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Save stores the file in all members. When saving fails for one member,
+// the file is removed from the members it has already been saved to, so that
+// the members do not diverge.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	for i, m := range be.members {
+		if i > 0 {
+			if err := rd.Rewind(); err != nil {
+				return err
+			}
+		}
+
+		err := m.Save(ctx, h, rd)
+		if err == nil {
+			continue
+		}
+
+		debug.Log("Save(%v) failed for %v: %v", h, m.Location(), err)
+		for _, saved := range be.members[:i] {
+			if rerr := saved.Remove(ctx, h); rerr != nil {
+				debug.Log("unable to remove %v from %v: %v", h, saved.Location(), rerr)
+			}
+		}
+
+		return errors.Wrap(err, m.Location())
+	}
+
+	return nil
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset. The members are tried in order until fn returns without an
+// error.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	var firstErr error
+	for _, m := range be.members {
+		err := m.Load(ctx, h, length, offset, fn)
+		if err == nil {
+			return nil
+		}
+
+		debug.Log("Load(%v) failed for %v: %v", h, m.Location(), err)
+		if firstErr == nil {
+			firstErr = err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	return firstErr
+}
+
+// Stat returns information about the file from the first member which
+// contains it.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	var firstErr error
+	for _, m := range be.members {
+		fi, err := m.Stat(ctx, h)
+		if err == nil {
+			return fi, nil
+		}
+
+		debug.Log("Stat(%v) failed for %v: %v", h, m.Location(), err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return restic.FileInfo{}, firstErr
+}
+
+// List runs fn for each file of type t in the first member which can be
+// listed. When listing fails for a member, the remaining files are listed
+// from the next member.
+func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	seen := make(map[string]struct{})
+	var firstErr error
+
+	for _, m := range be.members {
+		fnErr := false
+		err := m.List(ctx, t, func(fi restic.FileInfo) error {
+			if _, ok := seen[fi.Name]; ok {
+				return nil
+			}
+			seen[fi.Name] = struct{}{}
+
+			err := fn(fi)
+			if err != nil {
+				fnErr = true
+			}
+			return err
+		})
+
+		if err == nil || fnErr || ctx.Err() != nil {
+			return err
+		}
+
+		debug.Log("List(%v) failed for %v: %v", t, m.Location(), err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file in
+// one of the members.
+func (be *Backend) IsNotExist(err error) bool {
+	for _, m := range be.members {
+		if m.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete removes all data in all members.
+func (be *Backend) Delete(ctx context.Context) error {
+	var firstErr error
+	for _, m := range be.members {
+		if err := m.Delete(ctx); err != nil && firstErr == nil {
+			firstErr = errors.Wrap(err, m.Location())
+		}
+	}
+	return firstErr
+}
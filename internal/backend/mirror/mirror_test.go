@@ -0,0 +1,202 @@
+package mirror_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/mirror"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+type mirrorConfig struct {
+	members []restic.Backend
+}
+
+func newTestSuite() *test.Suite {
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			return &mirrorConfig{}, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(cfg interface{}) (restic.Backend, error) {
+			c := cfg.(*mirrorConfig)
+			if c.members != nil {
+				be, err := mirror.New(c.members...)
+				if err != nil {
+					return nil, err
+				}
+
+				ok, err := be.Test(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+				if err != nil {
+					return nil, err
+				}
+
+				if ok {
+					return nil, errors.New("config already exists")
+				}
+			}
+
+			c.members = []restic.Backend{mem.New(), mem.New()}
+			return mirror.New(c.members...)
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(cfg interface{}) (restic.Backend, error) {
+			c := cfg.(*mirrorConfig)
+			if c.members == nil {
+				c.members = []restic.Backend{mem.New(), mem.New()}
+			}
+			return mirror.New(c.members...)
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(cfg interface{}) error {
+			// no cleanup needed
+			return nil
+		},
+	}
+}
+
+func TestSuiteBackendMirror(t *testing.T) {
+	newTestSuite().RunTests(t)
+}
+
+func save(t testing.TB, be restic.Backend, data []byte) restic.Handle {
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+	return h
+}
+
+func load(t testing.TB, be restic.Backend, h restic.Handle) []byte {
+	var buf []byte
+	err := be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) (err error) {
+		buf, err = ioutil.ReadAll(rd)
+		return err
+	})
+	rtest.OK(t, err)
+	return buf
+}
+
+func TestMirrorLoad(t *testing.T) {
+	m1, m2 := mem.New(), mem.New()
+	be, err := mirror.New(m1, m2)
+	rtest.OK(t, err)
+
+	data := []byte("foobar")
+	h := save(t, be, data)
+	rtest.Equals(t, data, load(t, m1, h))
+	rtest.Equals(t, data, load(t, m2, h))
+
+	// the file is still available when it is missing in the first member
+	rtest.OK(t, m1.Remove(context.TODO(), h))
+	rtest.Equals(t, data, load(t, be, h))
+
+	fi, err := be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), fi.Size)
+
+	found, err := be.Test(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, found, "file missing in the first member was not found")
+
+	rtest.OK(t, be.Remove(context.TODO(), h))
+	_, err = be.Stat(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "expected a not-exist error, got %v", err)
+
+	err = be.Remove(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "expected a not-exist error, got %v", err)
+}
+
+// failingBackend returns an error for all saved files.
+type failingBackend struct {
+	restic.Backend
+}
+
+func (be failingBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	return errors.New("save failed")
+}
+
+func TestMirrorSaveFailure(t *testing.T) {
+	m1, m2 := mem.New(), mem.New()
+	be, err := mirror.New(m1, failingBackend{m2})
+	rtest.OK(t, err)
+
+	h := restic.Handle{Type: restic.SnapshotFile, Name: restic.NewRandomID().String()}
+	err = be.Save(context.TODO(), h, restic.NewByteReader([]byte("foo")))
+	rtest.Assert(t, err != nil, "expected an error")
+
+	// the file must have been removed from the first member again
+	found, err := m1.Test(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, !found, "file was not removed after a failed save")
+}
+
+func TestMirrorCompareRepair(t *testing.T) {
+	m1, m2, m3 := mem.New(), mem.New(), mem.New()
+	be, err := mirror.New(m1, m2, m3)
+	rtest.OK(t, err)
+
+	config := []byte("config")
+	rtest.OK(t, be.Save(context.TODO(), restic.Handle{Type: restic.ConfigFile}, restic.NewByteReader(config)))
+	intact := save(t, be, []byte("intact"))
+	missing := save(t, be, []byte("missing"))
+	damaged := save(t, be, []byte("damaged"))
+
+	divs, err := be.Compare(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(divs))
+
+	// remove a file from the first member, replace another one in the third
+	// member with a truncated version and remove the config from the second
+	rtest.OK(t, m1.Remove(context.TODO(), missing))
+	rtest.OK(t, m3.Remove(context.TODO(), damaged))
+	rtest.OK(t, m3.Save(context.TODO(), damaged, restic.NewByteReader([]byte("dam"))))
+	rtest.OK(t, m2.Remove(context.TODO(), restic.Handle{Type: restic.ConfigFile}))
+
+	divs, err = be.Compare(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, len(divs))
+
+	handles := make(map[restic.Handle]bool)
+	for _, d := range divs {
+		t.Logf("%v", d)
+		handles[d.Handle] = true
+		rtest.OK(t, be.Repair(context.TODO(), d))
+	}
+	rtest.Assert(t, !handles[intact], "intact file was reported as divergent")
+	rtest.Assert(t, handles[missing], "missing file was not reported")
+	rtest.Assert(t, handles[damaged], "damaged file was not reported")
+
+	divs, err = be.Compare(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(divs))
+
+	rtest.Equals(t, []byte("missing"), load(t, m1, missing))
+	rtest.Equals(t, []byte("damaged"), load(t, m3, damaged))
+	rtest.Equals(t, config, load(t, m2, restic.Handle{Type: restic.ConfigFile}))
+}
+
+func TestMirrorRepairNoIntactCopy(t *testing.T) {
+	m1, m2 := mem.New(), mem.New()
+	be, err := mirror.New(m1, m2)
+	rtest.OK(t, err)
+
+	// the content does not match the file name
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash([]byte("foo")).String()}
+	rtest.OK(t, m1.Save(context.TODO(), h, restic.NewByteReader([]byte("bar"))))
+
+	divs, err := be.Compare(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(divs))
+
+	err = be.Repair(context.TODO(), divs[0])
+	rtest.Assert(t, err != nil, "expected an error for a file without an intact copy")
+}
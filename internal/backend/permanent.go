@@ -0,0 +1,71 @@
+package backend
+
+import (
+	"net/http"
+)
+
+// permanentError marks an error which will occur again when the operation is
+// retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the underlying error, so that errors.Cause still finds the
+// original error.
+func (e *permanentError) Cause() error {
+	return e.err
+}
+
+// Permanent marks err as permanent, so that RetryBackend does not retry the
+// operation. Backends use it for errors like a denied access, where the
+// operation will never succeed.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent returns true if err or one of the errors it wraps was marked
+// with Permanent.
+func IsPermanent(err error) bool {
+	type causer interface {
+		Cause() error
+	}
+
+	for err != nil {
+		if _, ok := err.(*permanentError); ok {
+			return true
+		}
+
+		c, ok := err.(causer)
+		if !ok {
+			return false
+		}
+		err = c.Cause()
+	}
+
+	return false
+}
+
+// ClassifyHTTPError marks err as permanent if the request returned the HTTP
+// status code, which means that the server will reject the request again.
+// This is the case for client errors, except for timeouts and rate limiting.
+// "404 Not Found" is not permanent, it is reported by the IsNotExist method
+// of the backends and may go away for eventually consistent services.
+func ClassifyHTTPError(code int, err error) error {
+	switch code {
+	case http.StatusNotFound, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return err
+	}
+
+	if code >= 400 && code < 500 {
+		return Permanent(err)
+	}
+
+	return err
+}
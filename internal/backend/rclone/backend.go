@@ -0,0 +1,240 @@
+// Package rclone implements a backend which runs rclone (or another program
+// speaking the REST protocol on stdin/stdout) as a subprocess and accesses
+// the repository with the rest backend.
+package rclone
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/rest"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"golang.org/x/net/http2"
+)
+
+// Backend is used to access data stored somewhere via rclone.
+type Backend struct {
+	restic.Backend
+	remote string
+	tr     *http2.Transport
+	cmd    *exec.Cmd
+	conn   *StdioConn
+	waitCh <-chan struct{}
+
+	// waitResult is only valid after waitCh has been closed
+	waitResult error
+}
+
+// make sure that *Backend implements restic.Backend
+var _ restic.Backend = &Backend{}
+
+// run starts the command with args in the background.
+func run(command string, args ...string) (*StdioConn, *exec.Cmd, func() error, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Stderr = os.Stderr
+
+	// the process reads the requests from stdin
+	stdinRd, stdinWr, err := os.Pipe()
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "Pipe")
+	}
+
+	// and writes the responses to stdout
+	stdoutRd, stdoutWr, err := os.Pipe()
+	if err != nil {
+		_ = stdinRd.Close()
+		_ = stdinWr.Close()
+		return nil, nil, nil, errors.Wrap(err, "Pipe")
+	}
+
+	cmd.Stdin = stdinRd
+	cmd.Stdout = stdoutWr
+
+	bg, err := backend.StartForeground(cmd)
+
+	// the child has its own copies of these file descriptors now
+	_ = stdinRd.Close()
+	_ = stdoutWr.Close()
+
+	if err != nil {
+		_ = stdinWr.Close()
+		_ = stdoutRd.Close()
+		return nil, nil, nil, errors.Wrap(err, "cmd.Start")
+	}
+
+	c := &StdioConn{
+		receive: stdoutRd,
+		send:    stdinWr,
+	}
+
+	return c, cmd, bg, nil
+}
+
+// newBackend starts the program and returns a backend which talks to it.
+func newBackend(cfg Config) (*Backend, error) {
+	debug.Log("starting rclone, program %v, args %v, remote %v", cfg.Program, cfg.Args, cfg.Remote)
+
+	program, args, err := backend.SplitShellArgs(cfg.Program + " " + cfg.Args)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, cfg.Remote)
+
+	debug.Log("running command: %v %v", program, args)
+	conn, cmd, bg, err := run(program, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var m sync.Mutex
+	dialCount := 0
+	tr := &http2.Transport{
+		AllowHTTP: true, // this is not really HTTP, just stdin/stdout
+		DialTLS: func(network, address string, cfg *tls.Config) (net.Conn, error) {
+			debug.Log("new connection requested, %v %v", network, address)
+			m.Lock()
+			defer m.Unlock()
+			if dialCount > 0 {
+				// there is only one connection to the process
+				return nil, errors.New("rclone stdio connection already closed")
+			}
+			dialCount++
+			return conn, nil
+		},
+	}
+
+	waitCh := make(chan struct{})
+	be := &Backend{
+		remote: cfg.Remote,
+		tr:     tr,
+		cmd:    cmd,
+		conn:   conn,
+		waitCh: waitCh,
+	}
+
+	go func() {
+		err := cmd.Wait()
+		debug.Log("Wait returned %v", err)
+		be.waitResult = err
+		// the process is gone, outstanding requests cannot be answered
+		_ = conn.Close()
+		close(waitCh)
+	}()
+
+	err = bg()
+	if err != nil {
+		_ = be.Close()
+		return nil, errors.Wrap(err, "bg")
+	}
+
+	// send a request to make sure the process is up and speaks HTTP/2
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodHead, "http://localhost/config", nil)
+	if err != nil {
+		_ = be.Close()
+		return nil, errors.Wrap(err, "NewRequest")
+	}
+
+	res, err := tr.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		_ = be.Close()
+		return nil, errors.Errorf("error talking HTTP to rclone: %v", err)
+	}
+	_ = res.Body.Close()
+
+	debug.Log("HTTP status %q returned, rclone is ready", res.Status)
+	return be, nil
+}
+
+// restConfig returns the config for the rest backend which talks to the
+// process.
+func restConfig(cfg Config) rest.Config {
+	url, err := url.Parse("http://localhost/")
+	if err != nil {
+		panic(err)
+	}
+
+	restCfg := rest.NewConfig()
+	restCfg.URL = url
+	restCfg.Connections = cfg.Connections
+	return restCfg
+}
+
+// Open starts an rclone process with the given config.
+func Open(cfg Config) (*Backend, error) {
+	be, err := newBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	restBackend, err := rest.Open(restConfig(cfg), be.tr)
+	if err != nil {
+		_ = be.Close()
+		return nil, err
+	}
+
+	be.Backend = restBackend
+	return be, nil
+}
+
+// Create initializes a new restic repo with rclone.
+func Create(cfg Config) (*Backend, error) {
+	be, err := newBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	restBackend, err := rest.Create(restConfig(cfg), be.tr)
+	if err != nil {
+		_ = be.Close()
+		return nil, err
+	}
+
+	be.Backend = restBackend
+	return be, nil
+}
+
+// Location returns the location of the repository.
+func (be *Backend) Location() string {
+	return "rclone:" + be.remote
+}
+
+// Close terminates the backend. Closing the connection makes the process
+// exit.
+func (be *Backend) Close() error {
+	debug.Log("exiting rclone")
+	if be.Backend != nil {
+		if err := be.Backend.Close(); err != nil {
+			debug.Log("closing the rest backend returned %v", err)
+		}
+	}
+	be.tr.CloseIdleConnections()
+
+	err := be.conn.Close()
+	if err != nil {
+		debug.Log("closing the connection returned %v", err)
+	}
+
+	select {
+	case <-be.waitCh:
+	case <-time.After(10 * time.Second):
+		debug.Log("rclone did not exit, killing it")
+		_ = be.cmd.Process.Kill()
+		<-be.waitCh
+	}
+
+	debug.Log("wait for rclone returned: %v", be.waitResult)
+	return be.waitResult
+}
@@ -0,0 +1,111 @@
+package rclone
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/local"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/restserver"
+	rtest "github.com/restic/restic/internal/test"
+	"golang.org/x/net/http2"
+)
+
+// standinArg is passed as the first argument when the test binary is run as a
+// stand-in for rclone.
+const standinArg = "serve-rclone-standin"
+
+// TestMain runs the stand-in for rclone, which serves the repository at the
+// path given as the last argument via HTTP/2 on stdin/stdout.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 2 && os.Args[1] == standinArg {
+		serveStandin(os.Args[len(os.Args)-1])
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func serveStandin(dir string) {
+	open := func(ctx context.Context, path string, create bool) (restic.Backend, error) {
+		cfg := local.Config{Path: dir}
+		if create {
+			return local.Create(cfg)
+		}
+		return local.Open(cfg)
+	}
+
+	srv, err := restserver.New(open, restserver.Config{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	conn := &StdioConn{receive: os.Stdin, send: os.Stdout}
+	h2 := &http2.Server{}
+	h2.ServeConn(conn, &http2.ServeConnOpts{Handler: srv})
+
+	_ = srv.Close()
+}
+
+func newTestSuite(t testing.TB) *test.Suite {
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			dir, err := ioutil.TempDir(rtest.TestTempDir, "restic-test-rclone-")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Logf("create new backend at %v", dir)
+
+			cfg := NewConfig()
+			cfg.Program = os.Args[0]
+			cfg.Args = standinArg
+			cfg.Remote = dir
+			return cfg, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(config interface{}) (restic.Backend, error) {
+			return Create(config.(Config))
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(config interface{}) (restic.Backend, error) {
+			return Open(config.(Config))
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(config interface{}) error {
+			cfg := config.(Config)
+			if !rtest.TestCleanupTempDirs {
+				t.Logf("leaving test backend dir at %v", cfg.Remote)
+			}
+
+			rtest.RemoveAll(t, cfg.Remote)
+			return nil
+		},
+	}
+}
+
+func TestBackendRclone(t *testing.T) {
+	newTestSuite(t).RunTests(t)
+}
+
+func TestRcloneExit(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Program = os.Args[0]
+	cfg.Args = "-test.run=^$ -invalid-flag"
+	cfg.Remote = "foo"
+
+	_, err := Open(cfg)
+	if err == nil {
+		t.Fatal("expected an error for a program which does not speak HTTP")
+	}
+	t.Logf("Open returned %v", err)
+}
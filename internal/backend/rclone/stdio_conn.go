@@ -0,0 +1,86 @@
+package rclone
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+)
+
+// StdioConn implements a net.Conn via stdin/stdout of a process. Data is
+// received from receive (the process's stdout) and sent to send (the
+// process's stdin).
+type StdioConn struct {
+	receive *os.File
+	send    *os.File
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (s *StdioConn) Read(p []byte) (int, error) {
+	return s.receive.Read(p)
+}
+
+func (s *StdioConn) Write(p []byte) (int, error) {
+	return s.send.Write(p)
+}
+
+// Close closes both streams.
+func (s *StdioConn) Close() error {
+	s.closeOnce.Do(func() {
+		debug.Log("close stdio connection")
+		err1 := s.send.Close()
+		err2 := s.receive.Close()
+		if err1 != nil {
+			s.closeErr = err1
+		} else {
+			s.closeErr = err2
+		}
+	})
+
+	return s.closeErr
+}
+
+// LocalAddr returns a dummy address.
+func (s *StdioConn) LocalAddr() net.Addr {
+	return Addr{}
+}
+
+// RemoteAddr returns a dummy address.
+func (s *StdioConn) RemoteAddr() net.Addr {
+	return Addr{}
+}
+
+// SetDeadline does nothing, deadlines are not supported.
+func (s *StdioConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+// SetReadDeadline does nothing, deadlines are not supported.
+func (s *StdioConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline does nothing, deadlines are not supported.
+func (s *StdioConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// make sure StdioConn implements net.Conn
+var _ net.Conn = &StdioConn{}
+
+// Addr implements net.Addr for stdin/stdout.
+type Addr struct{}
+
+// Network returns the network type as a string.
+func (a Addr) Network() string {
+	return "stdio"
+}
+
+// String returns the address as a string.
+func (a Addr) String() string {
+	return "stdio"
+}
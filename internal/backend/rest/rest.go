@@ -143,7 +143,7 @@ func (b *restBackend) Save(ctx context.Context, h restic.Handle, rd restic.Rewin
 	}
 
 	if resp.StatusCode != 200 {
-		return errors.Errorf("server response unexpected: %v (%v)", resp.Status, resp.StatusCode)
+		return backend.ClassifyHTTPError(resp.StatusCode, errors.Errorf("server response unexpected: %v (%v)", resp.Status, resp.StatusCode))
 	}
 
 	return nil
@@ -218,7 +218,7 @@ func (b *restBackend) openReader(ctx context.Context, h restic.Handle, length in
 
 	if resp.StatusCode != 200 && resp.StatusCode != 206 {
 		_ = resp.Body.Close()
-		return nil, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status)
+		return nil, backend.ClassifyHTTPError(resp.StatusCode, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status))
 	}
 
 	return resp.Body, nil
@@ -254,7 +254,7 @@ func (b *restBackend) Stat(ctx context.Context, h restic.Handle) (restic.FileInf
 	}
 
 	if resp.StatusCode != 200 {
-		return restic.FileInfo{}, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status)
+		return restic.FileInfo{}, backend.ClassifyHTTPError(resp.StatusCode, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status))
 	}
 
 	if resp.ContentLength < 0 {
@@ -305,7 +305,7 @@ func (b *restBackend) Remove(ctx context.Context, h restic.Handle) error {
 	}
 
 	if resp.StatusCode != 200 {
-		return errors.Errorf("blob not removed, server response: %v (%v)", resp.Status, resp.StatusCode)
+		return backend.ClassifyHTTPError(resp.StatusCode, errors.Errorf("blob not removed, server response: %v (%v)", resp.Status, resp.StatusCode))
 	}
 
 	_, err = io.Copy(ioutil.Discard, resp.Body)
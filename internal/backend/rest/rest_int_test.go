@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/backend/rest"
 	"github.com/restic/restic/internal/restic"
 )
@@ -148,3 +149,51 @@ func TestListAPI(t *testing.T) {
 		})
 	}
 }
+
+func TestPermanentErrors(t *testing.T) {
+	var tests = []struct {
+		Status    int
+		Permanent bool
+	}{
+		{http.StatusForbidden, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+	}
+
+	for _, test := range tests {
+		t.Run(strconv.Itoa(test.Status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				res.WriteHeader(test.Status)
+			}))
+			defer srv.Close()
+
+			srvURL, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			be, err := rest.Open(rest.Config{Connections: 5, URL: srvURL}, http.DefaultTransport)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := restic.Handle{Type: restic.SnapshotFile, Name: restic.NewRandomID().String()}
+			errs := []error{
+				be.Save(context.TODO(), h, restic.NewByteReader([]byte("foo"))),
+				be.Remove(context.TODO(), h),
+			}
+			_, err = be.Stat(context.TODO(), h)
+			errs = append(errs, err)
+
+			for _, err := range errs {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				if backend.IsPermanent(err) != test.Permanent {
+					t.Errorf("error %v: want permanent %v, got %v", err, test.Permanent, backend.IsPermanent(err))
+				}
+			}
+		})
+	}
+}
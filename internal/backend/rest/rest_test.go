@@ -3,16 +3,21 @@ package rest_test
 import (
 	"context"
 	"net"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"os/exec"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
 	"github.com/restic/restic/internal/backend/rest"
 	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/restserver"
 	rtest "github.com/restic/restic/internal/test"
 )
 
@@ -121,6 +126,53 @@ func TestBackendREST(t *testing.T) {
 	newTestSuite(ctx, t, serverURL, false).RunTests(t)
 }
 
+// runInProcessServer starts a REST server in-process which stores the
+// repositories in memory.
+func runInProcessServer(t testing.TB) (*url.URL, func()) {
+	var m sync.Mutex
+	repos := make(map[string]restic.Backend)
+
+	open := func(ctx context.Context, path string, create bool) (restic.Backend, error) {
+		m.Lock()
+		defer m.Unlock()
+
+		be, ok := repos[path]
+		if !ok && create {
+			be = mem.New()
+			repos[path] = be
+		}
+
+		if be == nil {
+			return nil, errors.Errorf("repository %q does not exist", path)
+		}
+		return be, nil
+	}
+
+	srv, err := restserver.New(open, restserver.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(srv)
+
+	url, err := url.Parse(ts.URL + "/restic-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return url, ts.Close
+}
+
+func TestBackendRESTInProcess(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverURL, cleanup := runInProcessServer(t)
+	defer cleanup()
+
+	newTestSuite(ctx, t, serverURL, false).RunTests(t)
+}
+
 func TestBackendRESTExternalServer(t *testing.T) {
 	repostr := os.Getenv("RESTIC_TEST_REST_REPOSITORY")
 	if repostr == "" {
@@ -151,6 +151,16 @@ func (be *Backend) IsNotExist(err error) bool {
 	return false
 }
 
+// classifyError marks err as permanent if the server rejected the request
+// with a client error, so that it is not retried.
+func classifyError(err error) error {
+	if e, ok := errors.Cause(err).(minio.ErrorResponse); ok {
+		return backend.ClassifyHTTPError(e.StatusCode, err)
+	}
+
+	return err
+}
+
 // Join combines path components with slashes.
 func (be *Backend) Join(p ...string) string {
 	return path.Join(p...)
@@ -260,7 +270,7 @@ func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindRe
 
 	debug.Log("%v -> %v bytes, err %#v: %v", objName, n, err, err)
 
-	return errors.Wrap(err, "client.PutObject")
+	return classifyError(errors.Wrap(err, "client.PutObject"))
 }
 
 // wrapReader wraps an io.ReadCloser to run an additional function on Close.
@@ -316,7 +326,7 @@ func (be *Backend) openReader(ctx context.Context, h restic.Handle, length int,
 	rd, err := coreClient.GetObjectWithContext(ctx, be.cfg.Bucket, objName, opts)
 	if err != nil {
 		be.sem.ReleaseToken()
-		return nil, err
+		return nil, classifyError(err)
 	}
 
 	closeRd := wrapReader{
@@ -344,7 +354,7 @@ func (be *Backend) Stat(ctx context.Context, h restic.Handle) (bi restic.FileInf
 	if err != nil {
 		debug.Log("GetObject() err %v", err)
 		be.sem.ReleaseToken()
-		return restic.FileInfo{}, errors.Wrap(err, "client.GetObject")
+		return restic.FileInfo{}, classifyError(errors.Wrap(err, "client.GetObject"))
 	}
 
 	// make sure that the object is closed properly.
@@ -359,7 +369,7 @@ func (be *Backend) Stat(ctx context.Context, h restic.Handle) (bi restic.FileInf
 	fi, err := obj.Stat()
 	if err != nil {
 		debug.Log("Stat() err %v", err)
-		return restic.FileInfo{}, errors.Wrap(err, "Stat")
+		return restic.FileInfo{}, classifyError(errors.Wrap(err, "Stat"))
 	}
 
 	return restic.FileInfo{Size: fi.Size, Name: h.Name}, nil
@@ -396,7 +406,7 @@ func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
 		err = nil
 	}
 
-	return errors.Wrap(err, "client.RemoveObject")
+	return classifyError(errors.Wrap(err, "client.RemoveObject"))
 }
 
 // List runs fn for each file in the backend which has the type t. When an
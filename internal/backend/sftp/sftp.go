@@ -33,6 +33,7 @@ type SFTP struct {
 }
 
 var _ restic.Backend = &SFTP{}
+var _ backend.StaleTempFileRemover = &SFTP{}
 
 const defaultLayout = "default"
 
@@ -65,7 +66,7 @@ func startClient(program string, args ...string) (*SFTP, error) {
 		return nil, errors.Wrap(err, "cmd.StdoutPipe")
 	}
 
-	bg, err := startForeground(cmd)
+	bg, err := backend.StartForeground(cmd)
 	if err != nil {
 		return nil, errors.Wrap(err, "cmd.Start")
 	}
@@ -179,7 +180,7 @@ func (r *SFTP) IsNotExist(err error) bool {
 
 func buildSSHCommand(cfg Config) (cmd string, args []string, err error) {
 	if cfg.Command != "" {
-		return SplitShellArgs(cfg.Command)
+		return backend.SplitShellArgs(cfg.Command)
 	}
 
 	cmd = "ssh"
@@ -281,8 +282,11 @@ func Join(parts ...string) string {
 	return path.Clean(path.Join(parts...))
 }
 
-// Save stores data in the backend at the handle.
-func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+// Save stores data in the backend at the handle. The data is written to a
+// temporary file first, which is renamed after it has been closed, so that an
+// interrupted upload never leaves an incomplete file under the final name.
+// The sftp client does not support syncing the file to disk on the server.
+func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) (err error) {
 	debug.Log("Save %v", h)
 	if err := r.clientError(); err != nil {
 		return err
@@ -293,9 +297,10 @@ func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader
 	}
 
 	filename := r.Filename(h)
+	tmpFilename := backend.TempFilename(filename)
 
-	// create new file
-	f, err := r.c.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+	// create new temporary file
+	f, err := r.c.OpenFile(tmpFilename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
 
 	if r.IsNotExist(err) {
 		// error is caused by a missing directory, try to create it
@@ -304,7 +309,7 @@ func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader
 			debug.Log("error creating dir %v: %v", r.Dirname(h), mkdirErr)
 		} else {
 			// try again
-			f, err = r.c.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+			f, err = r.c.OpenFile(tmpFilename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
 		}
 	}
 
@@ -312,6 +317,15 @@ func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader
 		return errors.Wrap(err, "OpenFile")
 	}
 
+	defer func() {
+		if err != nil {
+			// remove the incomplete temporary file
+			if rerr := r.c.Remove(tmpFilename); rerr != nil {
+				debug.Log("unable to remove %v: %v", tmpFilename, rerr)
+			}
+		}
+	}()
+
 	// save data
 	_, err = io.Copy(f, rd)
 	if err != nil {
@@ -324,7 +338,12 @@ func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader
 		return errors.Wrap(err, "Close")
 	}
 
-	return errors.Wrap(r.c.Chmod(filename, backend.Modes.File), "Chmod")
+	err = r.c.Chmod(tmpFilename, backend.Modes.File)
+	if err != nil {
+		return errors.Wrap(err, "Chmod")
+	}
+
+	return errors.Wrap(r.c.Rename(tmpFilename, filename), "Rename")
 }
 
 // Load runs fn with a reader that yields the contents of the file at h at the
@@ -437,6 +456,12 @@ func (r *SFTP) List(ctx context.Context, t restic.FileType, fn func(restic.FileI
 			continue
 		}
 
+		if backend.IsTempFilename(path.Base(walker.Path())) {
+			// ignore temporary files, the ones left over from interrupted
+			// uploads are removed by RemoveStaleTempFiles
+			continue
+		}
+
 		debug.Log("send %v\n", path.Base(walker.Path()))
 
 		rfi := restic.FileInfo{
@@ -520,6 +545,41 @@ func (r *SFTP) deleteRecursive(name string) error {
 	return nil
 }
 
+// RemoveStaleTempFiles removes the temporary files left over from interrupted
+// uploads and returns the number of removed files. It must only be called
+// while no other process writes to the repository.
+func (r *SFTP) RemoveStaleTempFiles(ctx context.Context) (int, error) {
+	if err := r.clientError(); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	walker := r.c.Walk(r.p)
+	for walker.Step() {
+		if walker.Err() != nil {
+			return removed, walker.Err()
+		}
+
+		if ctx.Err() != nil {
+			return removed, ctx.Err()
+		}
+
+		fi := walker.Stat()
+		if !fi.Mode().IsRegular() || !backend.IsTempFilename(path.Base(walker.Path())) ||
+			time.Since(fi.ModTime()) < backend.StaleTempFileAge {
+			continue
+		}
+
+		debug.Log("remove stale temporary file %v", walker.Path())
+		if err := r.c.Remove(walker.Path()); err != nil {
+			return removed, errors.Wrap(err, "Remove")
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
 // Delete removes all data in the backend.
 func (r *SFTP) Delete(context.Context) error {
 	return r.deleteRecursive(r.p)
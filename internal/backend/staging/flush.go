@@ -0,0 +1,174 @@
+package staging
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/backend"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// flushOrder is the order in which pending files are uploaded. Snapshots
+// must only be uploaded when the indexes for their blobs are present, and
+// indexes only when the packs they reference are present.
+var flushOrder = []restic.FileType{
+	restic.DataFile,
+	restic.IndexFile,
+	restic.KeyFile,
+	restic.SnapshotFile,
+}
+
+// Pending returns the number of files in the spool directory which still
+// need to be uploaded.
+func (be *Backend) Pending() (int, error) {
+	n := 0
+	for _, t := range flushOrder {
+		err := be.listDir(pendingDir, t, func(restic.FileInfo) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// ListPending runs fn for each file of type t in the spool directory which
+// still needs to be uploaded.
+func (be *Backend) ListPending(t restic.FileType, fn func(restic.FileInfo) error) error {
+	return be.listDir(pendingDir, t, fn)
+}
+
+// Flush uploads all pending files to the backend, the packs first, then the
+// indexes and then the snapshots. Uploaded files are removed from the spool
+// directory. Pending locks are left over from runs which could not reach the
+// backend, they are removed without uploading them. The number of uploaded
+// files is returned.
+func (be *Backend) Flush(ctx context.Context, report func(restic.Handle)) (int, error) {
+	if !be.Online() {
+		return 0, errors.New("the backend is unreachable")
+	}
+
+	n := 0
+	for _, t := range flushOrder {
+		var handles []restic.Handle
+		err := be.listDir(pendingDir, t, func(fi restic.FileInfo) error {
+			handles = append(handles, restic.Handle{Type: t, Name: fi.Name})
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+
+		if len(handles) == 0 {
+			continue
+		}
+
+		// an earlier, interrupted flush may have uploaded some of the files
+		// already, list them once instead of testing each file
+		remote := make(map[string]int64)
+		err = be.Backend.List(ctx, t, func(fi restic.FileInfo) error {
+			remote[fi.Name] = fi.Size
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+
+		for _, h := range handles {
+			if ctx.Err() != nil {
+				return n, ctx.Err()
+			}
+
+			if err := be.upload(ctx, h, remote); err != nil {
+				return n, err
+			}
+
+			n++
+			if report != nil {
+				report(h)
+			}
+		}
+	}
+
+	err := be.listDir(pendingDir, restic.LockFile, func(fi restic.FileInfo) error {
+		return fs.Remove(be.filename(pendingDir, restic.Handle{Type: restic.LockFile, Name: fi.Name}))
+	})
+	if err != nil {
+		return n, errors.Wrap(err, "Remove")
+	}
+
+	return n, nil
+}
+
+// upload saves the pending file h in the backend and removes it from the
+// spool directory. remote contains the sizes of the files of the same type in
+// the backend.
+func (be *Backend) upload(ctx context.Context, h restic.Handle, remote map[string]int64) error {
+	filename := be.filename(pendingDir, h)
+	f, err := fs.Open(filename)
+	if err != nil {
+		return errors.Wrap(err, "Open")
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "Stat")
+	}
+
+	// an earlier, interrupted upload may have left a partial file
+	size, ok := remote[h.Name]
+	switch {
+	case ok && size == fi.Size():
+		debug.Log("%v was already uploaded", h)
+		_ = f.Close()
+		return be.finishUpload(h, filename)
+	case ok:
+		debug.Log("removing incomplete upload of %v", h)
+		if err := be.Backend.Remove(ctx, h); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+
+	rd, err := restic.NewFileReader(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	debug.Log("upload %v", h)
+	err = be.Backend.Save(ctx, h, rd)
+	if cerr := f.Close(); err == nil {
+		err = errors.Wrap(cerr, "Close")
+	}
+	if err != nil {
+		return err
+	}
+
+	return be.finishUpload(h, filename)
+}
+
+// finishUpload moves the uploaded file to the copies directory or removes it.
+func (be *Backend) finishUpload(h restic.Handle, filename string) error {
+	if copiedTypes[h.Type] {
+		dst := be.filename(copiesDir, h)
+		if err := fs.MkdirAll(filepath.Dir(dst), backend.Modes.Dir); err != nil {
+			return errors.Wrap(err, "MkdirAll")
+		}
+		return errors.Wrap(fs.Rename(filename, dst), "Rename")
+	}
+
+	err := fs.Remove(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "Remove")
+	}
+	return nil
+}
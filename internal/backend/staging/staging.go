@@ -0,0 +1,492 @@
+// Package staging implements a backend which saves files in a local spool
+// directory while the real backend is unreachable, so that they can be
+// uploaded later.
+package staging
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// The spool directory contains two subdirectories:
+//
+//	pending/<type>/<name>   files which still need to be uploaded
+//	copies/<type>/<name>    copies of the config, keys, indexes and snapshots
+//
+// The copies are kept up to date while the backend is reachable, they allow
+// opening the repository and finding the existing blobs while it is not.
+const (
+	pendingDir = "pending"
+	copiesDir  = "copies"
+)
+
+// copiedTypes are the file types of which local copies are kept.
+var copiedTypes = map[restic.FileType]bool{
+	restic.ConfigFile:   true,
+	restic.KeyFile:      true,
+	restic.IndexFile:    true,
+	restic.SnapshotFile: true,
+}
+
+// Backend saves files in the spool directory when the wrapped backend is
+// unreachable. Files from the spool directory are returned together with the
+// files in the backend.
+type Backend struct {
+	restic.Backend
+	dir string
+
+	m       sync.Mutex
+	offline bool
+}
+
+// make sure that *Backend implements restic.Backend
+var _ restic.Backend = &Backend{}
+
+// probeTimeout is the time after which the backend is considered unreachable
+// when it does not answer the first request.
+var probeTimeout = 15 * time.Second
+
+// TestSetProbeTimeout can be used to reduce the probe timeout for tests.
+func TestSetProbeTimeout(t testing.TB, d time.Duration) {
+	t.Logf("setting probe timeout to %v", d)
+	probeTimeout = d
+}
+
+// New returns a backend which uses dir as the spool directory for be. The
+// directory must only be used for a single repository, this is checked with
+// repo, the repository location given by the user.
+//
+// Whether the backend is reachable is tested with a single request to probe,
+// which is aborted after a short timeout. It should be the same backend as be,
+// but without retrying failed requests, which would delay noticing an
+// unreachable backend. If probe is nil, be is used.
+func New(ctx context.Context, be, probe restic.Backend, dir, repo string) (*Backend, error) {
+	if err := fs.MkdirAll(dir, backend.Modes.Dir); err != nil {
+		return nil, errors.Wrap(err, "MkdirAll")
+	}
+
+	locationFile := filepath.Join(dir, "location")
+	buf, err := ioutil.ReadFile(locationFile)
+	switch {
+	case os.IsNotExist(err):
+		err = ioutil.WriteFile(locationFile, []byte(repo), backend.Modes.File)
+		if err != nil {
+			return nil, errors.Wrap(err, "WriteFile")
+		}
+	case err != nil:
+		return nil, errors.Wrap(err, "ReadFile")
+	case string(buf) != repo:
+		return nil, errors.Errorf("staging directory %v is used for the repository at %v", dir, buf)
+	}
+
+	sb := &Backend{Backend: be, dir: dir}
+
+	if probe == nil {
+		probe = be
+	}
+
+	// probe the backend and update the local copy of the config
+	h := restic.Handle{Type: restic.ConfigFile}
+	probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	_, err = probe.Stat(probeCtx, h)
+	cancel()
+
+	switch {
+	case err == nil:
+		if _, err := os.Stat(sb.filename(copiesDir, h)); os.IsNotExist(err) {
+			sb.updateCopy(ctx, h)
+		}
+	case probe.IsNotExist(err):
+		// the repository does not exist yet
+	case backend.IsPermanent(err):
+		// the backend is reachable, but refuses the request, the error is
+		// returned again when the config is loaded
+		debug.Log("Stat(%v) returned permanent error: %v", h, err)
+	default:
+		debug.Log("backend %v is unreachable: %v", be.Location(), err)
+		sb.offline = true
+	}
+
+	return sb, nil
+}
+
+// Online returns false when the backend was unreachable.
+func (be *Backend) Online() bool {
+	be.m.Lock()
+	defer be.m.Unlock()
+	return !be.offline
+}
+
+func (be *Backend) setOffline(err error) {
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	if !be.offline {
+		debug.Log("backend %v is unreachable, using the staging directory: %v", be.Backend.Location(), err)
+	}
+	be.offline = true
+}
+
+func (be *Backend) filename(area string, h restic.Handle) string {
+	name := h.Name
+	if h.Type == restic.ConfigFile {
+		name = "config"
+	}
+	return filepath.Join(be.dir, area, string(h.Type), name)
+}
+
+// writeFile atomically writes the data from rd to filename.
+func writeFile(filename string, rd io.Reader) error {
+	if err := fs.MkdirAll(filepath.Dir(filename), backend.Modes.Dir); err != nil {
+		return errors.Wrap(err, "MkdirAll")
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(filename), "tmp-")
+	if err != nil {
+		return errors.Wrap(err, "TempFile")
+	}
+
+	_, err = io.Copy(f, rd)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = fs.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		_ = fs.Remove(f.Name())
+		return errors.Wrap(err, "write")
+	}
+
+	return nil
+}
+
+// updateCopy downloads the file h and stores it in the copies directory.
+func (be *Backend) updateCopy(ctx context.Context, h restic.Handle) {
+	err := be.Backend.Load(ctx, h, 0, 0, func(rd io.Reader) error {
+		return writeFile(be.filename(copiesDir, h), rd)
+	})
+	if err != nil {
+		debug.Log("unable to update copy of %v: %v", h, err)
+	}
+}
+
+// localFile returns the name of the local file for h, if it exists. Pending
+// files are always used, copies only when the backend is unreachable.
+func (be *Backend) localFile(h restic.Handle) (string, bool) {
+	filename := be.filename(pendingDir, h)
+	if _, err := os.Stat(filename); err == nil {
+		return filename, true
+	}
+
+	if be.Online() || !copiedTypes[h.Type] {
+		return "", false
+	}
+
+	filename = be.filename(copiesDir, h)
+	if _, err := os.Stat(filename); err == nil {
+		return filename, true
+	}
+
+	return "", false
+}
+
+// isUnreachable returns true if err means that the backend cannot be
+// reached, e.g. because of a network error. Permanent errors like a denied
+// access are returned by a reachable backend and are real failures.
+func (be *Backend) isUnreachable(ctx context.Context, err error) bool {
+	return err != nil && !be.Backend.IsNotExist(err) && !backend.IsPermanent(err) && ctx.Err() == nil
+}
+
+// Save saves the file in the backend. When this fails, it is saved in the
+// spool directory instead.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if err := h.Valid(); err != nil {
+		return err
+	}
+
+	if be.Online() {
+		err := be.Backend.Save(ctx, h, rd)
+		if err == nil {
+			if copiedTypes[h.Type] {
+				if err := rd.Rewind(); err == nil {
+					_ = writeFile(be.filename(copiesDir, h), rd)
+				}
+			}
+			return nil
+		}
+
+		if !be.isUnreachable(ctx, err) {
+			return err
+		}
+		be.setOffline(err)
+
+		if err := rd.Rewind(); err != nil {
+			return err
+		}
+	}
+
+	debug.Log("saving %v in the staging directory", h)
+	return writeFile(be.filename(pendingDir, h), rd)
+}
+
+// Remove removes the file from the spool directory and the backend.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	if err := h.Valid(); err != nil {
+		return err
+	}
+
+	_ = fs.Remove(be.filename(copiesDir, h))
+
+	err := fs.Remove(be.filename(pendingDir, h))
+	if err == nil {
+		// pending files were never uploaded
+		return nil
+	}
+
+	if !os.IsNotExist(err) {
+		return errors.Wrap(err, "Remove")
+	}
+
+	return be.Backend.Remove(ctx, h)
+}
+
+// errOffline is returned for files which are not available locally while the
+// backend is unreachable.
+var errOffline = errors.Wrap(os.ErrNotExist, "backend is unreachable and file is not in the staging directory")
+
+// Test returns true if the file exists in the spool directory or the backend.
+func (be *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	if err := h.Valid(); err != nil {
+		return false, err
+	}
+
+	if _, ok := be.localFile(h); ok {
+		return true, nil
+	}
+
+	if !be.Online() {
+		return false, nil
+	}
+
+	found, err := be.Backend.Test(ctx, h)
+	if be.isUnreachable(ctx, err) {
+		be.setOffline(err)
+		_, ok := be.localFile(h)
+		return ok, nil
+	}
+
+	return found, err
+}
+
+// Stat returns information about the file.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	if err := h.Valid(); err != nil {
+		return restic.FileInfo{}, err
+	}
+
+	if filename, ok := be.localFile(h); ok {
+		return statFile(h, filename)
+	}
+
+	if !be.Online() {
+		return restic.FileInfo{}, errOffline
+	}
+
+	fi, err := be.Backend.Stat(ctx, h)
+	if be.isUnreachable(ctx, err) {
+		be.setOffline(err)
+		if filename, ok := be.localFile(h); ok {
+			return statFile(h, filename)
+		}
+	}
+
+	return fi, err
+}
+
+func statFile(h restic.Handle, filename string) (restic.FileInfo, error) {
+	fi, err := fs.Stat(filename)
+	if err != nil {
+		return restic.FileInfo{}, errors.Wrap(err, "Stat")
+	}
+
+	return restic.FileInfo{Name: h.Name, Size: fi.Size()}, nil
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	if err := h.Valid(); err != nil {
+		return err
+	}
+
+	if offset < 0 {
+		return errors.New("offset is negative")
+	}
+
+	if length < 0 {
+		return errors.Errorf("invalid length %d", length)
+	}
+
+	if filename, ok := be.localFile(h); ok {
+		return loadFile(filename, length, offset, fn)
+	}
+
+	err := be.Backend.Load(ctx, h, length, offset, fn)
+	if be.isUnreachable(ctx, err) {
+		be.setOffline(err)
+		if filename, ok := be.localFile(h); ok {
+			return loadFile(filename, length, offset, fn)
+		}
+	}
+
+	return err
+}
+
+func loadFile(filename string, length int, offset int64, fn func(rd io.Reader) error) error {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return errors.Wrap(err, "ReadFile")
+	}
+
+	if offset > int64(len(buf)) {
+		return errors.Errorf("offset %d is beyond the end of the file", offset)
+	}
+	buf = buf[offset:]
+
+	if length > 0 && length < len(buf) {
+		buf = buf[:length]
+	}
+
+	return fn(bytes.NewReader(buf))
+}
+
+// listDir runs fn for all files in the directory for type t in area.
+func (be *Backend) listDir(area string, t restic.FileType, fn func(restic.FileInfo) error) error {
+	dir := filepath.Dir(be.filename(area, restic.Handle{Type: t, Name: "x"}))
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "ReadDir")
+	}
+
+	for _, fi := range entries {
+		if !fi.Mode().IsRegular() || len(fi.Name()) != 64 {
+			// skip temporary files
+			continue
+		}
+
+		if err := fn(restic.FileInfo{Name: fi.Name(), Size: fi.Size()}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// List runs fn for each file of type t in the spool directory and the
+// backend.
+func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	seen := make(map[string]struct{})
+	report := func(fi restic.FileInfo) error {
+		if _, ok := seen[fi.Name]; ok {
+			return nil
+		}
+		seen[fi.Name] = struct{}{}
+		return fn(fi)
+	}
+
+	if err := be.listDir(pendingDir, t, report); err != nil {
+		return err
+	}
+
+	if be.Online() {
+		// remember the files in the backend to update the copies
+		remote := make(map[string]struct{})
+		fnErr := false
+		err := be.Backend.List(ctx, t, func(fi restic.FileInfo) error {
+			remote[fi.Name] = struct{}{}
+			if err := report(fi); err != nil {
+				fnErr = true
+				return err
+			}
+			return nil
+		})
+
+		if err == nil {
+			if copiedTypes[t] {
+				be.updateCopies(ctx, t, remote)
+			}
+			return nil
+		}
+
+		if fnErr || !be.isUnreachable(ctx, err) {
+			return err
+		}
+		be.setOffline(err)
+	}
+
+	if !copiedTypes[t] {
+		return nil
+	}
+
+	return be.listDir(copiesDir, t, report)
+}
+
+// updateCopies removes the copies of files which are not in remote, and
+// downloads the missing ones.
+func (be *Backend) updateCopies(ctx context.Context, t restic.FileType, remote map[string]struct{}) {
+	local := make(map[string]struct{})
+	err := be.listDir(copiesDir, t, func(fi restic.FileInfo) error {
+		local[fi.Name] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		debug.Log("unable to list copies of type %v: %v", t, err)
+		return
+	}
+
+	for name := range local {
+		if _, ok := remote[name]; !ok {
+			_ = fs.Remove(be.filename(copiesDir, restic.Handle{Type: t, Name: name}))
+		}
+	}
+
+	for name := range remote {
+		if _, ok := local[name]; !ok && ctx.Err() == nil {
+			be.updateCopy(ctx, restic.Handle{Type: t, Name: name})
+		}
+	}
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file.
+func (be *Backend) IsNotExist(err error) bool {
+	return be.Backend.IsNotExist(err) || os.IsNotExist(errors.Cause(err))
+}
+
+// Delete removes all data in the backend and the spool directory.
+func (be *Backend) Delete(ctx context.Context) error {
+	if err := be.Backend.Delete(ctx); err != nil {
+		return err
+	}
+
+	return fs.RemoveAll(be.dir)
+}
@@ -0,0 +1,304 @@
+package staging_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/staging"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+type stagingConfig struct {
+	dir string
+	be  restic.Backend
+}
+
+func newTestSuite(t testing.TB) *test.Suite {
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			dir, err := ioutil.TempDir(rtest.TestTempDir, "restic-test-staging-")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return &stagingConfig{dir: dir}, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(cfg interface{}) (restic.Backend, error) {
+			c := cfg.(*stagingConfig)
+			if c.be != nil {
+				ok, err := c.be.Test(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+				if err != nil {
+					return nil, err
+				}
+
+				if ok {
+					return nil, errors.New("config already exists")
+				}
+			}
+
+			c.be = mem.New()
+			return staging.New(context.TODO(), c.be, nil, c.dir, "test")
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(cfg interface{}) (restic.Backend, error) {
+			c := cfg.(*stagingConfig)
+			if c.be == nil {
+				c.be = mem.New()
+			}
+			return staging.New(context.TODO(), c.be, nil, c.dir, "test")
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(cfg interface{}) error {
+			rtest.RemoveAll(t, cfg.(*stagingConfig).dir)
+			return nil
+		},
+	}
+}
+
+func TestSuiteBackendStaging(t *testing.T) {
+	newTestSuite(t).RunTests(t)
+}
+
+// switchableBackend returns errors for all operations while offline is set,
+// and records the order in which files are saved and the number of failed
+// operations.
+type switchableBackend struct {
+	restic.Backend
+	offline bool
+	err     error
+	saved   []restic.Handle
+	failed  int
+}
+
+var errNetwork = errors.New("network is unreachable")
+
+// fail returns the error for an operation while offline is set, which is
+// errNetwork unless err is set.
+func (be *switchableBackend) fail() error {
+	be.failed++
+	if be.err != nil {
+		return be.err
+	}
+	return errNetwork
+}
+
+func (be *switchableBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if be.offline {
+		return be.fail()
+	}
+	be.saved = append(be.saved, h)
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func (be *switchableBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	if be.offline {
+		return be.fail()
+	}
+	return be.Backend.Load(ctx, h, length, offset, fn)
+}
+
+func (be *switchableBackend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	if be.offline {
+		return restic.FileInfo{}, be.fail()
+	}
+	return be.Backend.Stat(ctx, h)
+}
+
+func (be *switchableBackend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	if be.offline {
+		return false, be.fail()
+	}
+	return be.Backend.Test(ctx, h)
+}
+
+func (be *switchableBackend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	if be.offline {
+		return be.fail()
+	}
+	return be.Backend.List(ctx, t, fn)
+}
+
+func save(t testing.TB, be restic.Backend, tpe restic.FileType, data string) restic.Handle {
+	h := restic.Handle{Type: tpe, Name: restic.Hash([]byte(data)).String()}
+	if tpe == restic.ConfigFile {
+		h.Name = ""
+	}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader([]byte(data))))
+	return h
+}
+
+func list(t testing.TB, be restic.Backend, tpe restic.FileType) []string {
+	var names []string
+	rtest.OK(t, be.List(context.TODO(), tpe, func(fi restic.FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	}))
+	sort.Strings(names)
+	return names
+}
+
+func load(t testing.TB, be restic.Backend, h restic.Handle) string {
+	var buf []byte
+	err := be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) (err error) {
+		buf, err = ioutil.ReadAll(rd)
+		return err
+	})
+	rtest.OK(t, err)
+	return string(buf)
+}
+
+func TestStagingOffline(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	remote := &switchableBackend{Backend: mem.New()}
+
+	// create the repository while the backend is reachable
+	be, err := staging.New(context.TODO(), remote, nil, dir, "test")
+	rtest.OK(t, err)
+	rtest.Assert(t, be.Online(), "backend should be online")
+
+	config := save(t, be, restic.ConfigFile, "config")
+	oldIndex := save(t, be, restic.IndexFile, "old index")
+	rtest.OK(t, be.Close())
+
+	remote.offline = true
+	remote.saved = nil
+
+	// the repository can be used while the backend is unreachable
+	be, err = staging.New(context.TODO(), remote, nil, dir, "test")
+	rtest.OK(t, err)
+	rtest.Assert(t, !be.Online(), "backend should be offline")
+
+	rtest.Equals(t, "config", load(t, be, config))
+	rtest.Equals(t, []string{oldIndex.Name}, list(t, be, restic.IndexFile))
+
+	snapshot := save(t, be, restic.SnapshotFile, "snapshot")
+	index := save(t, be, restic.IndexFile, "index")
+	pack := save(t, be, restic.DataFile, "pack")
+	lock := save(t, be, restic.LockFile, "lock")
+	rtest.OK(t, be.Remove(context.TODO(), lock))
+	save(t, be, restic.LockFile, "stale lock")
+
+	// the new index must be visible to find the blobs in the pack
+	want := []string{index.Name, oldIndex.Name}
+	sort.Strings(want)
+	rtest.Equals(t, want, list(t, be, restic.IndexFile))
+	rtest.Equals(t, "pack", load(t, be, pack))
+
+	n, err := be.Pending()
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, n)
+
+	_, err = be.Flush(context.TODO(), nil)
+	rtest.Assert(t, err != nil, "Flush should fail while the backend is unreachable")
+	rtest.OK(t, be.Close())
+
+	// upload the pending files when the backend is reachable again
+	remote.offline = false
+	be, err = staging.New(context.TODO(), remote, nil, dir, "test")
+	rtest.OK(t, err)
+	rtest.Equals(t, want, list(t, be, restic.IndexFile))
+
+	n, err = be.Flush(context.TODO(), nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, n)
+	rtest.Equals(t, []restic.Handle{pack, index, snapshot}, remote.saved)
+
+	n, err = be.Pending()
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, n)
+
+	rtest.Equals(t, "snapshot", load(t, remote, snapshot))
+	rtest.Equals(t, 0, len(list(t, remote, restic.LockFile)))
+	rtest.Equals(t, 0, len(list(t, be, restic.LockFile)))
+}
+
+// hangingBackend does not answer Stat requests until the context is
+// cancelled, like a server which is unreachable.
+type hangingBackend struct {
+	restic.Backend
+}
+
+func (be hangingBackend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	<-ctx.Done()
+	return restic.FileInfo{}, ctx.Err()
+}
+
+func TestStagingProbeTimeout(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	staging.TestSetProbeTimeout(t, 50*time.Millisecond)
+	defer staging.TestSetProbeTimeout(t, 15*time.Second)
+
+	// the backend is only probed once, without retries
+	remote := &switchableBackend{Backend: mem.New(), offline: true}
+	start := time.Now()
+	be, err := staging.New(context.TODO(), remote, hangingBackend{remote}, dir, "test")
+	rtest.OK(t, err)
+	rtest.Assert(t, !be.Online(), "backend should be offline")
+	rtest.Assert(t, time.Since(start) < 5*time.Second, "probing the backend took %v", time.Since(start))
+	rtest.Equals(t, 0, remote.failed)
+}
+
+func TestStagingPermanentError(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	remote := &switchableBackend{Backend: mem.New()}
+	be, err := staging.New(context.TODO(), remote, nil, dir, "test")
+	rtest.OK(t, err)
+	config := save(t, be, restic.ConfigFile, "config")
+
+	// a permanent error, e.g. a denied access, is returned to the caller
+	// instead of saving the file in the staging directory
+	remote.offline = true
+	remote.err = backend.Permanent(errors.New("access denied"))
+
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash([]byte("pack")).String()}
+	err = be.Save(context.TODO(), h, restic.NewByteReader([]byte("pack")))
+	rtest.Assert(t, err != nil, "expected an error for a permanent failure")
+	rtest.Assert(t, be.Online(), "backend should not be offline after a permanent error")
+
+	_, err = be.Stat(context.TODO(), config)
+	rtest.Assert(t, err != nil, "expected an error for a permanent failure")
+
+	n, err := be.Pending()
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, n)
+	rtest.OK(t, be.Close())
+
+	be, err = staging.New(context.TODO(), remote, nil, dir, "test")
+	rtest.OK(t, err)
+	rtest.Assert(t, be.Online(), "backend should not be offline after a permanent error")
+}
+
+func TestStagingLocation(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	_, err := staging.New(context.TODO(), mem.New(), nil, dir, "/srv/restic")
+	rtest.OK(t, err)
+
+	_, err = staging.New(context.TODO(), mem.New(), nil, dir, "/srv/other")
+	rtest.Assert(t, err != nil, "expected an error for a different repository")
+
+	be, err := staging.New(context.TODO(), staging.Unreachable("/srv/restic", errNetwork), nil, dir, "/srv/restic")
+	rtest.OK(t, err)
+	rtest.Assert(t, !be.Online(), "unreachable backend should be offline")
+}
@@ -0,0 +1,61 @@
+package staging
+
+import (
+	"context"
+	"io"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// unreachable is a backend which cannot be accessed.
+type unreachable struct {
+	location string
+	err      error
+}
+
+// Unreachable returns a backend for location which returns err for all
+// operations. It is wrapped with New when the real backend cannot be opened,
+// e.g. because the server cannot be reached.
+func Unreachable(location string, err error) restic.Backend {
+	return unreachable{location: location, err: err}
+}
+
+func (be unreachable) Location() string {
+	return be.location
+}
+
+func (be unreachable) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	return false, be.err
+}
+
+func (be unreachable) Remove(ctx context.Context, h restic.Handle) error {
+	return be.err
+}
+
+func (be unreachable) Close() error {
+	return nil
+}
+
+func (be unreachable) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	return be.err
+}
+
+func (be unreachable) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return be.err
+}
+
+func (be unreachable) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	return restic.FileInfo{}, be.err
+}
+
+func (be unreachable) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	return be.err
+}
+
+func (be unreachable) IsNotExist(err error) bool {
+	return false
+}
+
+func (be unreachable) Delete(ctx context.Context) error {
+	return be.err
+}
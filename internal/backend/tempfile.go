@@ -0,0 +1,39 @@
+package backend
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// tempFileMarker is contained in the names of temporary files. All other
+// files in a repository are named after their ID (or "config"), so the marker
+// never appears in their names.
+const tempFileMarker = "-tmp-"
+
+// StaleTempFileAge is the time since the last modification after which a
+// temporary file is assumed to be left over from an interrupted upload.
+const StaleTempFileAge = time.Hour
+
+// StaleTempFileRemover is implemented by backends which write files via
+// temporary files, which are left over when an upload is interrupted.
+type StaleTempFileRemover interface {
+	// RemoveStaleTempFiles removes the temporary files which have not been
+	// modified for StaleTempFileAge and returns the number of removed files.
+	RemoveStaleTempFiles(ctx context.Context) (int, error)
+}
+
+// TempFilename returns a unique name for a temporary file which is renamed to
+// filename after all data has been written, so that the file is never visible
+// under its final name while it is incomplete.
+func TempFilename(filename string) string {
+	return filename + tempFileMarker + restic.NewRandomID().String()[:16]
+}
+
+// IsTempFilename returns true if the base name of a file was created by
+// TempFilename.
+func IsTempFilename(name string) bool {
+	return strings.Contains(name, tempFileMarker)
+}
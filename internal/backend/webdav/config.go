@@ -0,0 +1,47 @@
+package webdav
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Config contains all configuration necessary to connect to a WebDAV server.
+type Config struct {
+	URL         *url.URL
+	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+}
+
+func init() {
+	options.Register("webdav", Config{})
+}
+
+// NewConfig returns a new Config with the default values filled in.
+func NewConfig() Config {
+	return Config{
+		Connections: 5,
+	}
+}
+
+// ParseConfig parses the string s and extracts the WebDAV server URL.
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "webdav:") {
+		return nil, errors.New("invalid WebDAV backend specification")
+	}
+
+	s = s[7:]
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "url.Parse")
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("unsupported scheme %q for WebDAV, use http or https", u.Scheme)
+	}
+
+	cfg := NewConfig()
+	cfg.URL = u
+	return cfg, nil
+}
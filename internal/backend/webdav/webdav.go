@@ -0,0 +1,549 @@
+// Package webdav implements a backend which stores the repository on a
+// WebDAV server.
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/context/ctxhttp"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// make sure the WebDAV backend implements restic.Backend
+var _ restic.Backend = &Backend{}
+
+// Backend stores the repository on a WebDAV server.
+type Backend struct {
+	url    *url.URL
+	sem    *backend.Semaphore
+	client *http.Client
+	backend.Layout
+}
+
+// Open opens the WebDAV backend with the given config.
+func Open(cfg Config, rt http.RoundTripper) (*Backend, error) {
+	sem, err := backend.NewSemaphore(cfg.Connections)
+	if err != nil {
+		return nil, err
+	}
+
+	// the layout works on the path component of the URL only
+	dir := strings.TrimRight(cfg.URL.Path, "/")
+	if dir == "" {
+		dir = "/"
+	}
+
+	be := &Backend{
+		url:    cfg.URL,
+		client: &http.Client{Transport: rt},
+		Layout: &backend.DefaultLayout{Path: dir, Join: path.Join},
+		sem:    sem,
+	}
+
+	return be, nil
+}
+
+// Create creates the repository directories on the WebDAV server.
+func Create(cfg Config, rt http.RoundTripper) (*Backend, error) {
+	be, err := Open(cfg, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = be.Stat(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+	if err == nil {
+		return nil, errors.Fatal("config file already exists")
+	}
+
+	base, _ := be.Basedir(restic.ConfigFile)
+	if err = be.mkcol(context.TODO(), base); err != nil {
+		return nil, errors.Errorf("unable to create directory %v: %v", base, err)
+	}
+
+	// directories must be created before their subdirs
+	dirs := be.Paths()
+	for _, subdirs := range []bool{false, true} {
+		for _, dir := range dirs {
+			if (path.Dir(dir) != base) == subdirs {
+				if err = be.mkdirAll(context.TODO(), dir); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return be, nil
+}
+
+// requestURL returns the URL for the path p on the server.
+func (b *Backend) requestURL(p string) string {
+	u := *b.url
+	u.Path = p
+	return u.String()
+}
+
+// do sends the request with the given method for the path p to the server.
+func (b *Backend) do(ctx context.Context, method, p string, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, b.requestURL(p), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewRequest")
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	b.sem.GetToken()
+	resp, err := ctxhttp.Do(ctx, b.client, req)
+	b.sem.ReleaseToken()
+
+	if err != nil {
+		return nil, errors.Wrap(err, method)
+	}
+
+	return resp, nil
+}
+
+// discard reads the remaining body of resp and closes it.
+func discard(resp *http.Response) error {
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// mkcol creates the directory dir. It is not an error if dir already exists.
+func (b *Backend) mkcol(ctx context.Context, dir string) error {
+	debug.Log("MKCOL %v", dir)
+	resp, err := b.do(ctx, "MKCOL", dir+"/", nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = discard(resp); err != nil {
+		return errors.Wrap(err, "Close")
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return nil
+	case http.StatusMethodNotAllowed:
+		// the directory already exists
+		return nil
+	case http.StatusConflict:
+		return errParentMissing
+	}
+
+	return errors.Errorf("unable to create directory %v, server response: %v", dir, resp.Status)
+}
+
+// errParentMissing is returned by mkcol when the parent directory does not
+// exist.
+var errParentMissing = errors.New("parent directory does not exist")
+
+// mkdirAll creates the directory dir below the repository directory, along
+// with all missing parents.
+func (b *Backend) mkdirAll(ctx context.Context, dir string) error {
+	err := b.mkcol(ctx, dir)
+	if err != errParentMissing {
+		return err
+	}
+
+	base, _ := b.Basedir(restic.ConfigFile)
+	parent := path.Dir(dir)
+	if parent == dir || !strings.HasPrefix(parent, base) {
+		return errors.Errorf("unable to create directory %v: %v", dir, err)
+	}
+
+	if err = b.mkdirAll(ctx, parent); err != nil {
+		return err
+	}
+
+	return b.mkcol(ctx, dir)
+}
+
+// Location returns this backend's location (the server's URL).
+func (b *Backend) Location() string {
+	return b.url.String()
+}
+
+// Save stores data in the backend at the handle.
+func (b *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if err := h.Valid(); err != nil {
+		return err
+	}
+
+	status, err := b.put(ctx, h, rd)
+	if err != nil {
+		return err
+	}
+
+	if status == http.StatusConflict || status == http.StatusNotFound {
+		// the directory does not exist yet (e.g. for parity files), create
+		// it and try again
+		debug.Log("Save(%v): directory is missing, status %v", h, status)
+		if err = b.mkdirAll(ctx, path.Dir(b.Filename(h))); err != nil {
+			return err
+		}
+
+		if err = rd.Rewind(); err != nil {
+			return err
+		}
+
+		status, err = b.put(ctx, h, rd)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch status {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	}
+
+	return backend.ClassifyHTTPError(status, errors.Errorf("server response unexpected: %v (%v)", http.StatusText(status), status))
+}
+
+// put uploads the data in rd and returns the HTTP status code.
+func (b *Backend) put(ctx context.Context, h restic.Handle, rd restic.RewindReader) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// make sure that the client cannot close the reader by wrapping it
+	req, err := http.NewRequest(http.MethodPut, b.requestURL(b.Filename(h)), ioutil.NopCloser(rd))
+	if err != nil {
+		return 0, errors.Wrap(err, "NewRequest")
+	}
+	req.ContentLength = rd.Length()
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	b.sem.GetToken()
+	resp, err := ctxhttp.Do(ctx, b.client, req)
+	b.sem.ReleaseToken()
+
+	if err != nil {
+		return 0, errors.Wrap(err, "PUT")
+	}
+
+	if err = discard(resp); err != nil {
+		return 0, errors.Wrap(err, "Close")
+	}
+
+	return resp.StatusCode, nil
+}
+
+// ErrIsNotExist is returned whenever the requested file does not exist on the
+// server.
+type ErrIsNotExist struct {
+	restic.Handle
+}
+
+func (e ErrIsNotExist) Error() string {
+	return fmt.Sprintf("%v does not exist", e.Handle)
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file.
+func (b *Backend) IsNotExist(err error) bool {
+	err = errors.Cause(err)
+	_, ok := err.(ErrIsNotExist)
+	return ok
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset.
+func (b *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return backend.DefaultLoad(ctx, h, length, offset, b.openReader, fn)
+}
+
+// limitedReadCloser limits the data read from an io.ReadCloser.
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (b *Backend) openReader(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+	debug.Log("Load %v, length %v, offset %v", h, length, offset)
+	if err := h.Valid(); err != nil {
+		return nil, err
+	}
+
+	if offset < 0 {
+		return nil, errors.New("offset is negative")
+	}
+
+	if length < 0 {
+		return nil, errors.Errorf("invalid length %d", length)
+	}
+
+	header := make(http.Header)
+	if offset > 0 || length > 0 {
+		byteRange := fmt.Sprintf("bytes=%d-", offset)
+		if length > 0 {
+			byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+int64(length)-1)
+		}
+		header.Set("Range", byteRange)
+		debug.Log("Load(%v) send range %v", h, byteRange)
+	}
+
+	resp, err := b.do(ctx, http.MethodGet, b.Filename(h), header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		// the server does not support ranges, skip the data before offset
+		if offset > 0 {
+			if _, err = io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+				_ = resp.Body.Close()
+				return nil, errors.Wrap(err, "Copy")
+			}
+		}
+
+		if length > 0 {
+			return limitedReadCloser{io.LimitReader(resp.Body, int64(length)), resp.Body}, nil
+		}
+		return resp.Body, nil
+	case http.StatusNotFound:
+		_ = discard(resp)
+		return nil, ErrIsNotExist{h}
+	}
+
+	_ = discard(resp)
+	return nil, backend.ClassifyHTTPError(resp.StatusCode, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status))
+}
+
+// Stat returns information about a blob.
+func (b *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	if err := h.Valid(); err != nil {
+		return restic.FileInfo{}, err
+	}
+
+	resp, err := b.do(ctx, http.MethodHead, b.Filename(h), nil, nil)
+	if err != nil {
+		return restic.FileInfo{}, err
+	}
+
+	if err = discard(resp); err != nil {
+		return restic.FileInfo{}, errors.Wrap(err, "Close")
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return restic.FileInfo{}, ErrIsNotExist{h}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return restic.FileInfo{}, backend.ClassifyHTTPError(resp.StatusCode, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status))
+	}
+
+	if resp.ContentLength < 0 {
+		return restic.FileInfo{}, errors.New("negative content length")
+	}
+
+	return restic.FileInfo{Size: resp.ContentLength, Name: h.Name}, nil
+}
+
+// Test returns true if a blob of the given type and name exists in the backend.
+func (b *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	_, err := b.Stat(ctx, h)
+	if b.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Remove removes the blob with the given name and type.
+func (b *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	if err := h.Valid(); err != nil {
+		return err
+	}
+
+	return b.remove(ctx, h, b.Filename(h))
+}
+
+// remove deletes the file or directory at p.
+func (b *Backend) remove(ctx context.Context, h restic.Handle, p string) error {
+	resp, err := b.do(ctx, http.MethodDelete, p, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = discard(resp); err != nil {
+		return errors.Wrap(err, "Close")
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return ErrIsNotExist{h}
+	}
+
+	return backend.ClassifyHTTPError(resp.StatusCode, errors.Errorf("blob not removed, server response: %v (%v)", resp.Status, resp.StatusCode))
+}
+
+// multistatus is the response to a PROPFIND request.
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				Length       int64 `xml:"getcontentlength"`
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/></prop></propfind>`
+
+// entry is a file or directory returned by propfind.
+type entry struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+// propfind returns the entries in the directory dir. If dir does not exist,
+// no entries are returned.
+func (b *Backend) propfind(ctx context.Context, dir string) ([]entry, error) {
+	header := make(http.Header)
+	header.Set("Depth", "1")
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := b.do(ctx, "PROPFIND", dir+"/", header, strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = discard(resp)
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, backend.ClassifyHTTPError(resp.StatusCode, errors.Errorf("unable to list %v, server response: %v", dir, resp.Status))
+	}
+
+	var ms multistatus
+	if err = xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, errors.Wrap(err, "Decode")
+	}
+
+	var entries []entry
+	for _, r := range ms.Responses {
+		u, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, errors.Wrap(err, "url.Parse")
+		}
+
+		p := strings.TrimRight(u.Path, "/")
+		if p == strings.TrimRight(dir, "/") {
+			// this is the directory itself
+			continue
+		}
+
+		e := entry{name: path.Base(p)}
+		for _, ps := range r.Propstat {
+			if ps.Prop.ResourceType.Collection != nil {
+				e.isDir = true
+			}
+			if ps.Prop.Length > e.size {
+				e.size = ps.Prop.Length
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// List runs fn for each file in the backend which has the type t. When an
+// error occurs (or fn returns an error), List stops and returns it.
+func (b *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	basedir, subdirs := b.Basedir(t)
+
+	dirs := []string{basedir}
+	if subdirs {
+		entries, err := b.propfind(ctx, basedir)
+		if err != nil {
+			return err
+		}
+
+		dirs = dirs[:0]
+		for _, e := range entries {
+			if e.isDir {
+				dirs = append(dirs, path.Join(basedir, e.name))
+			}
+		}
+	}
+
+	for _, dir := range dirs {
+		entries, err := b.propfind(ctx, dir)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if e.isDir {
+				continue
+			}
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			err := fn(restic.FileInfo{Name: e.name, Size: e.size})
+			if err != nil {
+				return err
+			}
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+	}
+
+	return ctx.Err()
+}
+
+// Close closes all open files.
+func (b *Backend) Close() error {
+	// this does not need to do anything, all open files are closed within the
+	// same function.
+	return nil
+}
+
+// Delete removes the repository directory with all its contents.
+func (b *Backend) Delete(ctx context.Context) error {
+	base, _ := b.Basedir(restic.ConfigFile)
+	err := b.remove(ctx, restic.Handle{Type: restic.ConfigFile}, base+"/")
+	if err != nil && b.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
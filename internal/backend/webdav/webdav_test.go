@@ -0,0 +1,109 @@
+package webdav_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/backend/webdav"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	xwebdav "golang.org/x/net/webdav"
+)
+
+// runWebDAVServer starts an in-process WebDAV server which stores the files
+// in memory.
+func runWebDAVServer(t testing.TB) (*url.URL, func()) {
+	srv := httptest.NewServer(&xwebdav.Handler{
+		FileSystem: xwebdav.NewMemFS(),
+		LockSystem: xwebdav.NewMemLS(),
+	})
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u, srv.Close
+}
+
+func newTestSuite(t testing.TB, serverURL *url.URL) *test.Suite {
+	tr, err := backend.Transport(backend.TransportOptions{})
+	if err != nil {
+		t.Fatalf("cannot create transport for tests: %v", err)
+	}
+
+	var n int32
+
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			u := *serverURL
+			u.Path = fmt.Sprintf("/restic-test-%d", atomic.AddInt32(&n, 1))
+
+			cfg := webdav.NewConfig()
+			cfg.URL = &u
+			return cfg, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(webdav.Config)
+			return webdav.Create(cfg, tr)
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(webdav.Config)
+			return webdav.Open(cfg, tr)
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(config interface{}) error {
+			return nil
+		},
+	}
+}
+
+func TestBackendWebDAV(t *testing.T) {
+	serverURL, cleanup := runWebDAVServer(t)
+	defer cleanup()
+
+	newTestSuite(t, serverURL).RunTests(t)
+}
+
+func TestWebDAVParityDirs(t *testing.T) {
+	serverURL, cleanup := runWebDAVServer(t)
+	defer cleanup()
+
+	cfg := webdav.NewConfig()
+	cfg.URL = serverURL
+	cfg.URL.Path = "/repo"
+
+	tr, err := backend.Transport(backend.TransportOptions{})
+	rtest.OK(t, err)
+
+	be, err := webdav.Create(cfg, tr)
+	rtest.OK(t, err)
+
+	// the parity directories are not created by Create
+	data := []byte("parity data")
+	h := restic.Handle{Type: restic.ParityFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+
+	var names []string
+	rtest.OK(t, be.List(context.TODO(), restic.ParityFile, func(fi restic.FileInfo) error {
+		names = append(names, fi.Name)
+		rtest.Equals(t, int64(len(data)), fi.Size)
+		return nil
+	}))
+	rtest.Equals(t, []string{h.Name}, names)
+
+	rtest.OK(t, be.Delete(context.TODO()))
+	rtest.OK(t, be.Close())
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/restic/restic/internal/restic"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/restic/restic/internal/compress"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/pack"
 	"github.com/restic/restic/internal/repository"
@@ -685,6 +686,15 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
 			continue
 		}
 
+		if blob.IsCompressed() {
+			plaintext, err = compress.Decompress(nil, plaintext, int(blob.UncompressedLength))
+			if err != nil {
+				debug.Log("  error decompressing blob %v: %v", blob.ID, err)
+				errs = append(errs, errors.Errorf("blob %v: %v", i, err))
+				continue
+			}
+		}
+
 		hash := restic.Hash(plaintext)
 		if !hash.Equal(blob.ID) {
 			debug.Log("  Blob ID does not match, want %v, got %v", blob.ID, hash)
@@ -694,7 +704,7 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
 	}
 
 	if len(errs) > 0 {
-		return errors.Errorf("pack %v contains %v errors: %v", id.Str(), len(errs), errs)
+		return errors.Errorf("contains %v errors: %v", len(errs), errs)
 	}
 
 	return nil
@@ -740,7 +750,7 @@ func (c *Checker) ReadPacks(ctx context.Context, packs restic.IDSet, p *restic.P
 				select {
 				case <-ctx.Done():
 					return nil
-				case errChan <- err:
+				case errChan <- PackError{ID: id, Err: err}:
 				}
 			}
 		})
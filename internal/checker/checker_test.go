@@ -12,7 +12,9 @@ import (
 	"time"
 
 	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/checker"
+	"github.com/restic/restic/internal/parity"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/test"
@@ -43,6 +45,10 @@ func checkStruct(chkr *checker.Checker) []error {
 	return collectErrors(context.TODO(), chkr.Structure)
 }
 
+func checkParity(chkr *checker.Checker) []error {
+	return collectErrors(context.TODO(), chkr.Parity)
+}
+
 func checkData(chkr *checker.Checker) []error {
 	return collectErrors(
 		context.TODO(),
@@ -390,3 +396,75 @@ func BenchmarkChecker(t *testing.B) {
 		test.OKs(t, checkData(chkr))
 	}
 }
+
+func TestRepairPack(t *testing.T) {
+	be, cleanup := repository.TestBackend(t)
+	defer cleanup()
+
+	pbe, err := parity.NewBackend(be, 2)
+	test.OK(t, err)
+
+	repo, cleanup := repository.TestRepositoryWithBackend(t, pbe)
+	defer cleanup()
+
+	arch := archiver.New(repo)
+	_, _, err = arch.Snapshot(context.TODO(), nil, []string{"."}, nil, "localhost", nil, time.Now())
+	test.OK(t, err)
+
+	var packs restic.IDs
+	err = repo.List(context.TODO(), restic.DataFile, func(id restic.ID, size int64) error {
+		packs = append(packs, id)
+		return nil
+	})
+	test.OK(t, err)
+	test.Assert(t, len(packs) > 0, "no packs found")
+
+	// damage all packs directly in the backend, so that the parity files
+	// are kept
+	for _, id := range packs {
+		h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+		buf, err := backend.LoadAll(context.TODO(), be, h)
+		test.OK(t, err)
+
+		buf[len(buf)/2] ^= 0xff
+		test.OK(t, be.Remove(context.TODO(), h))
+		test.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(buf)))
+	}
+
+	chkr := checker.New(repo)
+	_, errs := chkr.LoadIndex(context.TODO())
+	test.OKs(t, errs)
+	test.OKs(t, checkParity(chkr))
+
+	errs = checkData(chkr)
+	test.Equals(t, len(packs), len(errs))
+	for _, err := range errs {
+		_, ok := err.(checker.PackError)
+		test.Assert(t, ok, "expected PackError for a damaged pack, got %v", err)
+	}
+
+	// a pack without parity file cannot be repaired
+	test.OK(t, be.Remove(context.TODO(), parity.Handle(packs[0])))
+
+	errs = checkParity(chkr)
+	test.Equals(t, 1, len(errs))
+	if err, ok := errs[0].(checker.PackError); ok {
+		test.Equals(t, packs[0], err.ID)
+	} else {
+		t.Errorf("expected PackError for a pack without parity data, got %v", errs[0])
+	}
+
+	err = checker.RepairPack(context.TODO(), repo.Backend(), packs[0])
+	test.Assert(t, err == checker.ErrNoParity, "expected ErrNoParity, got %v", err)
+
+	for _, id := range packs[1:] {
+		test.OK(t, checker.RepairPack(context.TODO(), repo.Backend(), id))
+
+		found, err := be.Test(context.TODO(), parity.Handle(id))
+		test.OK(t, err)
+		test.Assert(t, found, "parity file for pack %v is missing after the repair", id.Str())
+	}
+
+	errs = checkData(chkr)
+	test.Assert(t, len(errs) == 1, "expected one damaged pack, got %v", errs)
+}
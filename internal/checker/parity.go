@@ -0,0 +1,101 @@
+package checker
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/parity"
+	"github.com/restic/restic/internal/restic"
+)
+
+// ErrNoParity is returned by RepairPack if there is no parity file for a
+// pack.
+var ErrNoParity = errors.New("no parity data found")
+
+// RepairPack reconstructs the damaged pack id from its parity file and
+// replaces the pack in the backend with the repaired version. ErrNoParity is
+// returned if the pack has no parity file.
+func RepairPack(ctx context.Context, be restic.Backend, id restic.ID) error {
+	debug.Log("repairing pack %v", id)
+
+	// test for the parity file first, loading a missing file is retried
+	ph := parity.Handle(id)
+	found, err := be.Test(ctx, ph)
+	if err != nil {
+		return errors.Wrap(err, "Test")
+	}
+	if !found {
+		return ErrNoParity
+	}
+
+	parityFile, err := backend.LoadAll(ctx, be, ph)
+	if err != nil {
+		return errors.Wrap(err, "LoadAll")
+	}
+
+	h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+	pack, err := backend.LoadAll(ctx, be, h)
+	if err != nil && !be.IsNotExist(err) {
+		return errors.Wrap(err, "LoadAll")
+	}
+	packExists := err == nil
+
+	repaired, err := parity.Repair(id, pack, parityFile)
+	if err != nil {
+		return err
+	}
+
+	if packExists && len(pack) == len(repaired) && restic.Hash(pack).Equal(id) {
+		debug.Log("pack %v is not damaged", id)
+		return nil
+	}
+
+	// the damaged pack must be removed before the repaired version can be
+	// saved, removing the pack may also remove the parity file
+	if packExists {
+		if err = be.Remove(ctx, h); err != nil {
+			return err
+		}
+	}
+
+	if err = be.Save(ctx, h, restic.NewByteReader(repaired)); err != nil {
+		return err
+	}
+
+	// restore the parity file if it was not written again with the pack
+	if _, err = be.Stat(ctx, ph); be.IsNotExist(err) {
+		err = be.Save(ctx, ph, restic.NewByteReader(parityFile))
+	}
+
+	return err
+}
+
+// Parity checks that a parity file exists for all packs referenced in the
+// index. A PackError is sent to errChan for each pack without parity data,
+// errChan is closed afterwards.
+func (c *Checker) Parity(ctx context.Context, errChan chan<- error) {
+	defer close(errChan)
+
+	debug.Log("listing parity files")
+	parityFiles := restic.NewIDSet()
+
+	err := c.repo.List(ctx, restic.ParityFile, func(id restic.ID, size int64) error {
+		parityFiles.Insert(id)
+		return nil
+	})
+
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	for id := range c.packs.Sub(parityFiles) {
+		select {
+		case <-ctx.Done():
+			return
+		case errChan <- PackError{ID: id, Err: errors.New("no parity data")}:
+		}
+	}
+}
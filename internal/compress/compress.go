@@ -0,0 +1,61 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// Level is the compression level used for blobs. Data is mostly compressed
+// once and read rarely, but backups should not get too slow, so the default
+// level is used.
+const Level = flate.DefaultCompression
+
+var writerPool = sync.Pool{
+	New: func() interface{} {
+		wr, err := flate.NewWriter(nil, Level)
+		if err != nil {
+			panic(err)
+		}
+		return wr
+	},
+}
+
+// Compress appends the compressed content of src to dst and returns the
+// resulting slice.
+func Compress(dst, src []byte) []byte {
+	buf := bytes.NewBuffer(dst)
+
+	wr := writerPool.Get().(*flate.Writer)
+	defer writerPool.Put(wr)
+	wr.Reset(buf)
+
+	// writing to a bytes.Buffer never fails
+	_, _ = wr.Write(src)
+	_ = wr.Close()
+
+	return buf.Bytes()
+}
+
+// Decompress appends the decompressed content of src to dst and returns the
+// resulting slice. At most max bytes are decompressed, if the data is
+// larger, an error is returned.
+func Decompress(dst, src []byte, max int) ([]byte, error) {
+	rd := flate.NewReader(bytes.NewReader(src))
+	defer rd.Close()
+
+	buf := bytes.NewBuffer(dst)
+	n, err := io.Copy(buf, io.LimitReader(rd, int64(max)+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "Decompress")
+	}
+
+	if n > int64(max) {
+		return nil, errors.Errorf("decompressed data is larger than %d bytes", max)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,47 @@
+package compress
+
+import (
+	"bytes"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestCompressDecompress(t *testing.T) {
+	tests := [][]byte{
+		nil,
+		[]byte("foobar"),
+		bytes.Repeat([]byte("restic"), 100000),
+		rtest.Random(23, 1<<20),
+	}
+
+	for _, data := range tests {
+		compressed := Compress(nil, data)
+
+		plaintext, err := Decompress(nil, compressed, len(data))
+		rtest.OK(t, err)
+
+		if !bytes.Equal(data, plaintext) {
+			t.Fatalf("wrong data returned, want %d bytes, got %d", len(data), len(plaintext))
+		}
+	}
+}
+
+func TestCompressRatio(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	compressed := Compress(nil, data)
+
+	if len(compressed) >= len(data)/10 {
+		t.Fatalf("data was not compressed well, %d bytes -> %d bytes", len(data), len(compressed))
+	}
+}
+
+func TestDecompressMax(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 1000)
+	compressed := Compress(nil, data)
+
+	_, err := Decompress(nil, compressed, len(data)-1)
+	if err == nil {
+		t.Fatal("expected error for too large data not found")
+	}
+}
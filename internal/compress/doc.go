@@ -0,0 +1,3 @@
+// Package compress implements the compression of blobs stored in a
+// repository.
+package compress
@@ -36,6 +36,10 @@ var (
 type Key struct {
 	MACKey        `json:"mac"`
 	EncryptionKey `json:"encrypt"`
+
+	// decryptionKeys are tried by Open when the data was not encrypted with
+	// this key, see AddDecryptionKeys
+	decryptionKeys []*Key
 }
 
 // EncryptionKey is key used for encryption
@@ -350,9 +354,19 @@ func (k *Key) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error
 
 	// verify mac
 	if !poly1305Verify(ct, nonce, &k.MACKey, mac) {
+		for _, dk := range k.decryptionKeys {
+			if dk.Valid() && poly1305Verify(ct, nonce, &dk.MACKey, mac) {
+				return dk.decrypt(dst, nonce, ct), nil
+			}
+		}
 		return nil, ErrUnauthenticated
 	}
 
+	return k.decrypt(dst, nonce, ct), nil
+}
+
+// decrypt appends the plaintext for the authenticated ciphertext ct to dst.
+func (k *Key) decrypt(dst, nonce, ct []byte) []byte {
 	ret, out := sliceForAppend(dst, len(ct))
 
 	c, err := aes.NewCipher(k.EncryptionKey[:])
@@ -362,7 +376,13 @@ func (k *Key) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error
 	e := cipher.NewCTR(c, nonce)
 	e.XORKeyStream(out, ct)
 
-	return ret, nil
+	return ret
+}
+
+// AddDecryptionKeys adds keys which Open uses to decrypt data that was not
+// encrypted with k. Seal always uses k.
+func (k *Key) AddDecryptionKeys(keys ...*Key) {
+	k.decryptionKeys = append(k.decryptionKeys, keys...)
 }
 
 // Valid tests if the key is valid.
@@ -2,28 +2,76 @@ package crypto
 
 import (
 	"crypto/rand"
+	"fmt"
+	"runtime"
 	"time"
 
 	"github.com/restic/restic/internal/errors"
 
 	sscrypt "github.com/elithrar/simple-scrypt"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/scrypt"
 )
 
 const saltLength = 64
 
+// Names of the supported key derivation functions.
+const (
+	KDFScrypt   = "scrypt"
+	KDFArgon2id = "argon2id"
+)
+
+// argon2idMaxMemory is the maximum memory in KiB accepted for Argon2id, it
+// protects against key files which would exhaust the available memory.
+const argon2idMaxMemory = 4 * 1024 * 1024
+
 // Params are the default parameters used for the key derivation function KDF().
 type Params struct {
+	// KDF is the name of the key derivation function, an empty string
+	// selects scrypt.
+	KDF string
+
+	// parameters for scrypt
 	N int
 	R int
 	P int
+
+	// parameters for Argon2id, Memory is specified in KiB
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// Name returns the name of the key derivation function.
+func (p Params) Name() string {
+	if p.KDF == "" {
+		return KDFScrypt
+	}
+	return p.KDF
+}
+
+func (p Params) String() string {
+	if p.Name() == KDFArgon2id {
+		return fmt.Sprintf("%v(t=%d, m=%d, p=%d)", KDFArgon2id, p.Time, p.Memory, p.Threads)
+	}
+	return fmt.Sprintf("%v(N=%d, r=%d, p=%d)", KDFScrypt, p.N, p.R, p.P)
 }
 
 // DefaultKDFParams are the default parameters used for Calibrate and KDF().
 var DefaultKDFParams = Params{
-	N: sscrypt.DefaultParams.N,
-	R: sscrypt.DefaultParams.R,
-	P: sscrypt.DefaultParams.P,
+	KDF: KDFScrypt,
+	N:   sscrypt.DefaultParams.N,
+	R:   sscrypt.DefaultParams.R,
+	P:   sscrypt.DefaultParams.P,
+}
+
+// DefaultArgon2idParams are the default parameters used for
+// CalibrateArgon2id.
+var DefaultArgon2idParams = Params{
+	KDF:     KDFArgon2id,
+	Time:    1,
+	Memory:  64 * 1024,
+	Threads: 4,
 }
 
 // Calibrate determines new KDF parameters for the current hardware.
@@ -42,17 +90,95 @@ func Calibrate(timeout time.Duration, memory int) (Params, error) {
 	}
 
 	return Params{
-		N: params.N,
-		R: params.R,
-		P: params.P,
+		KDF: KDFScrypt,
+		N:   params.N,
+		R:   params.R,
+		P:   params.P,
 	}, nil
 }
 
+// CalibrateArgon2id determines Argon2id parameters for the current hardware.
+// The memory in MiB is used as given, the number of passes is chosen so that
+// the key derivation takes about timeout.
+func CalibrateArgon2id(timeout time.Duration, memory int) (Params, error) {
+	params := DefaultArgon2idParams
+	if memory > 0 {
+		params.Memory = uint32(memory) * 1024
+	}
+
+	threads := runtime.NumCPU()
+	if threads > 255 {
+		threads = 255
+	}
+	params.Threads = uint8(threads)
+
+	if err := params.Check(); err != nil {
+		return DefaultArgon2idParams, err
+	}
+
+	salt, err := NewSalt()
+	if err != nil {
+		return DefaultArgon2idParams, err
+	}
+
+	// measure a single pass over the memory
+	start := time.Now()
+	argon2.IDKey([]byte("password"), salt, 1, params.Memory, params.Threads, macKeySize+aesKeySize)
+	pass := time.Since(start)
+
+	params.Time = 1
+	if pass > 0 && timeout > pass {
+		params.Time = uint32(timeout / pass)
+	}
+
+	return params, nil
+}
+
+// Check returns an error if p does not contain valid parameters for the KDF.
+func (p Params) Check() error {
+	switch p.Name() {
+	case KDFScrypt:
+		params := sscrypt.Params{
+			N:       p.N,
+			R:       p.R,
+			P:       p.P,
+			DKLen:   sscrypt.DefaultParams.DKLen,
+			SaltLen: saltLength,
+		}
+		return errors.Wrap(params.Check(), "Check")
+	case KDFArgon2id:
+		if p.Time < 1 {
+			return errors.New("argon2id: time must be at least 1")
+		}
+		if p.Threads < 1 {
+			return errors.New("argon2id: threads must be at least 1")
+		}
+		if p.Memory < 8*uint32(p.Threads) {
+			return errors.Errorf("argon2id: memory must be at least %d KiB for %d threads", 8*uint32(p.Threads), p.Threads)
+		}
+		if p.Memory > argon2idMaxMemory {
+			return errors.Errorf("argon2id: memory must not exceed %d KiB", argon2idMaxMemory)
+		}
+		return nil
+	}
+
+	return errors.Errorf("unsupported KDF %q", p.KDF)
+}
+
 // KDF derives encryption and message authentication keys from the password
-// using the supplied parameters N, R and P and the Salt.
+// using the supplied parameters and the Salt. Depending on p.KDF, either
+// scrypt with N, R and P or Argon2id with Time, Memory and Threads is used.
 func KDF(p Params, salt []byte, password string) (*Key, error) {
 	if len(salt) != saltLength {
-		return nil, errors.Errorf("scrypt() called with invalid salt bytes (len %d)", len(salt))
+		return nil, errors.Errorf("%v() called with invalid salt bytes (len %d)", p.Name(), len(salt))
+	}
+
+	switch p.Name() {
+	case KDFScrypt:
+	case KDFArgon2id:
+		return argon2idKDF(p, salt, password)
+	default:
+		return nil, errors.Errorf("unsupported KDF %q", p.KDF)
 	}
 
 	// make sure we have valid parameters
@@ -89,6 +215,22 @@ func KDF(p Params, salt []byte, password string) (*Key, error) {
 	return derKeys, nil
 }
 
+// argon2idKDF derives the keys from the password with Argon2id.
+func argon2idKDF(p Params, salt []byte, password string) (*Key, error) {
+	if err := p.Check(); err != nil {
+		return nil, err
+	}
+
+	keybytes := macKeySize + aesKeySize
+	keys := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(keybytes))
+
+	derKeys := &Key{}
+	copy(derKeys.EncryptionKey[:], keys[:aesKeySize])
+	macKeyFromSlice(&derKeys.MACKey, keys[aesKeySize:])
+
+	return derKeys, nil
+}
+
 // NewSalt returns new random salt bytes to use with KDF(). If NewSalt returns
 // an error, this is a grave situation and the program must abort and terminate.
 func NewSalt() ([]byte, error) {
@@ -12,3 +12,79 @@ func TestCalibrate(t *testing.T) {
 	}
 	t.Logf("testing calibrate, params after: %v", params)
 }
+
+func TestKDFArgon2id(t *testing.T) {
+	params := Params{KDF: KDFArgon2id, Time: 1, Memory: 64, Threads: 1}
+
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k1, err := KDF(params, salt, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k2, err := KDF(params, salt, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k1.EncryptionKey != k2.EncryptionKey || k1.MACKey != k2.MACKey {
+		t.Fatalf("KDF returned different keys for the same input")
+	}
+
+	params.Time = 2
+	k3, err := KDF(params, salt, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k1.EncryptionKey == k3.EncryptionKey {
+		t.Fatalf("KDF returned the same key for different parameters")
+	}
+
+	// the key must differ from the one derived with scrypt
+	k4, err := KDF(Params{N: 128, R: 1, P: 1}, salt, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k1.EncryptionKey == k4.EncryptionKey {
+		t.Fatalf("argon2id and scrypt returned the same key")
+	}
+}
+
+func TestKDFInvalidParams(t *testing.T) {
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, params := range []Params{
+		{KDF: "bcrypt", N: 128, R: 1, P: 1},
+		{KDF: KDFScrypt, N: 127, R: 1, P: 1},
+		{KDF: KDFArgon2id, Time: 0, Memory: 64, Threads: 1},
+		{KDF: KDFArgon2id, Time: 1, Memory: 64, Threads: 0},
+		{KDF: KDFArgon2id, Time: 1, Memory: 4, Threads: 1},
+		{KDF: KDFArgon2id, Time: 1, Memory: argon2idMaxMemory + 1, Threads: 1},
+	} {
+		_, err := KDF(params, salt, "password")
+		if err == nil {
+			t.Errorf("expected an error for invalid parameters %v", params)
+		}
+	}
+}
+
+func TestCalibrateArgon2id(t *testing.T) {
+	params, err := CalibrateArgon2id(100*time.Millisecond, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if params.KDF != KDFArgon2id || params.Memory != 1024 || params.Time < 1 {
+		t.Fatalf("unexpected parameters %v", params)
+	}
+	t.Logf("testing calibrate, params after: %v", params)
+}
@@ -0,0 +1,104 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/json"
+
+	"github.com/restic/restic/internal/errors"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// sealedNonceSize is the size of the nonce used for sealing keys.
+const sealedNonceSize = 24
+
+// NewKeyPair generates a new Curve25519 key pair, which is used to seal keys
+// for the owner of the private key.
+func NewKeyPair() (publicKey, privateKey []byte, err error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "GenerateKey")
+	}
+
+	return pub[:], priv[:], nil
+}
+
+func toKey32(buf []byte) (*[32]byte, error) {
+	if len(buf) != 32 {
+		return nil, errors.Errorf("invalid key length %d", len(buf))
+	}
+
+	var k [32]byte
+	copy(k[:], buf)
+	return &k, nil
+}
+
+// SealKey encrypts k for publicKey, so that it can only be decrypted with
+// the corresponding private key. A new ephemeral key pair is used for each
+// call, the result consists of the ephemeral public key, the nonce and the
+// encrypted key.
+func SealKey(k *Key, publicKey []byte) ([]byte, error) {
+	pub, err := toKey32(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralPub, ephemeralPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "GenerateKey")
+	}
+
+	var nonce [sealedNonceSize]byte
+	_, err = rand.Read(nonce[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "rand.Read")
+	}
+
+	buf, err := json.Marshal(k)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+
+	sealed := make([]byte, 0, len(ephemeralPub)+len(nonce)+len(buf)+box.Overhead)
+	sealed = append(sealed, ephemeralPub[:]...)
+	sealed = append(sealed, nonce[:]...)
+	return box.Seal(sealed, buf, &nonce, pub, ephemeralPriv), nil
+}
+
+// OpenSealedKey decrypts a key which was sealed with SealKey for the public
+// key belonging to privateKey.
+func OpenSealedKey(sealed, privateKey []byte) (*Key, error) {
+	priv, err := toKey32(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < 32+sealedNonceSize+box.Overhead {
+		return nil, errors.New("sealed key is too short")
+	}
+
+	ephemeralPub, err := toKey32(sealed[:32])
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [sealedNonceSize]byte
+	copy(nonce[:], sealed[32:32+sealedNonceSize])
+
+	buf, ok := box.Open(nil, sealed[32+sealedNonceSize:], &nonce, ephemeralPub, priv)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	k := &Key{}
+	err = json.Unmarshal(buf, k)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+
+	if !k.Valid() {
+		return nil, errors.New("invalid sealed key")
+	}
+
+	return k, nil
+}
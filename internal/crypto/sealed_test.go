@@ -0,0 +1,53 @@
+package crypto_test
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/crypto"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestSealKey(t *testing.T) {
+	pub, priv, err := crypto.NewKeyPair()
+	rtest.OK(t, err)
+
+	k := crypto.NewRandomKey()
+	sealed, err := crypto.SealKey(k, pub)
+	rtest.OK(t, err)
+
+	k2, err := crypto.OpenSealedKey(sealed, priv)
+	rtest.OK(t, err)
+	rtest.Equals(t, k.EncryptionKey, k2.EncryptionKey)
+	rtest.Equals(t, k.MACKey.K, k2.MACKey.K)
+
+	_, otherPriv, err := crypto.NewKeyPair()
+	rtest.OK(t, err)
+	_, err = crypto.OpenSealedKey(sealed, otherPriv)
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "wrong error returned: %v", err)
+
+	sealed[len(sealed)-1] ^= 0xff
+	_, err = crypto.OpenSealedKey(sealed, priv)
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "wrong error returned: %v", err)
+}
+
+func TestDecryptionKeys(t *testing.T) {
+	k := crypto.NewRandomKey()
+	other := crypto.NewRandomKey()
+
+	data := rtest.Random(23, 1000)
+	nonce := crypto.NewRandomNonce()
+	ciphertext := other.Seal(nil, nonce, data, nil)
+
+	_, err := k.Open(nil, nonce, ciphertext, nil)
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "wrong error returned: %v", err)
+
+	k.AddDecryptionKeys(other)
+	plaintext, err := k.Open(nil, nonce, ciphertext, nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, plaintext)
+
+	// data is still encrypted with k
+	ciphertext = k.Seal(nil, nonce, data, nil)
+	_, err = other.Open(nil, nonce, ciphertext, nil)
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "wrong error returned: %v", err)
+}
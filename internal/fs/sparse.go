@@ -0,0 +1,121 @@
+package fs
+
+import (
+	"io"
+)
+
+// NewSparseReader returns a reader for the regular file f which does not read
+// the holes of sparse files from disk but returns zeros for them instead. When
+// finding holes is not supported by the operating system or the file system,
+// or f is not a regular file, all data is read from f.
+func NewSparseReader(f File) io.Reader {
+	if !sparseSupported {
+		return f
+	}
+
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return f
+	}
+
+	return &sparseReader{f: f}
+}
+
+// sparseReader reads data regions from f and returns zeros for holes.
+type sparseReader struct {
+	f        File
+	offset   int64
+	dataEnd  int64
+	holeEnd  int64
+	disabled bool
+}
+
+func (rd *sparseReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for {
+		if rd.disabled {
+			return rd.f.Read(p)
+		}
+
+		if rd.offset < rd.dataEnd {
+			if int64(len(p)) > rd.dataEnd-rd.offset {
+				p = p[:rd.dataEnd-rd.offset]
+			}
+
+			n, err := rd.f.Read(p)
+			rd.offset += int64(n)
+			return n, err
+		}
+
+		if rd.offset < rd.holeEnd {
+			if int64(len(p)) > rd.holeEnd-rd.offset {
+				p = p[:rd.holeEnd-rd.offset]
+			}
+
+			for i := range p {
+				p[i] = 0
+			}
+			rd.offset += int64(len(p))
+			return len(p), nil
+		}
+
+		eof, err := rd.nextRegion()
+		if err != nil {
+			return 0, err
+		}
+
+		if eof {
+			return 0, io.EOF
+		}
+	}
+}
+
+// nextRegion finds the data region or hole which starts at the current
+// offset. It returns true if the offset is at the end of the file.
+func (rd *sparseReader) nextRegion() (eof bool, err error) {
+	data, err := rd.f.Seek(rd.offset, seekData)
+	if isNoData(err) {
+		// there is no more data after the offset, the file may end with a hole
+		fi, err := rd.f.Stat()
+		if err != nil {
+			return false, err
+		}
+
+		if rd.offset >= fi.Size() {
+			return true, nil
+		}
+
+		rd.holeEnd = fi.Size()
+		return false, nil
+	}
+
+	if err != nil {
+		// finding holes is not supported, read all data from the file
+		rd.disabled = true
+		_, err = rd.f.Seek(rd.offset, io.SeekStart)
+		return false, err
+	}
+
+	if data > rd.offset {
+		// the file position is now at the start of the next data region
+		rd.holeEnd = data
+		return false, nil
+	}
+
+	hole, err := rd.f.Seek(rd.offset, seekHole)
+	if err != nil {
+		return false, err
+	}
+
+	if hole <= rd.offset {
+		// the file was modified concurrently, read all remaining data
+		rd.disabled = true
+	}
+	rd.dataEnd = hole
+
+	_, err = rd.f.Seek(rd.offset, io.SeekStart)
+	return false, err
+}
@@ -0,0 +1,23 @@
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+const sparseSupported = true
+
+// whence values for lseek(2) to find data regions and holes in a file.
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// isNoData returns true if err is returned by Seek with seekData for an offset
+// after the last data region in a file.
+func isNoData(err error) bool {
+	if perr, ok := err.(*os.PathError); ok && perr.Err == syscall.ENXIO {
+		return true
+	}
+	return false
+}
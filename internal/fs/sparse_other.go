@@ -0,0 +1,14 @@
+// +build !linux
+
+package fs
+
+const sparseSupported = false
+
+const (
+	seekData = 0
+	seekHole = 0
+)
+
+func isNoData(err error) bool {
+	return false
+}
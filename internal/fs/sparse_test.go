@@ -0,0 +1,60 @@
+package fs_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/fs"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestSparseReader(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	const size = 8 << 20
+
+	var tests = []struct {
+		name    string
+		regions map[int64]int
+	}{
+		{"empty", nil},
+		{"hole", map[int64]int{}},
+		{"data", map[int64]int{0: size}},
+		{"leading-data", map[int64]int{0: 100000}},
+		{"trailing-data", map[int64]int{size - 100000: 100000}},
+		{"mixed", map[int64]int{1000: 5000, 3 << 20: 1 << 20, size - 1: 1}},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(tempdir, test.name)
+			f, err := os.Create(filename)
+			rtest.OK(t, err)
+
+			if test.regions != nil {
+				rtest.OK(t, f.Truncate(size))
+			}
+			for offset, length := range test.regions {
+				_, err = f.WriteAt(rtest.Random(i, length), offset)
+				rtest.OK(t, err)
+			}
+			rtest.OK(t, f.Close())
+
+			want, err := ioutil.ReadFile(filename)
+			rtest.OK(t, err)
+
+			f, err = os.Open(filename)
+			rtest.OK(t, err)
+			defer f.Close()
+
+			got, err := ioutil.ReadAll(fs.NewSparseReader(f))
+			rtest.OK(t, err)
+
+			rtest.Assert(t, bytes.Equal(want, got), "wrong data returned, want %d bytes, got %d bytes", len(want), len(got))
+		})
+	}
+}
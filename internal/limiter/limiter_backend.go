@@ -16,6 +16,15 @@ func LimitBackend(be restic.Backend, l Limiter) restic.Backend {
 	}
 }
 
+// Unwrap returns the backend wrapped by LimitBackend, or nil if be was not
+// returned by LimitBackend.
+func Unwrap(be restic.Backend) restic.Backend {
+	if r, ok := be.(rateLimitedBackend); ok {
+		return r.Backend
+	}
+	return nil
+}
+
 type rateLimitedBackend struct {
 	restic.Backend
 	limiter Limiter
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// Backend wraps a restic.Backend and records metrics for all requests.
+type Backend struct {
+	restic.Backend
+	m *Metrics
+}
+
+// make sure that *Backend implements restic.Backend
+var _ restic.Backend = &Backend{}
+
+// NewBackend returns a backend which records the metrics of all requests to
+// be in m.
+func NewBackend(be restic.Backend, m *Metrics) *Backend {
+	return &Backend{Backend: be, m: m}
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (rd *countingReader) Read(p []byte) (int, error) {
+	n, err := rd.Reader.Read(p)
+	rd.n += int64(n)
+	return n, err
+}
+
+// countingRewindReader counts the bytes read from the underlying reader.
+type countingRewindReader struct {
+	restic.RewindReader
+	n int64
+}
+
+func (rd *countingRewindReader) Read(p []byte) (int, error) {
+	n, err := rd.RewindReader.Read(p)
+	rd.n += int64(n)
+	return n, err
+}
+
+// Save stores the data in the backend under the handle.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	crd := &countingRewindReader{RewindReader: rd}
+	start := time.Now()
+	err := be.Backend.Save(ctx, h, crd)
+	be.m.Observe("save", h.Type, crd.n, time.Since(start), err)
+	return err
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset. The recorded duration includes the time fn needs to process
+// the data.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	var n int64
+	start := time.Now()
+	err := be.Backend.Load(ctx, h, length, offset, func(rd io.Reader) error {
+		crd := &countingReader{Reader: rd}
+		err := fn(crd)
+		n += crd.n
+		return err
+	})
+	be.m.Observe("load", h.Type, n, time.Since(start), err)
+	return err
+}
+
+// Stat returns information about the file.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	start := time.Now()
+	fi, err := be.Backend.Stat(ctx, h)
+	be.m.Observe("stat", h.Type, 0, time.Since(start), err)
+	return fi, err
+}
+
+// Test returns true if the file exists.
+func (be *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	start := time.Now()
+	found, err := be.Backend.Test(ctx, h)
+	be.m.Observe("test", h.Type, 0, time.Since(start), err)
+	return found, err
+}
+
+// Remove removes the file.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	start := time.Now()
+	err := be.Backend.Remove(ctx, h)
+	be.m.Observe("remove", h.Type, 0, time.Since(start), err)
+	return err
+}
+
+// List runs fn for each file of type t. The recorded duration includes the
+// time fn needs to process the files.
+func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	start := time.Now()
+	err := be.Backend.List(ctx, t, fn)
+	be.m.Observe("list", t, 0, time.Since(start), err)
+	return err
+}
@@ -0,0 +1,177 @@
+// Package metrics collects statistics about the requests to a backend and
+// exports them in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// durationBuckets are the upper bounds of the buckets of the request
+// duration histogram, in seconds.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type key struct {
+	operation string
+	tpe       restic.FileType
+}
+
+func (k key) labels() string {
+	return fmt.Sprintf("operation=%q,type=%q", k.operation, k.tpe)
+}
+
+type stats struct {
+	requests uint64
+	errors   uint64
+	bytes    uint64
+
+	// buckets counts the requests per duration bucket, the last element
+	// counts the requests which took longer than the largest bucket
+	buckets []uint64
+	seconds float64
+}
+
+// Metrics collects the number of requests, errors, transferred bytes and the
+// duration of the requests per operation and file type. It is safe for
+// concurrent use.
+type Metrics struct {
+	m     sync.Mutex
+	stats map[key]*stats
+}
+
+// New returns a new Metrics.
+func New() *Metrics {
+	return &Metrics{stats: make(map[key]*stats)}
+}
+
+// Observe records a request for the operation on a file of type t which
+// transferred n bytes, took d and returned err.
+func (m *Metrics) Observe(operation string, t restic.FileType, n int64, d time.Duration, err error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	k := key{operation: operation, tpe: t}
+	s, ok := m.stats[k]
+	if !ok {
+		s = &stats{buckets: make([]uint64, len(durationBuckets)+1)}
+		m.stats[k] = s
+	}
+
+	s.requests++
+	if err != nil {
+		s.errors++
+	}
+	if n > 0 {
+		s.bytes += uint64(n)
+	}
+
+	seconds := d.Seconds()
+	s.seconds += seconds
+	i := sort.SearchFloat64s(durationBuckets, seconds)
+	s.buckets[i]++
+}
+
+// copy returns a copy of s which does not share the buckets.
+func (s *stats) copy() stats {
+	c := *s
+	c.buckets = append([]uint64(nil), s.buckets...)
+	return c
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Write writes the metrics in the Prometheus text format to w.
+func (m *Metrics) Write(w io.Writer) error {
+	m.m.Lock()
+	keys := make([]key, 0, len(m.stats))
+	current := make(map[key]stats, len(m.stats))
+	for k, s := range m.stats {
+		keys = append(keys, k)
+		current[k] = s.copy()
+	}
+	m.m.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].operation != keys[j].operation {
+			return keys[i].operation < keys[j].operation
+		}
+		return keys[i].tpe < keys[j].tpe
+	})
+
+	wr := bufio.NewWriter(w)
+
+	counter := func(name, help string, value func(stats) uint64) {
+		fmt.Fprintf(wr, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(wr, "# TYPE %s counter\n", name)
+		for _, k := range keys {
+			fmt.Fprintf(wr, "%s{%s} %d\n", name, k.labels(), value(current[k]))
+		}
+	}
+
+	counter("restic_backend_requests_total", "Number of requests to the backend.",
+		func(s stats) uint64 { return s.requests })
+	counter("restic_backend_errors_total", "Number of requests to the backend which returned an error.",
+		func(s stats) uint64 { return s.errors })
+	counter("restic_backend_bytes_total", "Number of bytes uploaded to or downloaded from the backend.",
+		func(s stats) uint64 { return s.bytes })
+
+	const name = "restic_backend_request_duration_seconds"
+	fmt.Fprintf(wr, "# HELP %s Duration of the requests to the backend.\n", name)
+	fmt.Fprintf(wr, "# TYPE %s histogram\n", name)
+	for _, k := range keys {
+		s := current[k]
+		var count uint64
+		for i, bound := range durationBuckets {
+			count += s.buckets[i]
+			fmt.Fprintf(wr, "%s_bucket{%s,le=%q} %d\n", name, k.labels(), formatFloat(bound), count)
+		}
+		fmt.Fprintf(wr, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, k.labels(), s.requests)
+		fmt.Fprintf(wr, "%s_sum{%s} %s\n", name, k.labels(), formatFloat(s.seconds))
+		fmt.Fprintf(wr, "%s_count{%s} %d\n", name, k.labels(), s.requests)
+	}
+
+	return wr.Flush()
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_ = m.Write(w)
+}
+
+// WriteFile writes the metrics to the file filename. The file is replaced
+// atomically, so that a collector never reads an incomplete file.
+func (m *Metrics) WriteFile(filename string) error {
+	tmpname := filename + ".tmp"
+	f, err := os.OpenFile(tmpname, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+
+	err = m.Write(f)
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpname)
+		return errors.Wrap(err, "Write")
+	}
+
+	err = f.Close()
+	if err != nil {
+		_ = os.Remove(tmpname)
+		return errors.Wrap(err, "Close")
+	}
+
+	return errors.Wrap(os.Rename(tmpname, filename), "Rename")
+}
@@ -0,0 +1,127 @@
+package metrics_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/metrics"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func newTestSuite() *test.Suite {
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			return &memConfig{}, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(cfg interface{}) (restic.Backend, error) {
+			c := cfg.(*memConfig)
+			if c.be != nil {
+				ok, err := c.be.Test(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+				if err != nil {
+					return nil, err
+				}
+
+				if ok {
+					return nil, errors.New("config already exists")
+				}
+			}
+
+			c.be = mem.New()
+			return metrics.NewBackend(c.be, metrics.New()), nil
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(cfg interface{}) (restic.Backend, error) {
+			c := cfg.(*memConfig)
+			if c.be == nil {
+				c.be = mem.New()
+			}
+			return metrics.NewBackend(c.be, metrics.New()), nil
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(cfg interface{}) error {
+			// no cleanup needed
+			return nil
+		},
+	}
+}
+
+type memConfig struct {
+	be restic.Backend
+}
+
+func TestSuiteBackendMetrics(t *testing.T) {
+	newTestSuite().RunTests(t)
+}
+
+func TestMetricsBackend(t *testing.T) {
+	m := metrics.New()
+	be := metrics.NewBackend(mem.New(), m)
+
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+
+	err := be.Load(context.TODO(), h, 3, 0, func(rd io.Reader) error {
+		_, err := io.Copy(ioutil.Discard, rd)
+		return err
+	})
+	rtest.OK(t, err)
+
+	_, err = be.Stat(context.TODO(), restic.Handle{Type: restic.SnapshotFile, Name: "missing"})
+	rtest.Assert(t, err != nil, "expected an error for a missing file")
+
+	buf := bytes.NewBuffer(nil)
+	rtest.OK(t, m.Write(buf))
+	out := buf.String()
+	t.Log(out)
+
+	for _, line := range []string{
+		`restic_backend_requests_total{operation="save",type="data"} 1`,
+		`restic_backend_bytes_total{operation="save",type="data"} 6`,
+		`restic_backend_bytes_total{operation="load",type="data"} 3`,
+		`restic_backend_errors_total{operation="load",type="data"} 0`,
+		`restic_backend_errors_total{operation="stat",type="snapshot"} 1`,
+		`restic_backend_request_duration_seconds_bucket{operation="stat",type="snapshot",le="+Inf"} 1`,
+		`restic_backend_request_duration_seconds_count{operation="save",type="data"} 1`,
+		`# TYPE restic_backend_request_duration_seconds histogram`,
+	} {
+		rtest.Assert(t, strings.Contains(out, line+"\n"), "line %q not found in output", line)
+	}
+}
+
+func TestMetricsHistogram(t *testing.T) {
+	m := metrics.New()
+	m.Observe("load", restic.IndexFile, 0, 20*time.Millisecond, nil)
+	m.Observe("load", restic.IndexFile, 0, 2*time.Second, nil)
+	m.Observe("load", restic.IndexFile, 0, 2*time.Minute, nil)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+
+	for _, line := range []string{
+		`restic_backend_request_duration_seconds_bucket{operation="load",type="index",le="0.01"} 0`,
+		`restic_backend_request_duration_seconds_bucket{operation="load",type="index",le="0.025"} 1`,
+		`restic_backend_request_duration_seconds_bucket{operation="load",type="index",le="2.5"} 2`,
+		`restic_backend_request_duration_seconds_bucket{operation="load",type="index",le="60"} 2`,
+		`restic_backend_request_duration_seconds_bucket{operation="load",type="index",le="+Inf"} 3`,
+		`restic_backend_request_duration_seconds_sum{operation="load",type="index"} 122.02`,
+	} {
+		rtest.Assert(t, strings.Contains(out, line+"\n"), "line %q not found in output:\n%v", line, out)
+	}
+}
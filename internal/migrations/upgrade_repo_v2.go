@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+func init() {
+	register(&UpgradeRepoV2{})
+}
+
+// UpgradeRepoV2 upgrades a repository from version 1 to version 2, which
+// allows storing compressed blobs. Existing blobs are not modified, they are
+// only compressed when they are rewritten, e.g. by prune.
+type UpgradeRepoV2 struct{}
+
+// Check tests whether the migration can be applied.
+func (m *UpgradeRepoV2) Check(ctx context.Context, repo restic.Repository) (bool, error) {
+	cfg := repo.Config()
+	if cfg.Version != 1 {
+		debug.Log("repository version is %v", cfg.Version)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Apply runs the migration. The config is replaced by the repository, which
+// keeps a local backup so that the config can be recovered when restic is
+// interrupted while doing so.
+func (m *UpgradeRepoV2) Apply(ctx context.Context, repo restic.Repository) error {
+	cfg := repo.Config()
+	if cfg.Version != 1 {
+		return errors.Errorf("repository has version %v, only version 1 can be upgraded", cfg.Version)
+	}
+
+	cfg.Version = 2
+	return repo.ReplaceConfig(ctx, cfg)
+}
+
+// Name returns the name for this migration.
+func (m *UpgradeRepoV2) Name() string {
+	return "upgrade_repo_v2"
+}
+
+// Desc returns a short description what the migration does.
+func (m *UpgradeRepoV2) Desc() string {
+	return "upgrade a repository to version 2, which supports compression"
+}
@@ -0,0 +1,97 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func createV1Repo(t testing.TB, be restic.Backend) *repository.Repository {
+	repository.TestUseLowSecurityKDFParameters(t)
+
+	repo := repository.New(be)
+	err := repo.Init(context.TODO(), rtest.TestPassword, repository.InitOptions{Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repo
+}
+
+func TestUpgradeRepoV2(t *testing.T) {
+	repo := createV1Repo(t, mem.New())
+
+	m := &UpgradeRepoV2{}
+	ok, err := m.Check(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, ok, "migration check returned false")
+
+	rtest.OK(t, m.Apply(context.TODO(), repo))
+
+	cfg, err := restic.LoadConfig(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(2), cfg.Version)
+}
+
+// failConfigSaveBackend fails the first attempt to save the config, or all
+// attempts if failAll is set.
+type failConfigSaveBackend struct {
+	restic.Backend
+	failed  bool
+	failAll bool
+}
+
+func (be *failConfigSaveBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if h.Type == restic.ConfigFile && (!be.failed || be.failAll) {
+		be.failed = true
+		return errors.New("save failed")
+	}
+
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func TestUpgradeRepoV2Failure(t *testing.T) {
+	be := mem.New()
+	createV1Repo(t, be)
+
+	failBe := &failConfigSaveBackend{Backend: be}
+	repo := repository.New(failBe)
+	rtest.OK(t, repo.SearchKey(context.TODO(), rtest.TestPassword, 10))
+
+	m := &UpgradeRepoV2{}
+	err := m.Apply(context.TODO(), repo)
+	rtest.Assert(t, err != nil, "expected error for failed upgrade")
+	rtest.Assert(t, failBe.failed, "saving the new config did not fail")
+
+	// the old config must have been restored
+	repo = repository.New(be)
+	rtest.OK(t, repo.SearchKey(context.TODO(), rtest.TestPassword, 10))
+	rtest.Equals(t, uint(1), repo.Config().Version)
+}
+
+func TestUpgradeRepoV2Interrupted(t *testing.T) {
+	be := mem.New()
+	createV1Repo(t, be)
+
+	// saving the config always fails, so the repository is left without one
+	failBe := &failConfigSaveBackend{Backend: be, failAll: true}
+	repo := repository.New(failBe)
+	rtest.OK(t, repo.SearchKey(context.TODO(), rtest.TestPassword, 10))
+
+	m := &UpgradeRepoV2{}
+	err := m.Apply(context.TODO(), repo)
+	rtest.Assert(t, err != nil, "expected error for failed upgrade")
+
+	_, err = be.Stat(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+	rtest.Assert(t, be.IsNotExist(err), "config was not removed, error: %v", err)
+
+	// opening the repository again must recover the new config
+	repo = repository.New(be)
+	rtest.OK(t, repo.SearchKey(context.TODO(), rtest.TestPassword, 10))
+	rtest.Equals(t, uint(2), repo.Config().Version)
+}
@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+func init() {
+	register(&UpgradeRepoV4{})
+}
+
+// UpgradeRepoV4 upgrades a repository to version 4, which allows adding
+// write-only keys. Older versions of restic refuse to open the repository
+// afterwards, so that they cannot miss data saved by write-only clients.
+type UpgradeRepoV4 struct{}
+
+// Check tests whether the migration can be applied.
+func (m *UpgradeRepoV4) Check(ctx context.Context, repo restic.Repository) (bool, error) {
+	cfg := repo.Config()
+	if cfg.Version < 2 || cfg.Version >= restic.WriteOnlyRepoVersion {
+		debug.Log("repository version is %v", cfg.Version)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Apply runs the migration.
+func (m *UpgradeRepoV4) Apply(ctx context.Context, repo restic.Repository) error {
+	cfg := repo.Config()
+	if cfg.Version < 2 || cfg.Version >= restic.WriteOnlyRepoVersion {
+		return errors.Errorf("repository has version %v, only versions 2 and 3 can be upgraded", cfg.Version)
+	}
+
+	cfg.Version = restic.WriteOnlyRepoVersion
+	return repo.ReplaceConfig(ctx, cfg)
+}
+
+// Name returns the name for this migration.
+func (m *UpgradeRepoV4) Name() string {
+	return "upgrade_repo_v4"
+}
+
+// Desc returns a short description what the migration does.
+func (m *UpgradeRepoV4) Desc() string {
+	return "upgrade a repository to version 4, which supports write-only keys"
+}
@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestUpgradeRepoV4(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	m := &UpgradeRepoV4{}
+	ok, err := m.Check(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, ok, "migration check returned false")
+
+	rtest.OK(t, m.Apply(context.TODO(), repo))
+
+	cfg, err := restic.LoadConfig(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(restic.WriteOnlyRepoVersion), cfg.Version)
+
+	_, err = repository.AddWriteOnlyKey(context.TODO(), repo.(*repository.Repository), "write-only")
+	rtest.OK(t, err)
+
+	ok, err = m.Check(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, !ok, "migration check returned true for an upgraded repository")
+}
+
+func TestUpgradeRepoV4Version1(t *testing.T) {
+	repo := createV1Repo(t, mem.New())
+
+	m := &UpgradeRepoV4{}
+	ok, err := m.Check(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, !ok, "migration check returned true for a version 1 repository")
+}
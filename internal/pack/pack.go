@@ -34,9 +34,11 @@ func NewPacker(k *crypto.Key, wr io.Writer) *Packer {
 	return &Packer{k: k, wr: wr}
 }
 
-// Add saves the data read from rd as a new blob to the packer. Returned is the
-// number of bytes written to the pack.
-func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte) (int, error) {
+// Add saves the data read from rd as a new blob to the packer. If the data is
+// compressed, uncompressedLength is the length of the plaintext before
+// compression, otherwise it must be zero. Returned is the number of bytes
+// written to the pack.
+func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte, uncompressedLength int) (int, error) {
 	p.m.Lock()
 	defer p.m.Unlock()
 
@@ -45,13 +47,17 @@ func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte) (int, error)
 	n, err := p.wr.Write(data)
 	c.Length = uint(n)
 	c.Offset = p.bytes
+	c.UncompressedLength = uint(uncompressedLength)
 	p.bytes += uint(n)
 	p.blobs = append(p.blobs, c)
 
 	return n, errors.Wrap(err, "Write")
 }
 
-var entrySize = uint(binary.Size(restic.BlobType(0)) + binary.Size(uint32(0)) + len(restic.ID{}))
+var (
+	entrySize           = uint(binary.Size(restic.BlobType(0)) + binary.Size(uint32(0)) + len(restic.ID{}))
+	compressedEntrySize = entrySize + uint(binary.Size(uint32(0)))
+)
 
 // headerEntry is used with encoding/binary to read and write header entries
 type headerEntry struct {
@@ -60,6 +66,23 @@ type headerEntry struct {
 	ID     restic.ID
 }
 
+// compressedHeaderEntry is used with encoding/binary to read and write header
+// entries for compressed blobs.
+type compressedHeaderEntry struct {
+	Type               uint8
+	Length             uint32
+	UncompressedLength uint32
+	ID                 restic.ID
+}
+
+// These are the blob types as stored in the pack header.
+const (
+	headerTypeData           = 0
+	headerTypeTree           = 1
+	headerTypeCompressedData = 2
+	headerTypeCompressedTree = 3
+)
+
 // Finalize writes the header for all added blobs and finalizes the pack.
 // Returned are the number of bytes written, including the header. If the
 // underlying writer implements io.Closer, it is closed.
@@ -94,7 +117,7 @@ func (p *Packer) Finalize() (uint, error) {
 	bytesWritten += uint(hdrBytes)
 
 	// write length
-	err = binary.Write(p.wr, binary.LittleEndian, uint32(restic.CiphertextLength(len(p.blobs)*int(entrySize))))
+	err = binary.Write(p.wr, binary.LittleEndian, uint32(hdrBytes))
 	if err != nil {
 		return 0, errors.Wrap(err, "binary.Write")
 	}
@@ -112,16 +135,30 @@ func (p *Packer) Finalize() (uint, error) {
 // writeHeader constructs and writes the header to wr.
 func (p *Packer) writeHeader(wr io.Writer) (bytesWritten uint, err error) {
 	for _, b := range p.blobs {
-		entry := headerEntry{
-			Length: uint32(b.Length),
-			ID:     b.ID,
-		}
-
-		switch b.Type {
-		case restic.DataBlob:
-			entry.Type = 0
-		case restic.TreeBlob:
-			entry.Type = 1
+		var entry interface{}
+		size := entrySize
+
+		switch {
+		case b.Type == restic.DataBlob && !b.IsCompressed():
+			entry = headerEntry{Type: headerTypeData, Length: uint32(b.Length), ID: b.ID}
+		case b.Type == restic.TreeBlob && !b.IsCompressed():
+			entry = headerEntry{Type: headerTypeTree, Length: uint32(b.Length), ID: b.ID}
+		case b.Type == restic.DataBlob:
+			entry = compressedHeaderEntry{
+				Type:               headerTypeCompressedData,
+				Length:             uint32(b.Length),
+				UncompressedLength: uint32(b.UncompressedLength),
+				ID:                 b.ID,
+			}
+			size = compressedEntrySize
+		case b.Type == restic.TreeBlob:
+			entry = compressedHeaderEntry{
+				Type:               headerTypeCompressedTree,
+				Length:             uint32(b.Length),
+				UncompressedLength: uint32(b.UncompressedLength),
+				ID:                 b.ID,
+			}
+			size = compressedEntrySize
 		default:
 			return 0, errors.Errorf("invalid blob type %v", b.Type)
 		}
@@ -131,7 +168,7 @@ func (p *Packer) writeHeader(wr io.Writer) (bytesWritten uint, err error) {
 			return bytesWritten, errors.Wrap(err, "binary.Write")
 		}
 
-		bytesWritten += entrySize
+		bytesWritten += size
 	}
 
 	return
@@ -145,6 +182,15 @@ func (p *Packer) Size() uint {
 	return p.bytes
 }
 
+// HeaderFull returns true iff no more blobs can be added to the packer
+// because the header would get too large.
+func (p *Packer) HeaderFull() bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return len(p.blobs) >= maxHeaderEntries
+}
+
 // Count returns the number of blobs in this packer.
 func (p *Packer) Count() int {
 	p.m.Lock()
@@ -179,6 +225,9 @@ var (
 
 const (
 	maxHeaderSize = 16 * 1024 * 1024
+	// maximum number of entries in a header, computed for the larger entries
+	// of compressed blobs
+	maxHeaderEntries = (maxHeaderSize - crypto.Extension) / (1 + 4 + 4 + 32)
 	// number of header enries to download as part of header-length request
 	eagerEntries = 15
 )
@@ -186,7 +235,10 @@ const (
 // readRecords reads up to max records from the underlying ReaderAt, returning
 // the raw header, the total number of records in the header, and any error.
 // If the header contains fewer than max entries, the header is truncated to
-// the appropriate size.
+// the appropriate size. Since entries for compressed blobs are larger, the
+// number of records is computed as if all entries were uncompressed and
+// rounded up, so that reading that many records always returns the complete
+// header.
 func readRecords(rd io.ReaderAt, size int64, max int) ([]byte, int, error) {
 	var bufsize int
 	bufsize += max * int(entrySize)
@@ -213,8 +265,6 @@ func readRecords(rd io.ReaderAt, size int64, max int) ([]byte, int, error) {
 		err = InvalidFileError{Message: "header length is zero"}
 	case hlen < crypto.Extension:
 		err = InvalidFileError{Message: "header length is too small"}
-	case (hlen-crypto.Extension)%uint32(entrySize) != 0:
-		err = InvalidFileError{Message: "header length is invalid"}
 	case int64(hlen) > size-int64(headerLengthSize):
 		err = InvalidFileError{Message: "header is larger than file"}
 	case int64(hlen) > maxHeaderSize:
@@ -224,8 +274,8 @@ func readRecords(rd io.ReaderAt, size int64, max int) ([]byte, int, error) {
 		return nil, 0, errors.Wrap(err, "readHeader")
 	}
 
-	total := (int(hlen) - crypto.Extension) / int(entrySize)
-	if total < max {
+	total := (int(hlen) - crypto.Extension + int(entrySize) - 1) / int(entrySize)
+	if int(hlen) <= len(b) {
 		// truncate to the beginning of the pack header
 		b = b[len(b)-int(hlen):]
 	}
@@ -293,8 +343,8 @@ func List(k *crypto.Key, rd io.ReaderAt, size int64) (entries []restic.Blob, err
 
 	pos := uint(0)
 	for {
-		e := headerEntry{}
-		err = binary.Read(hdrRd, binary.LittleEndian, &e)
+		var tpe uint8
+		err = binary.Read(hdrRd, binary.LittleEndian, &tpe)
 		if errors.Cause(err) == io.EOF {
 			break
 		}
@@ -303,24 +353,45 @@ func List(k *crypto.Key, rd io.ReaderAt, size int64) (entries []restic.Blob, err
 			return nil, errors.Wrap(err, "binary.Read")
 		}
 
-		entry := restic.Blob{
-			Length: uint(e.Length),
-			ID:     e.ID,
-			Offset: pos,
+		var entry restic.Blob
+		switch tpe {
+		case headerTypeData, headerTypeTree:
+			e := struct {
+				Length uint32
+				ID     restic.ID
+			}{}
+			err = binary.Read(hdrRd, binary.LittleEndian, &e)
+			entry.Length = uint(e.Length)
+			entry.ID = e.ID
+		case headerTypeCompressedData, headerTypeCompressedTree:
+			e := struct {
+				Length             uint32
+				UncompressedLength uint32
+				ID                 restic.ID
+			}{}
+			err = binary.Read(hdrRd, binary.LittleEndian, &e)
+			entry.Length = uint(e.Length)
+			entry.UncompressedLength = uint(e.UncompressedLength)
+			entry.ID = e.ID
+		default:
+			return nil, errors.Errorf("invalid type %d", tpe)
+		}
+
+		if err != nil {
+			return nil, errors.Wrap(err, "binary.Read")
 		}
 
-		switch e.Type {
-		case 0:
+		switch tpe {
+		case headerTypeData, headerTypeCompressedData:
 			entry.Type = restic.DataBlob
-		case 1:
+		case headerTypeTree, headerTypeCompressedTree:
 			entry.Type = restic.TreeBlob
-		default:
-			return nil, errors.Errorf("invalid type %d", e.Type)
 		}
 
+		entry.Offset = pos
 		entries = append(entries, entry)
 
-		pos += uint(e.Length)
+		pos += entry.Length
 	}
 
 	return entries, nil
@@ -38,7 +38,7 @@ func newPack(t testing.TB, k *crypto.Key, lengths []int) ([]Buf, []byte, uint) {
 	// pack blobs
 	p := pack.NewPacker(k, nil)
 	for _, b := range bufs {
-		p.Add(restic.TreeBlob, b.id, b.data)
+		p.Add(restic.TreeBlob, b.id, b.data, 0)
 	}
 
 	_, err := p.Finalize()
@@ -94,6 +94,49 @@ func TestCreatePack(t *testing.T) {
 	verifyBlobs(t, bufs, k, bytes.NewReader(packData), packSize)
 }
 
+func TestCreatePackCompressed(t *testing.T) {
+	k := crypto.NewRandomKey()
+
+	var bufs []Buf
+	p := pack.NewPacker(k, nil)
+	// use more blobs than are loaded eagerly with the header length
+	for i := 0; i < 2*len(testLens); i++ {
+		l := testLens[i%len(testLens)]
+		data := rtest.Random(i, l)
+		id := restic.Hash(data)
+		bufs = append(bufs, Buf{data: data, id: id})
+
+		tpe := restic.DataBlob
+		if i%3 == 0 {
+			tpe = restic.TreeBlob
+		}
+
+		uncompressedLength := 0
+		if i%2 == 0 {
+			uncompressedLength = 2*l + 1
+		}
+
+		_, err := p.Add(tpe, id, data, uncompressedLength)
+		rtest.OK(t, err)
+	}
+
+	_, err := p.Finalize()
+	rtest.OK(t, err)
+
+	packData := p.Writer().(*bytes.Buffer).Bytes()
+	rtest.Equals(t, uint(len(packData)), p.Size())
+
+	entries, err := pack.List(k, bytes.NewReader(packData), int64(len(packData)))
+	rtest.OK(t, err)
+	rtest.Equals(t, p.Blobs(), entries)
+
+	for i, e := range entries {
+		buf := packData[e.Offset : e.Offset+e.Length]
+		rtest.Assert(t, bytes.Equal(bufs[i].data, buf),
+			"data for blob %v doesn't match", i)
+	}
+}
+
 var blobTypeJSON = []struct {
 	t   restic.BlobType
 	res string
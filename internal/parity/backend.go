@@ -0,0 +1,102 @@
+package parity
+
+import (
+	"context"
+	"io/ioutil"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Backend writes a parity file for each pack file saved to the wrapped
+// backend, and removes the parity file together with the pack.
+type Backend struct {
+	restic.Backend
+
+	// ParityShards is the number of parity shards for new pack files. When
+	// it is zero, no parity files are written, but existing parity files are
+	// still removed with their packs.
+	ParityShards int
+}
+
+// statically ensure that Backend implements restic.Backend.
+var _ restic.Backend = &Backend{}
+
+// NewBackend wraps be so that parity files with parityShards parity shards
+// are written for new pack files.
+func NewBackend(be restic.Backend, parityShards int) (*Backend, error) {
+	if parityShards < 0 || parityShards > MaxParityShards {
+		return nil, errors.Fatalf("invalid number of parity shards %d, must be between 0 and %d", parityShards, MaxParityShards)
+	}
+
+	return &Backend{Backend: be, ParityShards: parityShards}, nil
+}
+
+// Handle returns the handle of the parity file for the pack id.
+func Handle(id restic.ID) restic.Handle {
+	return restic.Handle{Type: restic.ParityFile, Name: id.String()}
+}
+
+// Save stores the data in the backend under the given handle. For pack files,
+// the parity file is saved first.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if h.Type != restic.DataFile || be.ParityShards == 0 {
+		return be.Backend.Save(ctx, h, rd)
+	}
+
+	buf, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return errors.Wrap(err, "ReadAll")
+	}
+
+	parity, err := Compute(buf, be.ParityShards)
+	if err != nil {
+		return err
+	}
+
+	ph := restic.Handle{Type: restic.ParityFile, Name: h.Name}
+	err = be.Backend.Save(ctx, ph, restic.NewByteReader(parity))
+	if err != nil {
+		return err
+	}
+
+	err = be.Backend.Save(ctx, h, restic.NewByteReader(buf))
+	if err != nil {
+		if rerr := be.Backend.Remove(ctx, ph); rerr != nil {
+			debug.Log("Remove(%v) returned error: %v", ph, rerr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Remove removes the file with the given handle. For pack files, the parity
+// file is removed as well if it exists.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	err := be.Backend.Remove(ctx, h)
+	if err != nil || h.Type != restic.DataFile {
+		return err
+	}
+
+	ph := restic.Handle{Type: restic.ParityFile, Name: h.Name}
+	err = be.Backend.Remove(ctx, ph)
+	if err != nil && !be.Backend.IsNotExist(err) {
+		// a stale parity file does not affect the repository
+		debug.Log("Remove(%v) returned error: %v", ph, err)
+	}
+
+	return nil
+}
+
+// List runs fn for each file of type t in the backend. The directory for
+// parity files is only created with the first parity file, so listing parity
+// files in a backend without any does not return an error.
+func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	err := be.Backend.List(ctx, t, fn)
+	if t == restic.ParityFile && be.Backend.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
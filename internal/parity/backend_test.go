@@ -0,0 +1,67 @@
+package parity_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/local"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/parity"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestBackend(t *testing.T) {
+	be, err := parity.NewBackend(mem.New(), 2)
+	rtest.OK(t, err)
+
+	data := rtest.Random(5, 12345)
+	id := restic.Hash(data)
+	h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+
+	found, err := be.Test(context.TODO(), parity.Handle(id))
+	rtest.OK(t, err)
+	rtest.Assert(t, found, "parity file was not saved")
+
+	// other files do not get parity data
+	sh := restic.Handle{Type: restic.SnapshotFile, Name: id.String()}
+	rtest.OK(t, be.Save(context.TODO(), sh, restic.NewByteReader(data)))
+	rtest.OK(t, be.Remove(context.TODO(), sh))
+
+	found, err = be.Test(context.TODO(), parity.Handle(id))
+	rtest.OK(t, err)
+	rtest.Assert(t, found, "parity file was removed with a snapshot")
+
+	// the parity file is removed with the pack, also when no new parity
+	// files are written
+	be.ParityShards = 0
+	rtest.OK(t, be.Remove(context.TODO(), h))
+
+	found, err = be.Test(context.TODO(), parity.Handle(id))
+	rtest.OK(t, err)
+	rtest.Assert(t, !found, "parity file was not removed")
+
+	// removing a pack without parity file works
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+	rtest.OK(t, be.Remove(context.TODO(), h))
+}
+
+func TestBackendListWithoutParity(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	lbe, err := local.Create(local.Config{Path: dir})
+	rtest.OK(t, err)
+
+	be, err := parity.NewBackend(lbe, 2)
+	rtest.OK(t, err)
+
+	// the directory for parity files does not exist yet
+	err = be.List(context.TODO(), restic.ParityFile, func(fi restic.FileInfo) error {
+		t.Errorf("unexpected parity file %v", fi.Name)
+		return nil
+	})
+	rtest.OK(t, err)
+}
@@ -0,0 +1,226 @@
+// Package parity computes Reed-Solomon parity data for pack files, which is
+// used to reconstruct packs damaged by bit rot.
+package parity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// DataShards is the number of shards a pack file is split into. Each parity
+// shard allows reconstructing one damaged data shard.
+const DataShards = 64
+
+// MaxParityShards is the maximum number of parity shards for a pack file.
+const MaxParityShards = 64
+
+// A parity file starts with a header, followed by the parity shards:
+//
+//	magic          [8]byte
+//	version        uint8
+//	data shards    uint8
+//	parity shards  uint8
+//	reserved       [5]byte
+//	pack size      uint64
+//	shard size     uint32
+//	shard hashes   [data shards + parity shards][32]byte
+//	header hash    [32]byte
+//	parity shards  [parity shards][shard size]byte
+//
+// The shard hashes are used to find the damaged shards. The header hash is
+// the SHA-256 hash of all preceding bytes. All integers are stored in little
+// endian byte order.
+var magic = []byte("rsparity")
+
+const (
+	version         = 1
+	fixedHeaderSize = 8 + 1 + 1 + 1 + 5 + 8 + 4
+)
+
+// ErrDamaged is returned when the parity file itself is damaged.
+var ErrDamaged = errors.New("parity file is damaged")
+
+type header struct {
+	dataShards, parityShards int
+	packSize                 uint64
+	shardSize                int
+	hashes                   [][sha256.Size]byte
+}
+
+func (h header) size() int {
+	return fixedHeaderSize + (h.dataShards+h.parityShards)*sha256.Size + sha256.Size
+}
+
+// split returns the data shards for pack, padded with zeros to the shard size.
+func (h header) split(pack []byte) [][]byte {
+	buf := make([]byte, h.dataShards*h.shardSize)
+	copy(buf, pack)
+
+	shards := make([][]byte, h.dataShards)
+	for i := range shards {
+		shards[i] = buf[i*h.shardSize : (i+1)*h.shardSize]
+	}
+	return shards
+}
+
+// Compute returns the parity file for pack with the given number of parity
+// shards.
+func Compute(pack []byte, parityShards int) ([]byte, error) {
+	if parityShards > MaxParityShards {
+		return nil, errors.Errorf("at most %d parity shards are supported, got %d", MaxParityShards, parityShards)
+	}
+
+	c, err := newCode(DataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+
+	h := header{
+		dataShards:   DataShards,
+		parityShards: parityShards,
+		packSize:     uint64(len(pack)),
+		shardSize:    (len(pack) + DataShards - 1) / DataShards,
+	}
+	if h.shardSize == 0 {
+		h.shardSize = 1
+	}
+
+	data := h.split(pack)
+	parity := make([][]byte, parityShards)
+	for i := range parity {
+		parity[i] = make([]byte, h.shardSize)
+	}
+	c.encode(data, parity)
+
+	for _, shard := range append(data, parity...) {
+		h.hashes = append(h.hashes, sha256.Sum256(shard))
+	}
+
+	buf := make([]byte, 0, h.size()+parityShards*h.shardSize)
+	buf = append(buf, magic...)
+	buf = append(buf, version, byte(h.dataShards), byte(h.parityShards), 0, 0, 0, 0, 0)
+
+	var num [8]byte
+	binary.LittleEndian.PutUint64(num[:], h.packSize)
+	buf = append(buf, num[:]...)
+	binary.LittleEndian.PutUint32(num[:], uint32(h.shardSize))
+	buf = append(buf, num[:4]...)
+
+	for _, hash := range h.hashes {
+		buf = append(buf, hash[:]...)
+	}
+
+	hash := sha256.Sum256(buf)
+	buf = append(buf, hash[:]...)
+
+	for _, shard := range parity {
+		buf = append(buf, shard...)
+	}
+
+	return buf, nil
+}
+
+func parseHeader(buf []byte) (header, error) {
+	if len(buf) < fixedHeaderSize || !bytes.Equal(buf[:len(magic)], magic) {
+		return header{}, errors.Wrap(ErrDamaged, "invalid header")
+	}
+
+	if buf[8] != version {
+		return header{}, errors.Errorf("unsupported parity file version %d", buf[8])
+	}
+
+	h := header{
+		dataShards:   int(buf[9]),
+		parityShards: int(buf[10]),
+		packSize:     binary.LittleEndian.Uint64(buf[16:24]),
+		shardSize:    int(binary.LittleEndian.Uint32(buf[24:28])),
+	}
+
+	if len(buf) < h.size() {
+		return header{}, errors.Wrap(ErrDamaged, "header is truncated")
+	}
+
+	hashStart := h.size() - sha256.Size
+	if sha256.Sum256(buf[:hashStart]) != sha256FromBytes(buf[hashStart:h.size()]) {
+		return header{}, errors.Wrap(ErrDamaged, "header hash does not match")
+	}
+
+	if h.dataShards == 0 || h.parityShards == 0 || h.shardSize == 0 ||
+		uint64(h.dataShards)*uint64(h.shardSize) < h.packSize {
+		return header{}, errors.Wrap(ErrDamaged, "invalid header values")
+	}
+
+	for i := 0; i < h.dataShards+h.parityShards; i++ {
+		start := fixedHeaderSize + i*sha256.Size
+		h.hashes = append(h.hashes, sha256FromBytes(buf[start:start+sha256.Size]))
+	}
+
+	return h, nil
+}
+
+func sha256FromBytes(buf []byte) (hash [sha256.Size]byte) {
+	copy(hash[:], buf)
+	return hash
+}
+
+// Repair reconstructs the pack with the given ID from the possibly damaged or
+// truncated content in pack and the parity file. The repaired pack is
+// returned, its hash is verified against id.
+func Repair(id restic.ID, pack, parityFile []byte) ([]byte, error) {
+	h, err := parseHeader(parityFile)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newCode(h.dataShards, h.parityShards)
+	if err != nil {
+		return nil, err
+	}
+
+	shards := h.split(pack)
+	for i := 0; i < h.parityShards; i++ {
+		start := h.size() + i*h.shardSize
+		shard := make([]byte, h.shardSize)
+		if start < len(parityFile) {
+			copy(shard, parityFile[start:])
+		}
+		shards = append(shards, shard)
+	}
+
+	valid := make([]bool, len(shards))
+	damaged := 0
+	for i, shard := range shards {
+		valid[i] = sha256.Sum256(shard) == h.hashes[i]
+		if !valid[i] && i < h.dataShards {
+			damaged++
+		}
+	}
+
+	if damaged == 0 && uint64(len(pack)) == h.packSize {
+		if !restic.Hash(pack).Equal(id) {
+			return nil, errors.Errorf("pack %v does not match the parity file", id.Str())
+		}
+		return pack, nil
+	}
+
+	err = c.reconstruct(shards, valid)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to reconstruct pack %v", id.Str())
+	}
+
+	repaired := make([]byte, 0, h.dataShards*h.shardSize)
+	for _, shard := range shards[:h.dataShards] {
+		repaired = append(repaired, shard...)
+	}
+	repaired = repaired[:h.packSize]
+
+	if !restic.Hash(repaired).Equal(id) {
+		return nil, errors.Errorf("reconstructed pack %v has the wrong hash", id.Str())
+	}
+
+	return repaired, nil
+}
@@ -0,0 +1,90 @@
+package parity_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/restic/restic/internal/parity"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// corruptShards flips bits in n different data shards of the pack.
+func corruptShards(rnd *rand.Rand, pack []byte, n int) {
+	shardSize := (len(pack) + parity.DataShards - 1) / parity.DataShards
+	for _, shard := range rnd.Perm(parity.DataShards)[:n] {
+		offset := shard*shardSize + rnd.Intn(shardSize)
+		if offset < len(pack) {
+			pack[offset] ^= 0x55
+		}
+	}
+}
+
+func TestRepair(t *testing.T) {
+	var tests = []struct {
+		size         int
+		parityShards int
+		damaged      int
+		truncate     int
+		ok           bool
+	}{
+		{size: 0, parityShards: 1, ok: true},
+		{size: 10, parityShards: 1, ok: true},
+		{size: 4 << 20, parityShards: 4, ok: true},
+		{size: 4<<20 + 17, parityShards: 4, damaged: 1, ok: true},
+		{size: 1<<20 + 3, parityShards: 4, damaged: 4, ok: true},
+		{size: 1<<20 + 3, parityShards: 4, damaged: 5, ok: false},
+		{size: 100000, parityShards: 2, truncate: 1000, ok: true},
+		{size: 100000, parityShards: 2, truncate: 5000, ok: false},
+		{size: 5000, parityShards: 64, damaged: 64, ok: true},
+	}
+
+	for i, test := range tests {
+		rnd := rand.New(rand.NewSource(int64(i)))
+		pack := rtest.Random(i, test.size)
+		id := restic.Hash(pack)
+
+		parityFile, err := parity.Compute(pack, test.parityShards)
+		rtest.OK(t, err)
+
+		damaged := append([]byte{}, pack...)
+		corruptShards(rnd, damaged, test.damaged)
+		damaged = damaged[:len(damaged)-test.truncate]
+
+		repaired, err := parity.Repair(id, damaged, parityFile)
+		if !test.ok {
+			rtest.Assert(t, err != nil, "test %d: expected an error", i)
+			continue
+		}
+
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(pack, repaired), "test %d: wrong data returned", i)
+	}
+}
+
+func TestRepairDamagedParity(t *testing.T) {
+	pack := rtest.Random(23, 500000)
+	id := restic.Hash(pack)
+
+	parityFile, err := parity.Compute(pack, 3)
+	rtest.OK(t, err)
+
+	// damaged parity shards are not used
+	damagedParity := append([]byte{}, parityFile...)
+	damagedParity[len(damagedParity)-100] ^= 0xff
+
+	damaged := append([]byte{}, pack...)
+	corruptShards(rand.New(rand.NewSource(1)), damaged, 2)
+
+	repaired, err := parity.Repair(id, damaged, damagedParity)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(pack, repaired), "wrong data returned")
+
+	// a damaged header cannot be used at all
+	damagedParity = append([]byte{}, parityFile...)
+	damagedParity[30] ^= 0xff
+
+	_, err = parity.Repair(id, damaged, damagedParity)
+	rtest.Assert(t, err != nil, "expected an error for a damaged header")
+}
@@ -0,0 +1,221 @@
+package parity
+
+import (
+	"github.com/restic/restic/internal/errors"
+)
+
+// The Reed-Solomon code works on bytes as elements of the finite field
+// GF(2^8), using the generator polynomial x^8 + x^4 + x^3 + x^2 + 1.
+const fieldPolynomial = 0x11d
+
+var (
+	expTable [510]byte
+	logTable [256]byte
+	mulTable [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = byte(i)
+
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= fieldPolynomial
+		}
+	}
+
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			mulTable[a][b] = expTable[int(logTable[a])+int(logTable[b])]
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	return mulTable[a][b]
+}
+
+func gfInv(a byte) byte {
+	return expTable[255-int(logTable[a])]
+}
+
+// mulAdd computes dst[i] ^= c * src[i] for all bytes of src.
+func mulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+
+	mt := &mulTable[c]
+	dst = dst[:len(src)]
+	for i, b := range src {
+		dst[i] ^= mt[b]
+	}
+}
+
+// matrix is a matrix over GF(2^8).
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+// invert returns the inverse of the square matrix m, computed by Gauss-Jordan
+// elimination.
+func (m matrix) invert() (matrix, error) {
+	n := len(m)
+	work := newMatrix(n, 2*n)
+	for i := range m {
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for row := col; row < n; row++ {
+			if work[row][col] != 0 {
+				pivot = row
+				break
+			}
+		}
+
+		if pivot < 0 {
+			return nil, errors.New("matrix is singular")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		inv := gfInv(work[col][col])
+		for i := range work[col] {
+			work[col][i] = gfMul(work[col][i], inv)
+		}
+
+		for row := 0; row < n; row++ {
+			if row != col && work[row][col] != 0 {
+				mulAdd(work[row], work[col], work[row][col])
+			}
+		}
+	}
+
+	result := newMatrix(n, n)
+	for i := range result {
+		copy(result[i], work[i][n:])
+	}
+	return result, nil
+}
+
+// code is a systematic Reed-Solomon erasure code: the data shards are stored
+// unmodified and the parity shards are computed from them. Any dataShards of
+// the shards suffice to reconstruct all data shards.
+type code struct {
+	dataShards, parityShards int
+
+	// coefficients holds one row for each parity shard. It is a Cauchy
+	// matrix, so every square submatrix of the identity matrix extended by
+	// these rows is invertible.
+	coefficients matrix
+}
+
+func newCode(dataShards, parityShards int) (*code, error) {
+	if dataShards <= 0 || parityShards <= 0 || dataShards+parityShards > 256 {
+		return nil, errors.Errorf("invalid number of shards: %d data shards, %d parity shards", dataShards, parityShards)
+	}
+
+	c := &code{
+		dataShards:   dataShards,
+		parityShards: parityShards,
+		coefficients: newMatrix(parityShards, dataShards),
+	}
+
+	for i := range c.coefficients {
+		for j := range c.coefficients[i] {
+			c.coefficients[i][j] = gfInv(byte(dataShards+i) ^ byte(j))
+		}
+	}
+
+	return c, nil
+}
+
+// row returns the row of the encoding matrix for shard i.
+func (c *code) row(i int) []byte {
+	if i >= c.dataShards {
+		return c.coefficients[i-c.dataShards]
+	}
+
+	row := make([]byte, c.dataShards)
+	row[i] = 1
+	return row
+}
+
+// encode computes the parity shards from the data shards. All shards must
+// have the same size.
+func (c *code) encode(data, parity [][]byte) {
+	for i, p := range parity {
+		for j := range p {
+			p[j] = 0
+		}
+
+		for j, d := range data {
+			mulAdd(p, d, c.coefficients[i][j])
+		}
+	}
+}
+
+// reconstruct recomputes the data shards for which valid is false from the
+// other shards. shards contains the data shards followed by the parity
+// shards, all of the same size.
+func (c *code) reconstruct(shards [][]byte, valid []bool) error {
+	var missing []int
+	for i := 0; i < c.dataShards; i++ {
+		if !valid[i] {
+			missing = append(missing, i)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	// select the first dataShards valid shards
+	var use []int
+	for i := range shards {
+		if valid[i] {
+			use = append(use, i)
+		}
+		if len(use) == c.dataShards {
+			break
+		}
+	}
+
+	if len(use) < c.dataShards {
+		return errors.Errorf("too many damaged shards, need %d intact shards but only %d are available", c.dataShards, len(use))
+	}
+
+	m := make(matrix, c.dataShards)
+	for i, shard := range use {
+		m[i] = c.row(shard)
+	}
+
+	inv, err := m.invert()
+	if err != nil {
+		return err
+	}
+
+	for _, i := range missing {
+		d := shards[i]
+		for j := range d {
+			d[j] = 0
+		}
+
+		for j, shard := range use {
+			mulAdd(d, shards[shard], inv[i][j])
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package parity
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestGaloisField(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if gfMul(byte(a), gfInv(byte(a))) != 1 {
+			t.Fatalf("inverse of %d is wrong", a)
+		}
+
+		if gfMul(byte(a), 1) != byte(a) || gfMul(byte(a), 0) != 0 {
+			t.Fatalf("multiplication with %d is wrong", a)
+		}
+	}
+}
+
+func TestCodeReconstruct(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+
+	for _, test := range []struct{ data, parity int }{
+		{1, 1}, {4, 2}, {10, 4}, {64, 8}, {200, 56},
+	} {
+		c, err := newCode(test.data, test.parity)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		shards := make([][]byte, test.data+test.parity)
+		for i := range shards {
+			shards[i] = make([]byte, 100)
+			if i < test.data {
+				rnd.Read(shards[i])
+			}
+		}
+		c.encode(shards[:test.data], shards[test.data:])
+
+		want := make([][]byte, test.data)
+		for i := range want {
+			want[i] = append([]byte{}, shards[i]...)
+		}
+
+		// drop as many random shards as there are parity shards
+		valid := make([]bool, len(shards))
+		for i := range valid {
+			valid[i] = true
+		}
+		for _, i := range rnd.Perm(len(shards))[:test.parity] {
+			valid[i] = false
+			rnd.Read(shards[i])
+		}
+
+		if err = c.reconstruct(shards, valid); err != nil {
+			t.Fatalf("%v: %v", test, err)
+		}
+
+		for i := range want {
+			if !bytes.Equal(want[i], shards[i]) {
+				t.Fatalf("%v: shard %d was not reconstructed", test, i)
+			}
+		}
+	}
+}
@@ -34,6 +34,10 @@ type Entry struct {
 	// points to the old node if available, interface{} is used to prevent
 	// circular import
 	Node interface{}
+
+	// Changed is set when the file was present in the old snapshot, but has
+	// been modified since
+	Changed bool
 }
 
 func (e Entry) Path() string          { return e.path }
@@ -26,9 +26,10 @@ type Index struct {
 }
 
 type indexEntry struct {
-	packID restic.ID
-	offset uint
-	length uint
+	packID             restic.ID
+	offset             uint
+	length             uint
+	uncompressedLength uint
 }
 
 // NewIndex returns a new index.
@@ -41,9 +42,10 @@ func NewIndex() *Index {
 
 func (idx *Index) store(blob restic.PackedBlob) {
 	newEntry := indexEntry{
-		packID: blob.PackID,
-		offset: blob.Offset,
-		length: blob.Length,
+		packID:             blob.PackID,
+		offset:             blob.Offset,
+		length:             blob.Length,
+		uncompressedLength: blob.UncompressedLength,
 	}
 	h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
 	idx.pack[h] = append(idx.pack[h], newEntry)
@@ -122,10 +124,11 @@ func (idx *Index) Lookup(id restic.ID, tpe restic.BlobType) (blobs []restic.Pack
 		for _, p := range packs {
 			blob := restic.PackedBlob{
 				Blob: restic.Blob{
-					Type:   tpe,
-					Length: p.length,
-					ID:     id,
-					Offset: p.offset,
+					Type:               tpe,
+					Length:             p.length,
+					ID:                 id,
+					Offset:             p.offset,
+					UncompressedLength: p.uncompressedLength,
 				},
 				PackID: p.packID,
 			}
@@ -149,10 +152,11 @@ func (idx *Index) ListPack(id restic.ID) (list []restic.PackedBlob) {
 			if entry.packID == id {
 				list = append(list, restic.PackedBlob{
 					Blob: restic.Blob{
-						ID:     h.ID,
-						Type:   h.Type,
-						Length: entry.length,
-						Offset: entry.offset,
+						ID:                 h.ID,
+						Type:               h.Type,
+						Length:             entry.length,
+						Offset:             entry.offset,
+						UncompressedLength: entry.uncompressedLength,
 					},
 					PackID: entry.packID,
 				})
@@ -182,7 +186,7 @@ func (idx *Index) LookupSize(id restic.ID, tpe restic.BlobType) (plaintextLength
 		return 0, found
 	}
 
-	return uint(restic.PlaintextLength(int(blobs[0].Length))), true
+	return blobs[0].DataLength(), true
 }
 
 // Supersedes returns the list of indexes this index supersedes, if any.
@@ -225,10 +229,11 @@ func (idx *Index) Each(ctx context.Context) <-chan restic.PackedBlob {
 					return
 				case ch <- restic.PackedBlob{
 					Blob: restic.Blob{
-						ID:     h.ID,
-						Type:   h.Type,
-						Offset: blob.offset,
-						Length: blob.length,
+						ID:                 h.ID,
+						Type:               h.Type,
+						Offset:             blob.offset,
+						Length:             blob.length,
+						UncompressedLength: blob.uncompressedLength,
 					},
 					PackID: blob.packID,
 				}:
@@ -278,10 +283,11 @@ type packJSON struct {
 }
 
 type blobJSON struct {
-	ID     restic.ID       `json:"id"`
-	Type   restic.BlobType `json:"type"`
-	Offset uint            `json:"offset"`
-	Length uint            `json:"length"`
+	ID                 restic.ID       `json:"id"`
+	Type               restic.BlobType `json:"type"`
+	Offset             uint            `json:"offset"`
+	Length             uint            `json:"length"`
+	UncompressedLength uint            `json:"uncompressed_length,omitempty"`
 }
 
 // generatePackList returns a list of packs.
@@ -316,10 +322,11 @@ func (idx *Index) generatePackList() ([]*packJSON, error) {
 
 			// add blob
 			p.Blobs = append(p.Blobs, blobJSON{
-				ID:     h.ID,
-				Type:   h.Type,
-				Offset: blob.offset,
-				Length: blob.length,
+				ID:                 h.ID,
+				Type:               h.Type,
+				Offset:             blob.offset,
+				Length:             blob.length,
+				UncompressedLength: blob.uncompressedLength,
 			})
 		}
 	}
@@ -477,10 +484,11 @@ func DecodeIndex(buf []byte) (idx *Index, err error) {
 		for _, blob := range pack.Blobs {
 			idx.store(restic.PackedBlob{
 				Blob: restic.Blob{
-					Type:   blob.Type,
-					ID:     blob.ID,
-					Offset: blob.Offset,
-					Length: blob.Length,
+					Type:               blob.Type,
+					ID:                 blob.ID,
+					Offset:             blob.Offset,
+					Length:             blob.Length,
+					UncompressedLength: blob.UncompressedLength,
 				},
 				PackID: pack.ID,
 			})
@@ -522,10 +530,11 @@ func DecodeOldIndex(buf []byte) (idx *Index, err error) {
 		for _, blob := range pack.Blobs {
 			idx.store(restic.PackedBlob{
 				Blob: restic.Blob{
-					Type:   blob.Type,
-					ID:     blob.ID,
-					Offset: blob.Offset,
-					Length: blob.Length,
+					Type:               blob.Type,
+					ID:                 blob.ID,
+					Offset:             blob.Offset,
+					Length:             blob.Length,
+					UncompressedLength: blob.UncompressedLength,
 				},
 				PackID: pack.ID,
 			})
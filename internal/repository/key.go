@@ -2,8 +2,10 @@ package repository
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
 	"time"
@@ -22,6 +24,9 @@ var (
 
 	// ErrMaxKeysReached is returned when the maximum number of keys was checked and no key could be found.
 	ErrMaxKeysReached = errors.Fatal("maximum number of keys reached")
+
+	// ErrWriteOnly is returned when data is loaded from a repository which was opened with a write-only key.
+	ErrWriteOnly = errors.Fatal("the repository was opened with a write-only key, which cannot read existing data")
 )
 
 // Key represents an encrypted master key for a repository.
@@ -37,21 +42,46 @@ type Key struct {
 	Salt []byte `json:"salt"`
 	Data []byte `json:"data"`
 
+	// parameters for Argon2id, Memory is specified in KiB
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+
+	// Pending is set for the key which holds the new master key while a
+	// master key rotation is in progress.
+	Pending bool `json:"pending,omitempty"`
+
+	// WriteOnly is set for keys which can only be used to add new data to
+	// the repository. Data contains the config instead of the master key.
+	WriteOnly bool `json:"write_only,omitempty"`
+
+	// Sealed contains the master key which a write-only client used for
+	// the data it saved, encrypted for the public key of the repository.
+	// Such keys are not protected by a password.
+	Sealed []byte `json:"sealed,omitempty"`
+
 	user   *crypto.Key
 	master *crypto.Key
 
+	// config is the config of the repository for write-only keys
+	config *restic.Config
+
 	name string
 }
 
 // Params tracks the parameters used for the KDF. If not set, it will be
-// calibrated on the first run of AddKey().
+// calibrated for KDFName on the first run of AddKey().
 var Params *crypto.Params
 
 var (
+	// KDFName is the key derivation function used for new keys when Params
+	// is not set.
+	KDFName = crypto.KDFScrypt
+
 	// KDFTimeout specifies the maximum runtime for the KDF.
 	KDFTimeout = 500 * time.Millisecond
 
-	// KDFMemory limits the memory the KDF is allowed to use.
+	// KDFMemory limits the memory (in MiB) the KDF is allowed to use.
 	KDFMemory = 60
 )
 
@@ -69,27 +99,47 @@ func OpenKey(ctx context.Context, s *Repository, name string, password string) (
 		return nil, err
 	}
 
+	err = k.open(password)
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// open derives the user key from password and decrypts the master key.
+func (k *Key) open(password string) (err error) {
 	// check KDF
-	if k.KDF != "scrypt" {
-		return nil, errors.New("only supported KDF is scrypt()")
+	if k.KDF != crypto.KDFScrypt && k.KDF != crypto.KDFArgon2id {
+		return errors.Errorf("unsupported KDF %q", k.KDF)
 	}
 
 	// derive user key
-	params := crypto.Params{
-		N: k.N,
-		R: k.R,
-		P: k.P,
-	}
-	k.user, err = crypto.KDF(params, k.Salt, password)
+	k.user, err = crypto.KDF(k.Params(), k.Salt, password)
 	if err != nil {
-		return nil, errors.Wrap(err, "crypto.KDF")
+		return errors.Wrap(err, "crypto.KDF")
 	}
 
 	// decrypt master keys
 	nonce, ciphertext := k.Data[:k.user.NonceSize()], k.Data[k.user.NonceSize():]
 	buf, err := k.user.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	if k.WriteOnly {
+		k.config = &restic.Config{}
+		err = json.Unmarshal(buf, k.config)
+		if err != nil {
+			debug.Log("Unmarshal() returned error %v", err)
+			return errors.Wrap(err, "Unmarshal")
+		}
+
+		if !k.user.Valid() || len(k.config.PublicKey) == 0 {
+			return errors.New("Invalid key for repository")
+		}
+
+		return nil
 	}
 
 	// restore json
@@ -97,23 +147,25 @@ func OpenKey(ctx context.Context, s *Repository, name string, password string) (
 	err = json.Unmarshal(buf, k.master)
 	if err != nil {
 		debug.Log("Unmarshal() returned error %v", err)
-		return nil, errors.Wrap(err, "Unmarshal")
+		return errors.Wrap(err, "Unmarshal")
 	}
-	k.name = name
 
 	if !k.Valid() {
-		return nil, errors.New("Invalid key for repository")
+		return errors.New("Invalid key for repository")
 	}
 
-	return k, nil
+	return nil
 }
 
 // SearchKey tries to decrypt at most maxKeys keys in the backend with the
 // given password. If none could be found, ErrNoKeyFound is returned. When
 // maxKeys is reached, ErrMaxKeysReached is returned. When setting maxKeys to
-// zero, all keys in the repo are checked.
+// zero, all keys in the repo are checked. When the repository contains the
+// key of an unfinished master key rotation, ErrKeyRotationInProgress is
+// returned.
 func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int) (k *Key, err error) {
 	checked := 0
+	pending := false
 
 	// try at most maxKeysForSearch keys in repo
 	err = s.Backend().List(ctx, restic.KeyFile, func(fi restic.FileInfo) error {
@@ -127,8 +179,25 @@ func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int)
 			return nil
 		}
 
+		key, err := LoadKey(ctx, s, fi.Name)
+		if err != nil {
+			debug.Log("LoadKey(%v) returned error %v", fi.Name, err)
+			return err
+		}
+
+		if key.Pending {
+			debug.Log("key %q belongs to a master key rotation", fi.Name)
+			pending = true
+			return nil
+		}
+
+		if key.Sealed != nil {
+			debug.Log("key %q is a sealed key without password", fi.Name)
+			return nil
+		}
+
 		debug.Log("trying key %q", fi.Name)
-		key, err := OpenKey(ctx, s, fi.Name, password)
+		err = key.open(password)
 		if err != nil {
 			debug.Log("key %v returned error %v", fi.Name, err)
 
@@ -149,6 +218,10 @@ func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int)
 		return nil, err
 	}
 
+	if pending {
+		return nil, ErrKeyRotationInProgress
+	}
+
 	if k == nil {
 		return nil, ErrNoKeyFound
 	}
@@ -169,78 +242,229 @@ func LoadKey(ctx context.Context, s *Repository, name string) (k *Key, err error
 	if err != nil {
 		return nil, errors.Wrap(err, "Unmarshal")
 	}
+	k.name = name
 
 	return k, nil
 }
 
 // AddKey adds a new key to an already existing repository.
 func AddKey(ctx context.Context, s *Repository, password string, template *crypto.Key) (*Key, error) {
-	// make sure we have valid KDF parameters
-	if Params == nil {
-		p, err := crypto.Calibrate(KDFTimeout, KDFMemory)
+	return addKey(ctx, s, password, template, false)
+}
+
+// addKey saves a new key for the master key template, which is encrypted with
+// password. When template is nil, a new master key is generated.
+func addKey(ctx context.Context, s *Repository, password string, template *crypto.Key, pending bool) (*Key, error) {
+	newkey := newKey()
+	newkey.Pending = pending
+
+	err := newkey.setPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	if template == nil {
+		// generate new random master keys
+		newkey.master = crypto.NewRandomKey()
+	} else {
+		// copy master keys from old key
+		newkey.master = template
+	}
+
+	// encrypt master keys (as json) with user key
+	buf, err := json.Marshal(newkey.master)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+	newkey.encryptData(buf)
+
+	err = newkey.save(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return newkey, nil
+}
+
+// AddWriteOnlyKey adds a key which can only be used to add new data to the
+// repository. Instead of the master key, it contains the config without the
+// private key. When the repository has no key pair yet, a new one is created
+// and saved in the config. The repository must have at least version
+// restic.WriteOnlyRepoVersion.
+func AddWriteOnlyKey(ctx context.Context, s *Repository, password string) (*Key, error) {
+	if s.cfg.Version < restic.WriteOnlyRepoVersion {
+		return nil, errors.Errorf("write-only keys require repository version %v or later", restic.WriteOnlyRepoVersion)
+	}
+
+	if len(s.cfg.PublicKey) == 0 {
+		err := s.createKeyPair(ctx)
 		if err != nil {
-			return nil, errors.Wrap(err, "Calibrate")
+			return nil, err
 		}
+	}
 
-		Params = &p
-		debug.Log("calibrated KDF parameters are %v", p)
+	newkey := newKey()
+	newkey.WriteOnly = true
+
+	err := newkey.setPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := s.cfg
+	cfg.PrivateKey = nil
+	newkey.config = &cfg
+
+	buf, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+	newkey.encryptData(buf)
+
+	err = newkey.save(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return newkey, nil
+}
+
+// addSealedKey saves the master key of a write-only client encrypted for the
+// public key of the repository.
+//
+// Sealed keys are not protected by a password. Still, they contain cheap
+// scrypt parameters and random data, so that older versions of restic treat
+// them like keys for another password and go on to report the unsupported
+// repository version instead of failing on the missing KDF.
+func addSealedKey(ctx context.Context, s *Repository, master *crypto.Key, publicKey []byte) (*Key, error) {
+	sealed, err := crypto.SealKey(master, publicKey)
+	if err != nil {
+		return nil, err
 	}
 
-	// fill meta data about key
-	newkey := &Key{
+	newkey := newKey()
+	newkey.Sealed = sealed
+	newkey.master = master
+
+	newkey.KDF = crypto.KDFScrypt
+	newkey.N, newkey.R, newkey.P = 2, 1, 1
+	newkey.Salt, err = crypto.NewSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	newkey.Data = make([]byte, 64)
+	_, err = io.ReadFull(rand.Reader, newkey.Data)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFull")
+	}
+
+	err = newkey.save(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return newkey, nil
+}
+
+// newKey returns a key with the meta data for the current user and host.
+func newKey() *Key {
+	k := &Key{
 		Created: time.Now(),
-		KDF:     "scrypt",
-		N:       Params.N,
-		R:       Params.R,
-		P:       Params.P,
 	}
 
 	hn, err := os.Hostname()
 	if err == nil {
-		newkey.Hostname = hn
+		k.Hostname = hn
 	}
 
 	usr, err := user.Current()
 	if err == nil {
-		newkey.Username = usr.Username
+		k.Username = usr.Username
 	}
 
-	// generate random salt
-	newkey.Salt, err = crypto.NewSalt()
-	if err != nil {
-		panic("unable to read enough random bytes for salt: " + err.Error())
+	return k
+}
+
+// Params returns the parameters of the KDF used for the key.
+func (k *Key) Params() crypto.Params {
+	return crypto.Params{
+		KDF:     k.KDF,
+		N:       k.N,
+		R:       k.R,
+		P:       k.P,
+		Time:    k.Time,
+		Memory:  k.Memory,
+		Threads: k.Threads,
 	}
+}
 
-	// call KDF to derive user key
-	newkey.user, err = crypto.KDF(*Params, newkey.Salt, password)
-	if err != nil {
-		return nil, err
+// calibrateKDF returns the parameters for the KDF name for the current
+// hardware.
+func calibrateKDF(name string) (crypto.Params, error) {
+	switch name {
+	case crypto.KDFScrypt:
+		return crypto.Calibrate(KDFTimeout, KDFMemory)
+	case crypto.KDFArgon2id:
+		return crypto.CalibrateArgon2id(KDFTimeout, KDFMemory)
 	}
 
-	if template == nil {
-		// generate new random master keys
-		newkey.master = crypto.NewRandomKey()
-	} else {
-		// copy master keys from old key
-		newkey.master = template
+	return crypto.Params{}, errors.Errorf("unsupported KDF %q", name)
+}
+
+// setPassword derives the user key from password with a new random salt.
+func (k *Key) setPassword(password string) error {
+	// make sure we have valid KDF parameters
+	if Params == nil {
+		p, err := calibrateKDF(KDFName)
+		if err != nil {
+			return errors.Wrap(err, "Calibrate")
+		}
+
+		Params = &p
+		debug.Log("calibrated KDF parameters are %v", p)
 	}
 
-	// encrypt master keys (as json) with user key
-	buf, err := json.Marshal(newkey.master)
+	k.KDF = Params.Name()
+	switch k.KDF {
+	case crypto.KDFScrypt:
+		k.N = Params.N
+		k.R = Params.R
+		k.P = Params.P
+	case crypto.KDFArgon2id:
+		k.Time = Params.Time
+		k.Memory = Params.Memory
+		k.Threads = Params.Threads
+	}
+
+	// generate random salt
+	var err error
+	k.Salt, err = crypto.NewSalt()
 	if err != nil {
-		return nil, errors.Wrap(err, "Marshal")
+		panic("unable to read enough random bytes for salt: " + err.Error())
 	}
 
+	// call KDF to derive user key
+	k.user, err = crypto.KDF(*Params, k.Salt, password)
+	return err
+}
+
+// encryptData encrypts buf with the user key and stores it in Data.
+func (k *Key) encryptData(buf []byte) {
 	nonce := crypto.NewRandomNonce()
-	ciphertext := make([]byte, 0, len(buf)+newkey.user.Overhead()+newkey.user.NonceSize())
+	ciphertext := make([]byte, 0, len(buf)+k.user.Overhead()+k.user.NonceSize())
 	ciphertext = append(ciphertext, nonce...)
-	ciphertext = newkey.user.Seal(ciphertext, nonce, buf, nil)
-	newkey.Data = ciphertext
+	ciphertext = k.user.Seal(ciphertext, nonce, buf, nil)
+	k.Data = ciphertext
+}
 
+// save stores the key in the repository, the name is the hash of the JSON
+// representation.
+func (k *Key) save(ctx context.Context, s *Repository) error {
 	// dump as json
-	buf, err = json.Marshal(newkey)
+	buf, err := json.Marshal(k)
 	if err != nil {
-		return nil, errors.Wrap(err, "Marshal")
+		return errors.Wrap(err, "Marshal")
 	}
 
 	// store in repository and return
@@ -251,12 +475,11 @@ func AddKey(ctx context.Context, s *Repository, password string, template *crypt
 
 	err = s.be.Save(ctx, h, restic.NewByteReader(buf))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	newkey.name = h.Name
-
-	return newkey, nil
+	k.name = h.Name
+	return nil
 }
 
 func (k *Key) String() string {
@@ -0,0 +1,109 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestWriteOnlyKey(t *testing.T) {
+	repo, cleanup := repository.TestRepositoryWithVersion(t, restic.WriteOnlyRepoVersion)
+	defer cleanup()
+
+	be := repo.Backend()
+
+	createRandomBlobs(t, repo, 10, 0.5)
+	saveIndex(t, repo)
+
+	sn, err := restic.NewSnapshot([]string{"/foo"}, nil, "host", time.Now())
+	rtest.OK(t, err)
+	snID, err := repo.SaveJSONUnpacked(context.TODO(), restic.SnapshotFile, sn)
+	rtest.OK(t, err)
+
+	_, err = repository.AddWriteOnlyKey(context.TODO(), repo.(*repository.Repository), "write-only")
+	rtest.OK(t, err)
+
+	// the write-only key can neither read the index nor the snapshots
+	wrepo := repository.New(be)
+	rtest.OK(t, wrepo.SearchKey(context.TODO(), "write-only", 0))
+	rtest.Assert(t, wrepo.WriteOnly(), "repository was not opened in write-only mode")
+
+	// the sealed key is only saved together with the first file
+	rtest.Equals(t, 2, countFiles(t, repo, restic.KeyFile))
+	rtest.Equals(t, repo.Config().ChunkerPolynomial, wrepo.Config().ChunkerPolynomial)
+	rtest.Assert(t, len(wrepo.Config().PrivateKey) == 0, "write-only key contains the private key")
+
+	rtest.OK(t, wrepo.LoadIndex(context.TODO()))
+	rtest.Equals(t, uint(0), wrepo.Index().Count(restic.DataBlob))
+
+	_, err = restic.LoadSnapshot(context.TODO(), wrepo, snID)
+	rtest.Assert(t, err != nil, "write-only key was able to load a snapshot")
+
+	buf := random(t, 5000)
+	blobID, err := wrepo.SaveBlob(context.TODO(), restic.DataBlob, buf, restic.ID{})
+	rtest.OK(t, err)
+	rtest.OK(t, wrepo.Flush(context.TODO()))
+	rtest.OK(t, wrepo.SaveIndex(context.TODO()))
+	newSnID, err := wrepo.SaveJSONUnpacked(context.TODO(), restic.SnapshotFile, sn)
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, countFiles(t, repo, restic.KeyFile))
+
+	// a regular key can read everything, also after a key rotation
+	check := func() {
+		r := repository.New(be)
+		rtest.OK(t, r.SearchKey(context.TODO(), rtest.TestPassword, 0))
+		rtest.OK(t, r.LoadIndex(context.TODO()))
+
+		plaintext := restic.NewBlobBuffer(len(buf))
+		n, err := r.LoadBlob(context.TODO(), restic.DataBlob, blobID, plaintext)
+		rtest.OK(t, err)
+		rtest.Equals(t, buf, plaintext[:n])
+
+		err = r.List(context.TODO(), restic.SnapshotFile, func(id restic.ID, size int64) error {
+			_, err := restic.LoadSnapshot(context.TODO(), r, id)
+			return err
+		})
+		rtest.OK(t, err)
+	}
+
+	check()
+	_, err = restic.LoadSnapshot(context.TODO(), repo, newSnID)
+	rtest.Assert(t, err != nil, "snapshot was decrypted without the sealed key")
+
+	rtest.OK(t, rotateKey(t, be))
+	check()
+}
+
+func TestArgon2idKey(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	oldParams := repository.Params
+	defer func() {
+		repository.Params = oldParams
+	}()
+	repository.Params = &crypto.Params{KDF: crypto.KDFArgon2id, Time: 1, Memory: 64, Threads: 1}
+
+	key, err := repository.AddKey(context.TODO(), repo.(*repository.Repository), "argon2id password", repo.Key())
+	rtest.OK(t, err)
+	rtest.Equals(t, crypto.KDFArgon2id, key.KDF)
+	rtest.Equals(t, *repository.Params, key.Params())
+
+	loaded, err := repository.LoadKey(context.TODO(), repo.(*repository.Repository), key.Name())
+	rtest.OK(t, err)
+	rtest.Equals(t, key.Params(), loaded.Params())
+
+	r := repository.New(repo.Backend())
+	rtest.OK(t, r.SearchKey(context.TODO(), "argon2id password", 0))
+	rtest.Equals(t, key.Name(), r.KeyName())
+	rtest.Equals(t, repo.Key().EncryptionKey, r.Key().EncryptionKey)
+
+	// the key created with scrypt can still be used
+	r = repository.New(repo.Backend())
+	rtest.OK(t, r.SearchKey(context.TODO(), rtest.TestPassword, 0))
+}
@@ -36,7 +36,12 @@ type packerManager struct {
 	packers []*Packer
 }
 
-const minPackSize = 4 * 1024 * 1024
+// These are the bounds for the target size of pack files.
+const (
+	DefaultPackSize = 4 * 1024 * 1024
+	MinPackSize     = 1 * 1024 * 1024
+	MaxPackSize     = 128 * 1024 * 1024
+)
 
 // newPackerManager returns an new packer manager which writes temporary files
 // to a temporary directory
@@ -103,6 +108,11 @@ func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packe
 		return err
 	}
 
+	err = r.saveSealedKey(ctx)
+	if err != nil {
+		return err
+	}
+
 	err = r.be.Save(ctx, h, rd)
 	if err != nil {
 		debug.Log("Save(%v) error: %v", h, err)
@@ -140,10 +150,11 @@ func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packe
 		debug.Log("  updating blob %v to pack %v", b.ID, id)
 		r.idx.Store(restic.PackedBlob{
 			Blob: restic.Blob{
-				Type:   b.Type,
-				ID:     b.ID,
-				Offset: b.Offset,
-				Length: uint(b.Length),
+				Type:               b.Type,
+				ID:                 b.ID,
+				Offset:             b.Offset,
+				Length:             uint(b.Length),
+				UncompressedLength: b.UncompressedLength,
 			},
 			PackID: id,
 		})
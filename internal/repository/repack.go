@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/restic/restic/internal/compress"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/pack"
@@ -77,6 +78,13 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 				return nil, err
 			}
 
+			if entry.IsCompressed() {
+				plaintext, err = compress.Decompress(nil, plaintext, int(entry.UncompressedLength))
+				if err != nil {
+					return nil, err
+				}
+			}
+
 			id := restic.Hash(plaintext)
 			if !id.Equal(entry.ID) {
 				debug.Log("read blob %v/%v from %v: wrong data returned, hash is %v",
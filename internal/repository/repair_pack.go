@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"io"
+
+	"github.com/restic/restic/internal/compress"
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/pack"
+	"github.com/restic/restic/internal/restic"
+)
+
+// SalvagePack downloads the damaged pack id and saves all blobs which are
+// still intact to new packs. The blobs are located using the pack header. When
+// the header cannot be read, the pack is scanned for the blobs the index
+// expects in it, see scanPack. Blobs which are also stored in other packs are
+// skipped.
+//
+// Returned are the blobs which were saved and the blobs which were lost,
+// i.e. which are neither intact in this pack nor stored in another pack. The
+// caller is responsible for calling Flush() and for removing the damaged pack
+// from the index and the backend afterwards.
+func SalvagePack(ctx context.Context, repo restic.Repository, id restic.ID) (salvaged, lost restic.BlobSet, err error) {
+	debug.Log("salvaging blobs from pack %v", id)
+
+	h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+	packfile, _, size, err := DownloadAndHash(ctx, repo, h)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "SalvagePack")
+	}
+
+	defer func() {
+		_ = packfile.Close()
+		_ = fs.RemoveIfExists(packfile.Name())
+	}()
+
+	// the blobs the index expects in this pack
+	indexed, err := listIndexedBlobs(ctx, repo.Index(), id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blobs, err := pack.List(repo.Key(), packfile, size)
+	if err != nil {
+		debug.Log("unable to read header of pack %v, scanning the pack: %v", id, err)
+		blobs = scanPack(repo.Key(), packfile, size, indexed)
+	}
+
+	salvaged = restic.NewBlobSet()
+	lost = restic.NewBlobSet()
+	for _, blob := range indexed {
+		lost.Insert(restic.BlobHandle{ID: blob.ID, Type: blob.Type})
+	}
+
+	for _, blob := range blobs {
+		bh := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+		if salvaged.Has(bh) {
+			continue
+		}
+
+		if storedElsewhere(repo.Index(), bh, id) {
+			debug.Log("  blob %v is also stored in another pack", bh)
+			lost.Delete(bh)
+			continue
+		}
+
+		plaintext, err := loadPackedBlob(repo.Key(), packfile, blob)
+		if err != nil {
+			debug.Log("  blob %v is damaged: %v", bh, err)
+			lost.Insert(bh)
+			continue
+		}
+
+		_, err = repo.SaveBlob(ctx, blob.Type, plaintext, blob.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		debug.Log("  salvaged blob %v", bh)
+		salvaged.Insert(bh)
+		lost.Delete(bh)
+	}
+
+	return salvaged, lost, nil
+}
+
+// scanPack locates blobs in a pack with a damaged header by trial decryption.
+// Candidate offsets are the start of the pack, the offsets recorded in the
+// index and the end of every blob found so far. At each offset, all lengths of
+// the blobs in indexed are tried. A blob is only accepted when its plaintext
+// hashes to the ID of one of the blobs in indexed, so the offsets in the index
+// need not be correct.
+func scanPack(key *crypto.Key, rd io.ReaderAt, size int64, indexed []restic.Blob) []restic.Blob {
+	type blobLength struct {
+		length, uncompressedLength uint
+	}
+
+	expected := make(map[restic.ID]restic.Blob, len(indexed))
+	lengths := make(map[blobLength]struct{})
+	for _, blob := range indexed {
+		expected[blob.ID] = blob
+		lengths[blobLength{blob.Length, blob.UncompressedLength}] = struct{}{}
+	}
+
+	offsets := []uint{0}
+	for _, blob := range indexed {
+		offsets = append(offsets, blob.Offset)
+	}
+
+	var found []restic.Blob
+	visited := make(map[uint]struct{})
+	for len(offsets) > 0 && len(expected) > 0 {
+		offset := offsets[0]
+		offsets = offsets[1:]
+
+		if _, ok := visited[offset]; ok {
+			continue
+		}
+		visited[offset] = struct{}{}
+
+		for l := range lengths {
+			if int64(offset+l.length) > size {
+				continue
+			}
+
+			candidate := restic.Blob{Offset: offset, Length: l.length, UncompressedLength: l.uncompressedLength}
+			plaintext, err := decryptPackedBlob(key, rd, candidate)
+			if err != nil {
+				continue
+			}
+
+			blob, ok := expected[restic.Hash(plaintext)]
+			if !ok || blob.Length != l.length {
+				continue
+			}
+
+			debug.Log("  found blob %v at offset %v", blob.ID.Str(), offset)
+			blob.Offset = offset
+			found = append(found, blob)
+			delete(expected, blob.ID)
+
+			offsets = append(offsets, offset+l.length)
+			break
+		}
+	}
+
+	return found
+}
+
+// listIndexedBlobs returns the blobs which are stored in the pack id
+// according to the index.
+func listIndexedBlobs(ctx context.Context, idx restic.Index, id restic.ID) ([]restic.Blob, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var blobs []restic.Blob
+	for pb := range idx.Each(ctx) {
+		if pb.PackID.Equal(id) {
+			blobs = append(blobs, pb.Blob)
+		}
+	}
+
+	return blobs, ctx.Err()
+}
+
+// storedElsewhere returns true if the index lists the blob h in a pack other
+// than packID.
+func storedElsewhere(idx restic.Index, h restic.BlobHandle, packID restic.ID) bool {
+	blobs, found := idx.Lookup(h.ID, h.Type)
+	if !found {
+		return false
+	}
+
+	for _, pb := range blobs {
+		if !pb.PackID.Equal(packID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadPackedBlob reads the blob from rd, decrypts it with key, decompresses it
+// and checks that the plaintext matches the blob ID.
+func loadPackedBlob(key *crypto.Key, rd io.ReaderAt, blob restic.Blob) ([]byte, error) {
+	plaintext, err := decryptPackedBlob(key, rd, blob)
+	if err != nil {
+		return nil, err
+	}
+
+	if !restic.Hash(plaintext).Equal(blob.ID) {
+		return nil, errors.Errorf("blob %v: hash does not match", blob.ID.Str())
+	}
+
+	return plaintext, nil
+}
+
+// decryptPackedBlob reads the blob from rd, decrypts it with key and
+// decompresses it. The blob ID is not checked.
+func decryptPackedBlob(key *crypto.Key, rd io.ReaderAt, blob restic.Blob) ([]byte, error) {
+	if blob.Length < uint(key.NonceSize()) {
+		return nil, errors.Errorf("invalid blob length %v", blob.Length)
+	}
+
+	buf := make([]byte, blob.Length)
+	n, err := rd.ReadAt(buf, int64(blob.Offset))
+	if err != nil && !(err == io.EOF && n == len(buf)) {
+		return nil, errors.Wrap(err, "ReadAt")
+	}
+
+	nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+	plaintext, err := key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if blob.IsCompressed() {
+		plaintext, err = compress.Decompress(nil, plaintext, int(blob.UncompressedLength))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return plaintext, nil
+}
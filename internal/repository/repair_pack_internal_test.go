@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestScanPack(t *testing.T) {
+	key := crypto.NewRandomKey()
+	rnd := rand.New(rand.NewSource(23))
+
+	// build the data section of a pack, the header is missing
+	var (
+		pack    []byte
+		indexed []restic.Blob
+	)
+	for _, l := range []int{23, 31650, 25860, 10928, 127} {
+		buf := make([]byte, l)
+		_, err := io.ReadFull(rnd, buf)
+		rtest.OK(t, err)
+
+		nonce := crypto.NewRandomNonce()
+		ciphertext := append([]byte{}, nonce...)
+		ciphertext = key.Seal(ciphertext, nonce, buf, nil)
+
+		indexed = append(indexed, restic.Blob{
+			Type:   restic.DataBlob,
+			ID:     restic.Hash(buf),
+			Offset: uint(len(pack)),
+			Length: uint(len(ciphertext)),
+		})
+		pack = append(pack, ciphertext...)
+	}
+
+	// damage the third blob
+	pack[indexed[2].Offset+10] ^= 0xff
+
+	// the offsets in the index are not used for blobs found by the scan
+	wrongOffsets := make([]restic.Blob, 0, len(indexed))
+	for _, blob := range indexed {
+		blob.Offset = 0
+		wrongOffsets = append(wrongOffsets, blob)
+	}
+
+	found := scanPack(key, bytes.NewReader(pack), int64(len(pack)), wrongOffsets)
+	rtest.Equals(t, indexed[:2], found)
+
+	// with the offsets from the index, the blobs after the damaged one are found
+	found = scanPack(key, bytes.NewReader(pack), int64(len(pack)), indexed)
+	rtest.Equals(t, []restic.Blob{indexed[0], indexed[1], indexed[3], indexed[4]}, found)
+}
@@ -0,0 +1,107 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// flipByte flips all bits of the byte at offset in the pack id. Negative
+// offsets are counted from the end of the file.
+func flipByte(t *testing.T, repo restic.Repository, id restic.ID, offset int) {
+	h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+	buf, err := backend.LoadAll(context.TODO(), repo.Backend(), h)
+	rtest.OK(t, err)
+
+	if offset < 0 {
+		offset += len(buf)
+	}
+	buf[offset] ^= 0xff
+
+	rtest.OK(t, repo.Backend().Remove(context.TODO(), h))
+	rtest.OK(t, repo.Backend().Save(context.TODO(), h, restic.NewByteReader(buf)))
+}
+
+func TestSalvagePack(t *testing.T) {
+	var tests = []struct {
+		name string
+		// damage returns the blob which is damaged, or nil
+		damage func(t *testing.T, repo restic.Repository, packID restic.ID, blobs []restic.PackedBlob) *restic.BlobHandle
+	}{
+		{
+			name: "undamaged",
+			damage: func(t *testing.T, repo restic.Repository, packID restic.ID, blobs []restic.PackedBlob) *restic.BlobHandle {
+				return nil
+			},
+		},
+		{
+			name: "blob",
+			damage: func(t *testing.T, repo restic.Repository, packID restic.ID, blobs []restic.PackedBlob) *restic.BlobHandle {
+				flipByte(t, repo, packID, int(blobs[2].Offset+blobs[2].Length/2))
+				return &restic.BlobHandle{ID: blobs[2].ID, Type: blobs[2].Type}
+			},
+		},
+		{
+			name: "header",
+			damage: func(t *testing.T, repo restic.Repository, packID restic.ID, blobs []restic.PackedBlob) *restic.BlobHandle {
+				// the last four bytes contain the header length
+				flipByte(t, repo, packID, -10)
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo, cleanup := repository.TestRepository(t)
+			defer cleanup()
+
+			for i := 0; i < 5; i++ {
+				buf := random(t, 2000)
+				_, err := repo.SaveBlob(context.TODO(), restic.DataBlob, buf, restic.Hash(buf))
+				rtest.OK(t, err)
+			}
+			rtest.OK(t, repo.Flush(context.TODO()))
+			saveIndex(t, repo)
+
+			packs := listPacks(t, repo)
+			rtest.Equals(t, 1, len(packs))
+			var packID restic.ID
+			for id := range packs {
+				packID = id
+			}
+
+			blobs := repo.Index().(*repository.MasterIndex).ListPack(packID)
+			rtest.Equals(t, 5, len(blobs))
+
+			damaged := test.damage(t, repo, packID, blobs)
+
+			salvaged, lost, err := repository.SalvagePack(context.TODO(), repo, packID)
+			rtest.OK(t, err)
+
+			wantLost := restic.NewBlobSet()
+			if damaged != nil {
+				wantLost.Insert(*damaged)
+			}
+			rtest.Equals(t, wantLost, lost)
+			rtest.Equals(t, 5-len(wantLost), len(salvaged))
+
+			// remove the damaged pack, all salvaged blobs must be loadable
+			rtest.OK(t, repo.Flush(context.TODO()))
+			rtest.OK(t, repo.Backend().Remove(context.TODO(), restic.Handle{Type: restic.DataFile, Name: packID.String()}))
+			rebuildIndex(t, repo)
+			reloadIndex(t, repo)
+
+			for h := range salvaged {
+				buf := restic.NewBlobBuffer(2000)
+				n, err := repo.LoadBlob(context.TODO(), h.Type, h.ID, buf)
+				rtest.OK(t, err)
+				rtest.Equals(t, h.ID, restic.Hash(buf[:n]))
+			}
+		})
+	}
+}
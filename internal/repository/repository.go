@@ -7,9 +7,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 
 	"github.com/restic/restic/internal/cache"
+	"github.com/restic/restic/internal/compress"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/hashing"
@@ -19,6 +23,8 @@ import (
 	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/pack"
+
+	"github.com/restic/chunker"
 )
 
 // Repository is used to access a repository in a backend.
@@ -30,22 +36,50 @@ type Repository struct {
 	idx     *MasterIndex
 	restic.Cache
 
-	treePM *packerManager
-	dataPM *packerManager
+	treePM   *packerManager
+	dataPM   *packerManager
+	packSize uint
+
+	// writeOnly is set when the repository was opened with a write-only key
+	writeOnly bool
+
+	// sealPublicKey is the public key for which the master key of a
+	// write-only client is sealed, it is reset when the sealed key has been
+	// saved
+	sealPublicKey []byte
+	sealMu        sync.Mutex
 }
 
 // New returns a new repository with backend be.
 func New(be restic.Backend) *Repository {
 	repo := &Repository{
-		be:     be,
-		idx:    NewMasterIndex(),
-		dataPM: newPackerManager(be, nil),
-		treePM: newPackerManager(be, nil),
+		be:       be,
+		idx:      NewMasterIndex(),
+		dataPM:   newPackerManager(be, nil),
+		treePM:   newPackerManager(be, nil),
+		packSize: DefaultPackSize,
 	}
 
 	return repo
 }
 
+// SetPackSize sets the target size of new pack files, which must be between
+// MinPackSize and MaxPackSize.
+func (r *Repository) SetPackSize(size uint) error {
+	if size < MinPackSize || size > MaxPackSize {
+		return errors.Errorf("pack size %d is not between %d and %d bytes", size, MinPackSize, MaxPackSize)
+	}
+
+	debug.Log("using pack size %d", size)
+	r.packSize = size
+	return nil
+}
+
+// PackSize returns the target size of new pack files.
+func (r *Repository) PackSize() uint {
+	return r.packSize
+}
+
 // Config returns the repository configuration.
 func (r *Repository) Config() restic.Config {
 	return r.cfg
@@ -85,6 +119,10 @@ func (r *Repository) LoadAndDecrypt(ctx context.Context, t restic.FileType, id r
 
 	nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
 	plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if err != nil && r.writeOnly {
+		// only the data saved by this client can be decrypted
+		return nil, ErrWriteOnly
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -167,6 +205,18 @@ func (r *Repository) loadBlob(ctx context.Context, id restic.ID, t restic.BlobTy
 			continue
 		}
 
+		if blob.IsCompressed() {
+			if uint(cap(plaintextBuf)) < blob.UncompressedLength {
+				return 0, errors.Errorf("buffer is too small: %v < %v", cap(plaintextBuf), blob.UncompressedLength)
+			}
+
+			plaintext, err = compress.Decompress(nil, plaintext, int(blob.UncompressedLength))
+			if err != nil {
+				lastError = errors.Errorf("decompressing blob %v failed: %v", id, err)
+				continue
+			}
+		}
+
 		// check hash
 		if !restic.Hash(plaintext).Equal(id) {
 			lastError = errors.Errorf("blob %v returned invalid hash", id)
@@ -174,6 +224,7 @@ func (r *Repository) loadBlob(ctx context.Context, id restic.ID, t restic.BlobTy
 		}
 
 		// move decrypted data to the start of the provided buffer
+		plaintextBuf = plaintextBuf[:len(plaintext)]
 		copy(plaintextBuf[0:], plaintext)
 		return len(plaintext), nil
 	}
@@ -212,6 +263,21 @@ func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data
 
 	debug.Log("save id %v (%v, %d bytes)", id, t, len(data))
 
+	// compress the data if the repository supports it, but only keep the
+	// compressed version if it is actually smaller
+	uncompressedLength := 0
+	if r.cfg.SupportsCompression() {
+		compressed := getBuf()
+		defer freeBuf(compressed)
+
+		compressed = compress.Compress(compressed[:0], data)
+		if len(compressed) < len(data) {
+			debug.Log("compressed %v from %d to %d bytes", id, len(data), len(compressed))
+			uncompressedLength = len(data)
+			data = compressed
+		}
+	}
+
 	// get buf from the pool
 	ciphertext := getBuf()
 	defer freeBuf(ciphertext)
@@ -241,13 +307,13 @@ func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data
 	}
 
 	// save ciphertext
-	_, err = packer.Add(t, *id, ciphertext)
+	_, err = packer.Add(t, *id, ciphertext, uncompressedLength)
 	if err != nil {
 		return restic.ID{}, err
 	}
 
 	// if the pack is not full enough, put back to the list
-	if packer.Size() < minPackSize {
+	if packer.Size() < r.packSize && !packer.HeaderFull() {
 		debug.Log("pack is not full enough (%d bytes)", packer.Size())
 		pm.insertPacker(packer)
 		return *id, nil
@@ -282,6 +348,11 @@ func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, p []by
 	id = restic.Hash(ciphertext)
 	h := restic.Handle{Type: t, Name: id.String()}
 
+	err = r.saveSealedKey(ctx)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
 	err = r.be.Save(ctx, h, restic.NewByteReader(ciphertext))
 	if err != nil {
 		debug.Log("error saving blob %v: %v", h, err)
@@ -380,6 +451,13 @@ const loadIndexParallelism = 4
 func (r *Repository) LoadIndex(ctx context.Context) error {
 	debug.Log("Loading index")
 
+	if r.writeOnly {
+		// the index cannot be decrypted, so blobs are only deduplicated
+		// against the blobs saved by this client
+		debug.Log("write-only repository, not loading the index")
+		return nil
+	}
+
 	errCh := make(chan error, 1)
 	indexes := make(chan *Index)
 
@@ -482,17 +560,269 @@ func (r *Repository) SearchKey(ctx context.Context, password string, maxKeys int
 		return err
 	}
 
+	if key.WriteOnly {
+		return r.openWriteOnly(ctx, key)
+	}
+
+	r.useKey(key)
+	r.cfg, err = restic.LoadConfig(ctx, r)
+	if err != nil && r.be.IsNotExist(err) {
+		// restic may have been interrupted while replacing the config
+		_, err = recoverConfig(ctx, r.be, key.Name())
+		if err == nil {
+			r.cfg, err = restic.LoadConfig(ctx, r)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	keys, err := r.sealedKeys(ctx)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		r.key.AddDecryptionKeys(k)
+	}
+
+	return nil
+}
+
+// openWriteOnly uses a new random master key for all data saved with the
+// write-only key. Before the first file is saved, the master key is sealed for
+// the public key of the repository and saved in a new key file, so that it can
+// be used by the owners of regular keys to decrypt the data.
+func (r *Repository) openWriteOnly(ctx context.Context, key *Key) error {
+	key.master = crypto.NewRandomKey()
+	r.useKey(key)
+	r.cfg = *key.config
+	r.writeOnly = true
+	r.sealPublicKey = key.config.PublicKey
+
+	return nil
+}
+
+// saveSealedKey saves the sealed master key of a write-only client if this has
+// not been done yet. Opening the repository thus only leaves a key file behind
+// when data is saved.
+func (r *Repository) saveSealedKey(ctx context.Context) error {
+	r.sealMu.Lock()
+	defer r.sealMu.Unlock()
+
+	if r.sealPublicKey == nil {
+		return nil
+	}
+
+	sealed, err := addSealedKey(ctx, r, r.key, r.sealPublicKey)
+	if err != nil {
+		return err
+	}
+	debug.Log("saved sealed key %v for write-only key %v", sealed.Name(), r.keyName)
+
+	r.sealPublicKey = nil
+	return nil
+}
+
+// sealedKeys returns the master keys used by write-only clients, indexed by
+// the name of the key file. They are decrypted with the private key from the
+// config.
+func (r *Repository) sealedKeys(ctx context.Context) (map[string]*crypto.Key, error) {
+	if len(r.cfg.PrivateKey) == 0 {
+		return nil, nil
+	}
+
+	keys := make(map[string]*crypto.Key)
+	err := r.be.List(ctx, restic.KeyFile, func(fi restic.FileInfo) error {
+		if _, err := restic.ParseID(fi.Name); err != nil {
+			return nil
+		}
+
+		k, err := LoadKey(ctx, r, fi.Name)
+		if err != nil {
+			return err
+		}
+
+		if k.Sealed == nil {
+			return nil
+		}
+
+		master, err := crypto.OpenSealedKey(k.Sealed, r.cfg.PrivateKey)
+		if err != nil {
+			return errors.Wrapf(err, "open sealed key %v", fi.Name)
+		}
+
+		keys[fi.Name] = master
+		return nil
+	})
+
+	return keys, err
+}
+
+// createKeyPair generates the key pair for write-only keys and saves it in the
+// config. The private key is thereby encrypted with the master key, so it is
+// available to everybody who can read the data in the repository anyway, but
+// not to write-only clients.
+func (r *Repository) createKeyPair(ctx context.Context) error {
+	if r.writeOnly {
+		return ErrWriteOnly
+	}
+
+	cfg := r.cfg
+	var err error
+	cfg.PublicKey, cfg.PrivateKey, err = crypto.NewKeyPair()
+	if err != nil {
+		return err
+	}
+
+	return r.ReplaceConfig(ctx, cfg)
+}
+
+// ReplaceConfig saves cfg as the new config of the repository, see
+// replaceConfig for how the old config is kept until the new one is saved.
+func (r *Repository) ReplaceConfig(ctx context.Context, cfg restic.Config) error {
+	if r.writeOnly {
+		return ErrWriteOnly
+	}
+
+	plaintext, err := json.Marshal(cfg)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+
+	err = r.replaceConfig(ctx, plaintext)
+	if err != nil {
+		return err
+	}
+
+	r.cfg = cfg
+	return nil
+}
+
+// configBackupDir returns the local directory in which replaceConfig keeps a
+// backup of the config. It depends on the name of the key used, so that the
+// backup is found again when the repository is opened with the same key.
+func configBackupDir(keyName string) string {
+	return filepath.Join(os.TempDir(), "restic-config-backup-"+keyName)
+}
+
+// replaceConfig encrypts plaintext and replaces the config file with it. As
+// the config cannot be overwritten, the old file has to be removed first.
+// Beforehand, the old and the new config are written to the local directory
+// returned by configBackupDir as "config.old" and "config.new", so that
+// recoverConfig can restore the config when restic is interrupted in between.
+// When saving the new config fails, the old config is restored. The backup is
+// removed unless the repository is left without a config.
+func (r *Repository) replaceConfig(ctx context.Context, plaintext []byte) error {
+	h := restic.Handle{Type: restic.ConfigFile}
+	oldConfig, err := backend.LoadAll(ctx, r.be, h)
+	if err != nil {
+		return errors.Wrap(err, "load old config")
+	}
+
+	nonce := crypto.NewRandomNonce()
+	newConfig := append([]byte{}, nonce...)
+	newConfig = r.key.Seal(newConfig, nonce, plaintext, nil)
+
+	backupDir := configBackupDir(r.keyName)
+	err = fs.MkdirAll(backupDir, 0700)
+	if err != nil {
+		return errors.Wrap(err, "MkdirAll")
+	}
+
+	oldFilename := filepath.Join(backupDir, "config.old")
+	err = ioutil.WriteFile(oldFilename, oldConfig, 0600)
+	if err != nil {
+		return errors.Wrap(err, "save backup of old config")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(backupDir, "config.new"), newConfig, 0600)
+	if err != nil {
+		return errors.Wrap(err, "save backup of new config")
+	}
+	debug.Log("saved backup of the config to %v", backupDir)
+
+	err = r.be.Remove(ctx, h)
+	if err != nil {
+		_ = fs.RemoveAll(backupDir)
+		return errors.Wrap(err, "remove old config")
+	}
+
+	err = r.be.Save(ctx, h, restic.NewByteReader(newConfig))
+	if err != nil {
+		debug.Log("saving new config failed, restoring old config: %v", err)
+
+		// remove a partially written file
+		_ = r.be.Remove(ctx, h)
+		rerr := r.be.Save(ctx, h, restic.NewByteReader(oldConfig))
+		if rerr != nil {
+			return errors.Errorf("saving new config failed: %v, restoring old config failed: %v\n"+
+				"copy %v to the file \"config\" in the repository manually", err, rerr, oldFilename)
+		}
+
+		_ = fs.RemoveAll(backupDir)
+		return errors.Wrap(err, "save new config")
+	}
+
+	return fs.RemoveAll(backupDir)
+}
+
+// recoverConfig saves the new config from the backup which replaceConfig
+// wrote for the key keyName. It is used when restic was interrupted after the
+// old config had been removed. Returned is the encrypted config.
+func recoverConfig(ctx context.Context, be restic.Backend, keyName string) ([]byte, error) {
+	filename := filepath.Join(configBackupDir(keyName), "config.new")
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "config not found, unable to load backup")
+	}
+
+	debug.Log("restoring config from %v", filename)
+	err = be.Save(ctx, restic.Handle{Type: restic.ConfigFile}, restic.NewByteReader(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	_ = fs.RemoveAll(configBackupDir(keyName))
+	return buf, nil
+}
+
+// WriteOnly returns true if the repository was opened with a write-only key.
+// In this case, only the data saved with this repository can be loaded.
+func (r *Repository) WriteOnly() bool {
+	return r.writeOnly
+}
+
+// useKey sets the master key of key as the key used for all encryption and
+// decryption operations.
+func (r *Repository) useKey(key *Key) {
 	r.key = key.master
 	r.dataPM.key = key.master
 	r.treePM.key = key.master
 	r.keyName = key.Name()
-	r.cfg, err = restic.LoadConfig(ctx, r)
-	return err
+}
+
+// InitOptions collects the settings for a new repository.
+type InitOptions struct {
+	// Version is the repository version, restic.RepoVersion is used if it
+	// is zero.
+	Version uint
+
+	// ChunkerPolynomial is used for the chunker. If it is nil, a new random
+	// polynomial is selected.
+	ChunkerPolynomial *chunker.Pol
+
+	// ChunkSizes are the chunk sizes for the chunker. If it is the zero
+	// value, the default chunk sizes are used. Other chunk sizes require at
+	// least restic.ChunkSizesRepoVersion.
+	ChunkSizes restic.ChunkSizes
+
+	// ParityShards selects the parity data written for new pack files.
+	ParityShards uint
 }
 
 // Init creates a new master key with the supplied password, initializes and
-// saves the repository config.
-func (r *Repository) Init(ctx context.Context, password string) error {
+// saves the repository config using the given options.
+func (r *Repository) Init(ctx context.Context, password string, opts InitOptions) error {
 	has, err := r.be.Test(ctx, restic.Handle{Type: restic.ConfigFile})
 	if err != nil {
 		return err
@@ -501,11 +831,33 @@ func (r *Repository) Init(ctx context.Context, password string) error {
 		return errors.New("repository master key and config already initialized")
 	}
 
-	cfg, err := restic.CreateConfig()
+	version := opts.Version
+	if version == 0 {
+		version = restic.RepoVersion
+	}
+
+	cfg, err := restic.CreateConfig(version)
 	if err != nil {
 		return err
 	}
 
+	if opts.ChunkerPolynomial != nil {
+		cfg.ChunkerPolynomial = *opts.ChunkerPolynomial
+	}
+
+	if opts.ChunkSizes != (restic.ChunkSizes{}) {
+		if err := opts.ChunkSizes.Check(); err != nil {
+			return err
+		}
+
+		if opts.ChunkSizes != restic.DefaultChunkSizes && version < restic.ChunkSizesRepoVersion {
+			return errors.Errorf("custom chunk sizes require repository version %v or later", restic.ChunkSizesRepoVersion)
+		}
+		cfg.SetChunkSizes(opts.ChunkSizes)
+	}
+
+	cfg.ParityShards = opts.ParityShards
+
 	return r.init(ctx, password, cfg)
 }
 
@@ -517,10 +869,7 @@ func (r *Repository) init(ctx context.Context, password string, cfg restic.Confi
 		return err
 	}
 
-	r.key = key.master
-	r.dataPM.key = key.master
-	r.treePM.key = key.master
-	r.keyName = key.Name()
+	r.useKey(key)
 	r.cfg = cfg
 	_, err = r.SaveJSONUnpacked(ctx, restic.ConfigFile, cfg)
 	return err
@@ -56,6 +56,90 @@ func TestSave(t *testing.T) {
 	}
 }
 
+func testSaveCompressible(t *testing.T, repo restic.Repository, wantCompressed bool) {
+	data := bytes.Repeat([]byte("compressible data "), 10000)
+	id, err := repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(context.Background()))
+
+	blobs, found := repo.Index().Lookup(id, restic.DataBlob)
+	rtest.Assert(t, found, "blob %v not found in index", id.Str())
+	rtest.Equals(t, wantCompressed, blobs[0].IsCompressed())
+	rtest.Equals(t, uint(len(data)), blobs[0].DataLength())
+
+	size, found := repo.LookupBlobSize(id, restic.DataBlob)
+	rtest.Assert(t, found, "blob %v not found in index", id.Str())
+	rtest.Equals(t, uint(len(data)), size)
+
+	buf := restic.NewBlobBuffer(len(data))
+	n, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, buf)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(buf[:n], data), "data does not match")
+}
+
+func TestSaveCompressed(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	testSaveCompressible(t, repo, true)
+}
+
+func TestSaveUncompressedV1(t *testing.T) {
+	repository.TestUseLowSecurityKDFParameters(t)
+	be, cleanup := repository.TestBackend(t)
+	defer cleanup()
+
+	repo := repository.New(be)
+	rtest.OK(t, repo.Init(context.TODO(), rtest.TestPassword, repository.InitOptions{Version: 1}))
+
+	testSaveCompressible(t, repo, false)
+}
+
+func testPackSizes(t *testing.T, packSize uint) []int64 {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	repo := r.(*repository.Repository)
+	rtest.OK(t, repo.SetPackSize(packSize))
+	rtest.Equals(t, packSize, repo.PackSize())
+
+	// save 8 MiB of random data
+	for i := 0; i < 32; i++ {
+		data := make([]byte, 256*1024)
+		_, err := io.ReadFull(rnd, data)
+		rtest.OK(t, err)
+
+		_, err = repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{})
+		rtest.OK(t, err)
+	}
+	rtest.OK(t, repo.Flush(context.Background()))
+
+	var sizes []int64
+	rtest.OK(t, repo.List(context.TODO(), restic.DataFile, func(id restic.ID, size int64) error {
+		sizes = append(sizes, size)
+		return nil
+	}))
+	return sizes
+}
+
+func TestPackSize(t *testing.T) {
+	sizes := testPackSizes(t, repository.MinPackSize)
+	rtest.Assert(t, len(sizes) >= 7, "expected at least 7 packs, got %v", len(sizes))
+
+	sizes = testPackSizes(t, 16*1024*1024)
+	rtest.Equals(t, 1, len(sizes))
+}
+
+func TestPackSizeInvalid(t *testing.T) {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	repo := r.(*repository.Repository)
+	rtest.Assert(t, repo.SetPackSize(repository.MinPackSize-1) != nil, "expected error for too small pack size")
+	rtest.Assert(t, repo.SetPackSize(repository.MaxPackSize+1) != nil, "expected error for too large pack size")
+	rtest.Equals(t, uint(repository.DefaultPackSize), repo.PackSize())
+}
+
 func TestSaveFrom(t *testing.T) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
@@ -0,0 +1,550 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/pack"
+	"github.com/restic/restic/internal/restic"
+)
+
+// ErrKeyRotationInProgress is returned by SearchKey when the repository
+// contains the key of an unfinished master key rotation.
+var ErrKeyRotationInProgress = errors.Fatal("an unfinished master key rotation was found, run `restic key rotate` to complete it")
+
+// rotatePacksBatch is the number of packs which are re-encrypted before the
+// new packs are flushed and the old packs are removed.
+const rotatePacksBatch = 100
+
+// KeyRotation replaces the master key of a repository: all files are
+// re-encrypted with a new master key, afterwards all keys for the old master
+// key are removed. Data saved by write-only clients is re-encrypted as well,
+// write-only keys stay valid.
+//
+// While the rotation is in progress, the new master key is stored in a key
+// file marked as pending, which prevents other commands from using the
+// repository. When the rotation is interrupted, running it again with the
+// same password resumes it using the master key from the pending key. Files
+// which are already encrypted with the new master key are skipped.
+type KeyRotation struct {
+	repo     *Repository
+	password string
+
+	// oldKey is a key for the master key which is replaced, it is nil if no
+	// key for it is left
+	oldKey *Key
+
+	// newKey is the pending key for the new master key, it is nil until the
+	// rotation has been started
+	newKey *Key
+
+	// finalKey is the regular key for the new master key, it is only set
+	// when an interrupted rotation has already saved it
+	finalKey *Key
+
+	// sealed contains the master keys of write-only clients, indexed by the
+	// name of the sealed key
+	sealed map[string]*crypto.Key
+
+	// rotatedSealed contains the names of the sealed keys for which data was
+	// re-encrypted, only these sealed keys are removed
+	rotatedSealed map[string]struct{}
+}
+
+// NewKeyRotation searches the keys of the repository r which can be opened
+// with password. The repository must not have been opened yet, afterwards
+// it uses the master key which decrypts the config.
+func NewKeyRotation(ctx context.Context, r *Repository, password string) (*KeyRotation, error) {
+	kr := &KeyRotation{repo: r, password: password, rotatedSealed: make(map[string]struct{})}
+
+	var keys []*Key
+	err := r.be.List(ctx, restic.KeyFile, func(fi restic.FileInfo) error {
+		if _, err := restic.ParseID(fi.Name); err != nil {
+			debug.Log("rejecting key with invalid name: %v", fi.Name)
+			return nil
+		}
+
+		key, err := LoadKey(ctx, r, fi.Name)
+		if err != nil {
+			return err
+		}
+
+		if key.Sealed != nil || key.WriteOnly {
+			return nil
+		}
+
+		err = key.open(password)
+		if errors.Cause(err) == crypto.ErrUnauthenticated {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if key.Pending && kr.newKey == nil {
+			kr.newKey = key
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		switch {
+		case key.Pending:
+		case kr.newKey != nil && sameMasterKey(key.master, kr.newKey.master):
+			kr.finalKey = key
+		case kr.oldKey == nil:
+			kr.oldKey = key
+		}
+	}
+
+	if kr.oldKey == nil && kr.newKey == nil {
+		return nil, ErrNoKeyFound
+	}
+
+	// the config is re-encrypted last, so it tells which key must be used
+	// to access the repository until the rotation is complete
+	buf, err := backend.LoadAll(ctx, r.be, restic.Handle{Type: restic.ConfigFile})
+	if err != nil && r.be.IsNotExist(err) && kr.newKey != nil {
+		// the rotation was interrupted while the config was replaced
+		buf, err = recoverConfig(ctx, r.be, kr.newKey.Name())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, rotated, err := kr.decrypt(buf)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt config")
+	}
+
+	if rotated {
+		r.useKey(kr.newKey)
+	} else {
+		r.useKey(kr.oldKey)
+	}
+
+	err = json.Unmarshal(plaintext, &r.cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+
+	// data saved by write-only clients is encrypted with the sealed keys
+	if kr.oldKey != nil {
+		kr.sealed, err = r.sealedKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return kr, nil
+}
+
+// Resumed returns true if an interrupted rotation was found.
+func (kr *KeyRotation) Resumed() bool {
+	return kr.newKey != nil
+}
+
+// Run re-encrypts all packs, index files, snapshots and the config with the
+// new master key, and removes the keys for the old master key afterwards.
+// Locks are not re-encrypted. The progress p is updated for each pack.
+func (kr *KeyRotation) Run(ctx context.Context, p *restic.Progress) (*Key, error) {
+	if kr.newKey == nil {
+		key, err := addKey(ctx, kr.repo, kr.password, nil, true)
+		if err != nil {
+			return nil, err
+		}
+		debug.Log("saved pending key %v", key.Name())
+		kr.newKey = key
+	}
+
+	kr.repo.useKey(kr.newKey)
+
+	err := kr.rotatePacks(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	err = kr.rotateSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = kr.rotateConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return kr.replaceKeys(ctx)
+}
+
+// decrypt opens buf, which consists of the nonce followed by the ciphertext,
+// with the new or the old master key. It returns true if the new master key
+// was used.
+func (kr *KeyRotation) decrypt(buf []byte) (plaintext []byte, rotated bool, err error) {
+	if kr.newKey != nil {
+		plaintext, err = decryptUnpacked(kr.newKey.master, buf)
+		if err == nil {
+			return plaintext, true, nil
+		}
+	}
+
+	if kr.oldKey == nil {
+		return nil, false, errors.New("unable to decrypt data with the new master key and no old key is left")
+	}
+
+	name, err := kr.openOld(func(key *crypto.Key) (err error) {
+		plaintext, err = decryptUnpacked(key, buf)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	kr.markRotated(name)
+	return plaintext, false, nil
+}
+
+// openOld calls open with the old master key and, if that fails, with the
+// master keys of write-only clients until it succeeds. It returns the name of
+// the sealed key which was used, or the empty string for the old master key.
+func (kr *KeyRotation) openOld(open func(key *crypto.Key) error) (string, error) {
+	err := open(kr.oldKey.master)
+	if err == nil {
+		return "", nil
+	}
+
+	for name, key := range kr.sealed {
+		if open(key) == nil {
+			return name, nil
+		}
+	}
+
+	return "", err
+}
+
+// markRotated records that data encrypted with the sealed key name has been
+// re-encrypted.
+func (kr *KeyRotation) markRotated(name string) {
+	if name != "" {
+		kr.rotatedSealed[name] = struct{}{}
+	}
+}
+
+func decryptUnpacked(key *crypto.Key, buf []byte) ([]byte, error) {
+	if len(buf) < key.NonceSize() {
+		return nil, errors.Errorf("invalid data length %v", len(buf))
+	}
+
+	nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+	return key.Open(nil, nonce, ciphertext, nil)
+}
+
+// sameMasterKey returns true if a and b are the same master key.
+func sameMasterKey(a, b *crypto.Key) bool {
+	return a.EncryptionKey == b.EncryptionKey && a.MACKey.K == b.MACKey.K
+}
+
+// rotatePacks saves the blobs of all packs encrypted with the old master key
+// to new packs and removes the old packs. Afterwards, a new index for all
+// packs replaces the old index files.
+func (kr *KeyRotation) rotatePacks(ctx context.Context, p *restic.Progress) error {
+	r := kr.repo
+
+	// collect the packs and index files first, so that the new files are
+	// not processed again
+	packs := restic.NewIDSet()
+	err := r.List(ctx, restic.DataFile, func(id restic.ID, size int64) error {
+		packs.Insert(id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var oldIndexes restic.IDs
+	err = r.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+		oldIndexes = append(oldIndexes, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	p.Start()
+	defer p.Done()
+
+	obsoletePacks := restic.NewIDSet()
+	for id := range packs {
+		rotated, err := kr.rotatePack(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if rotated {
+			obsoletePacks.Insert(id)
+		}
+
+		if len(obsoletePacks) >= rotatePacksBatch {
+			err = kr.removePacks(ctx, obsoletePacks)
+			if err != nil {
+				return err
+			}
+			obsoletePacks = restic.NewIDSet()
+		}
+
+		p.Report(restic.Stat{Blobs: 1})
+	}
+
+	// this also saves the index for the remaining packs
+	err = kr.removePacks(ctx, obsoletePacks)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range oldIndexes {
+		debug.Log("remove old index %v", id)
+		err = r.be.Remove(ctx, restic.Handle{Type: restic.IndexFile, Name: id.String()})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rotatePack re-encrypts the blobs in the pack id, it returns true if the
+// pack was encrypted with the old master key and is now obsolete. The blobs
+// of packs which are already encrypted with the new master key are added to
+// the index.
+func (kr *KeyRotation) rotatePack(ctx context.Context, id restic.ID) (bool, error) {
+	r := kr.repo
+
+	h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+	packfile, hash, size, err := DownloadAndHash(ctx, r, h)
+	if err != nil {
+		return false, errors.Wrap(err, "rotatePack")
+	}
+
+	defer func() {
+		_ = packfile.Close()
+		_ = fs.RemoveIfExists(packfile.Name())
+	}()
+
+	if !id.Equal(hash) {
+		return false, errors.Errorf("pack %v is damaged: hash does not match id", id.Str())
+	}
+
+	blobs, err := pack.List(kr.newKey.master, packfile, size)
+	if err == nil {
+		debug.Log("pack %v is already encrypted with the new master key", id)
+		for _, blob := range blobs {
+			r.idx.Store(restic.PackedBlob{Blob: blob, PackID: id})
+		}
+		return false, nil
+	}
+
+	if kr.oldKey == nil {
+		return false, errors.Errorf("unable to read pack %v: %v", id.Str(), err)
+	}
+
+	var key *crypto.Key
+	name, err := kr.openOld(func(k *crypto.Key) (err error) {
+		blobs, err = pack.List(k, packfile, size)
+		key = k
+		return err
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "pack %v", id.Str())
+	}
+
+	debug.Log("re-encrypting %d blobs from pack %v", len(blobs), id)
+	for _, blob := range blobs {
+		plaintext, err := loadPackedBlob(key, packfile, blob)
+		if err != nil {
+			return false, errors.Wrapf(err, "pack %v", id.Str())
+		}
+
+		_, err = r.SaveBlob(ctx, blob.Type, plaintext, blob.ID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	kr.markRotated(name)
+	return true, nil
+}
+
+// removePacks saves all pending packs and an index for them, and removes the
+// packs in ids from the backend afterwards. This way the blobs are always
+// referenced by an index file, even if the rotation is interrupted.
+func (kr *KeyRotation) removePacks(ctx context.Context, ids restic.IDSet) error {
+	err := kr.repo.Flush(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = kr.repo.SaveIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	for id := range ids {
+		debug.Log("remove old pack %v", id)
+		err = kr.repo.be.Remove(ctx, restic.Handle{Type: restic.DataFile, Name: id.String()})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rotateSnapshots re-encrypts all snapshots which are still encrypted with
+// the old master key. As the ID of a snapshot is the hash of the encrypted
+// file, all snapshots get a new ID.
+func (kr *KeyRotation) rotateSnapshots(ctx context.Context) error {
+	r := kr.repo
+
+	var ids restic.IDs
+	err := r.List(ctx, restic.SnapshotFile, func(id restic.ID, size int64) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the snapshots which have already been re-encrypted, identified by the
+	// hash of the plaintext, so that an interrupted run does not leave
+	// duplicate snapshots behind
+	rotated := restic.NewIDSet()
+	snapshots := make(map[restic.ID][]byte)
+	for _, id := range ids {
+		buf, err := backend.LoadAll(ctx, r.be, restic.Handle{Type: restic.SnapshotFile, Name: id.String()})
+		if err != nil {
+			return err
+		}
+
+		plaintext, isRotated, err := kr.decrypt(buf)
+		if err != nil {
+			return errors.Wrapf(err, "snapshot %v", id.Str())
+		}
+
+		if isRotated {
+			rotated.Insert(restic.Hash(plaintext))
+			continue
+		}
+		snapshots[id] = plaintext
+	}
+
+	for id, plaintext := range snapshots {
+		if !rotated.Has(restic.Hash(plaintext)) {
+			newID, err := r.SaveUnpacked(ctx, restic.SnapshotFile, plaintext)
+			if err != nil {
+				return err
+			}
+			debug.Log("re-encrypted snapshot %v as %v", id, newID)
+		}
+
+		err = r.be.Remove(ctx, restic.Handle{Type: restic.SnapshotFile, Name: id.String()})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rotateConfig re-encrypts the config with the new master key.
+func (kr *KeyRotation) rotateConfig(ctx context.Context) error {
+	r := kr.repo
+
+	h := restic.Handle{Type: restic.ConfigFile}
+	buf, err := backend.LoadAll(ctx, r.be, h)
+	if err != nil {
+		return err
+	}
+
+	plaintext, rotated, err := kr.decrypt(buf)
+	if err != nil {
+		return errors.Wrap(err, "config")
+	}
+
+	if rotated {
+		return nil
+	}
+
+	return r.replaceConfig(ctx, plaintext)
+}
+
+// replaceKeys saves a regular key for the new master key and removes the keys
+// for the old master key, the pending key is removed last. Write-only keys are
+// kept, they contain the config with the unchanged public key. Sealed keys are
+// only removed when data encrypted with them was re-encrypted, others may
+// belong to a write-only client which is still saving data. It returns the new
+// key.
+func (kr *KeyRotation) replaceKeys(ctx context.Context) (*Key, error) {
+	r := kr.repo
+
+	key := kr.finalKey
+	if key == nil {
+		var err error
+		key, err = AddKey(ctx, r, kr.password, kr.newKey.master)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var names []string
+	err := r.be.List(ctx, restic.KeyFile, func(fi restic.FileInfo) error {
+		if fi.Name == key.Name() || fi.Name == kr.newKey.Name() {
+			return nil
+		}
+
+		if _, err := restic.ParseID(fi.Name); err != nil {
+			debug.Log("ignoring key with invalid name: %v", fi.Name)
+			return nil
+		}
+
+		k, err := LoadKey(ctx, r, fi.Name)
+		if err != nil {
+			return err
+		}
+
+		if k.WriteOnly {
+			return nil
+		}
+
+		if _, ok := kr.rotatedSealed[fi.Name]; k.Sealed != nil && !ok {
+			debug.Log("keep sealed key %v", fi.Name)
+			return nil
+		}
+
+		names = append(names, fi.Name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	names = append(names, kr.newKey.Name())
+
+	for _, name := range names {
+		debug.Log("remove key %v", name)
+		err = r.be.Remove(ctx, restic.Handle{Type: restic.KeyFile, Name: name})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.useKey(key)
+	return key, nil
+}
@@ -0,0 +1,200 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// failRemoveBackend returns an error when a file of type failType is removed.
+type failRemoveBackend struct {
+	restic.Backend
+	failType restic.FileType
+}
+
+func (be failRemoveBackend) Remove(ctx context.Context, h restic.Handle) error {
+	if h.Type == be.failType {
+		return errors.Errorf("injected error removing %v", h)
+	}
+	return be.Backend.Remove(ctx, h)
+}
+
+// failConfigSaveBackend returns an error when the config is saved, so that a
+// rotation which is interrupted after removing the old config is simulated.
+type failConfigSaveBackend struct {
+	restic.Backend
+}
+
+func (be failConfigSaveBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if h.Type == restic.ConfigFile {
+		return errors.Errorf("injected error saving %v", h)
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func countFiles(t *testing.T, repo restic.Repository, tpe restic.FileType) int {
+	n := 0
+	err := repo.List(context.TODO(), tpe, func(id restic.ID, size int64) error {
+		n++
+		return nil
+	})
+	rtest.OK(t, err)
+	return n
+}
+
+func rotateKey(t *testing.T, be restic.Backend) error {
+	repo := repository.New(be)
+	kr, err := repository.NewKeyRotation(context.TODO(), repo, rtest.TestPassword)
+	rtest.OK(t, err)
+
+	_, err = kr.Run(context.TODO(), nil)
+	return err
+}
+
+func TestKeyRotation(t *testing.T) {
+	var tests = []struct {
+		name string
+		// interrupt is the type of file for which the first removal fails,
+		// which aborts the first run of the rotation
+		interrupt restic.FileType
+		// failConfigSave aborts the first run after the old config has been
+		// removed
+		failConfigSave bool
+	}{
+		{name: "complete"},
+		{name: "packs", interrupt: restic.DataFile},
+		{name: "index", interrupt: restic.IndexFile},
+		{name: "snapshots", interrupt: restic.SnapshotFile},
+		{name: "config", interrupt: restic.ConfigFile},
+		{name: "config-save", failConfigSave: true},
+		{name: "keys", interrupt: restic.KeyFile},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			be, cleanup := repository.TestBackend(t)
+			defer cleanup()
+
+			repo, cleanup := repository.TestRepositoryWithBackend(t, be)
+			defer cleanup()
+
+			createRandomBlobs(t, repo, 20, 0.5)
+			saveIndex(t, repo)
+
+			blobs := restic.NewBlobSet()
+			for pb := range repo.Index().Each(context.TODO()) {
+				blobs.Insert(restic.BlobHandle{ID: pb.ID, Type: pb.Type})
+			}
+
+			for i := 0; i < 3; i++ {
+				sn, err := restic.NewSnapshot([]string{"/foo"}, nil, "host", time.Now())
+				rtest.OK(t, err)
+				_, err = repo.SaveJSONUnpacked(context.TODO(), restic.SnapshotFile, sn)
+				rtest.OK(t, err)
+			}
+
+			// a key with another password for the old master key
+			_, err := repository.AddKey(context.TODO(), repo.(*repository.Repository), "other password", repo.Key())
+			rtest.OK(t, err)
+
+			oldKey := repo.Key()
+
+			if test.interrupt != "" || test.failConfigSave {
+				var failBe restic.Backend = failRemoveBackend{Backend: be, failType: test.interrupt}
+				if test.failConfigSave {
+					failBe = failConfigSaveBackend{Backend: be}
+				}
+
+				err = rotateKey(t, failBe)
+				rtest.Assert(t, err != nil, "expected an error for the interrupted rotation")
+
+				// the repository must not be used until the rotation is complete
+				err = repository.New(be).SearchKey(context.TODO(), rtest.TestPassword, 0)
+				rtest.Assert(t, err == repository.ErrKeyRotationInProgress,
+					"expected ErrKeyRotationInProgress, got %v", err)
+			}
+
+			rtest.OK(t, rotateKey(t, be))
+
+			rtest.Equals(t, 1, countFiles(t, repo, restic.KeyFile))
+			rtest.Equals(t, 3, countFiles(t, repo, restic.SnapshotFile))
+
+			err = repository.New(be).SearchKey(context.TODO(), "other password", 0)
+			rtest.Assert(t, err == repository.ErrNoKeyFound,
+				"expected ErrNoKeyFound for the removed key, got %v", err)
+
+			newRepo := repository.New(be)
+			rtest.OK(t, newRepo.SearchKey(context.TODO(), rtest.TestPassword, 0))
+			rtest.Assert(t, newRepo.Key().EncryptionKey != oldKey.EncryptionKey, "master key was not replaced")
+			rtest.OK(t, newRepo.LoadIndex(context.TODO()))
+
+			for h := range blobs {
+				size, found := newRepo.LookupBlobSize(h.ID, h.Type)
+				rtest.Assert(t, found, "blob %v not found in the index", h)
+
+				buf := restic.NewBlobBuffer(int(size))
+				n, err := newRepo.LoadBlob(context.TODO(), h.Type, h.ID, buf)
+				rtest.OK(t, err)
+				rtest.Equals(t, h.ID, restic.Hash(buf[:n]))
+			}
+
+			err = newRepo.List(context.TODO(), restic.SnapshotFile, func(id restic.ID, size int64) error {
+				_, err := restic.LoadSnapshot(context.TODO(), newRepo, id)
+				return err
+			})
+			rtest.OK(t, err)
+		})
+	}
+}
+
+func TestKeyRotationWriteOnlyKey(t *testing.T) {
+	repo, cleanup := repository.TestRepositoryWithVersion(t, restic.WriteOnlyRepoVersion)
+	defer cleanup()
+
+	be := repo.Backend()
+
+	_, err := repository.AddWriteOnlyKey(context.TODO(), repo.(*repository.Repository), "write-only")
+	rtest.OK(t, err)
+
+	saveWriteOnly := func() restic.ID {
+		wrepo := repository.New(be)
+		rtest.OK(t, wrepo.SearchKey(context.TODO(), "write-only", 0))
+		rtest.Assert(t, wrepo.WriteOnly(), "repository was not opened in write-only mode")
+
+		id, err := wrepo.SaveBlob(context.TODO(), restic.DataBlob, random(t, 5000), restic.ID{})
+		rtest.OK(t, err)
+		rtest.OK(t, wrepo.Flush(context.TODO()))
+		rtest.OK(t, wrepo.SaveIndex(context.TODO()))
+		return id
+	}
+
+	blobs := restic.IDs{saveWriteOnly()}
+
+	// the regular key, the write-only key and the sealed key
+	rtest.Equals(t, 3, countFiles(t, repo, restic.KeyFile))
+
+	rtest.OK(t, rotateKey(t, be))
+
+	// the sealed key was removed together with the old master key, the
+	// write-only key is kept and can still be used
+	rtest.Equals(t, 2, countFiles(t, repo, restic.KeyFile))
+	blobs = append(blobs, saveWriteOnly())
+
+	r := repository.New(be)
+	rtest.OK(t, r.SearchKey(context.TODO(), rtest.TestPassword, 0))
+	rtest.OK(t, r.LoadIndex(context.TODO()))
+	for _, id := range blobs {
+		size, found := r.LookupBlobSize(id, restic.DataBlob)
+		rtest.Assert(t, found, "blob %v not found in the index", id.Str())
+
+		buf := restic.NewBlobBuffer(int(size))
+		n, err := r.LoadBlob(context.TODO(), restic.DataBlob, id, buf)
+		rtest.OK(t, err)
+		rtest.Equals(t, id, restic.Hash(buf[:n]))
+	}
+}
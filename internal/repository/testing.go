@@ -42,6 +42,16 @@ const testChunkerPol = chunker.Pol(0x3DA3358B4DC173)
 // password. If be is nil, an in-memory backend is used. A constant polynomial
 // is used for the chunker and low-security test parameters.
 func TestRepositoryWithBackend(t testing.TB, be restic.Backend) (r restic.Repository, cleanup func()) {
+	return testRepository(t, be, restic.RepoVersion)
+}
+
+// TestRepositoryWithVersion returns a repository on an in-memory backend like
+// TestRepository, but with the given repository version.
+func TestRepositoryWithVersion(t testing.TB, version uint) (r restic.Repository, cleanup func()) {
+	return testRepository(t, nil, version)
+}
+
+func testRepository(t testing.TB, be restic.Backend, version uint) (r restic.Repository, cleanup func()) {
 	TestUseLowSecurityKDFParameters(t)
 
 	var beCleanup func()
@@ -52,6 +62,7 @@ func TestRepositoryWithBackend(t testing.TB, be restic.Backend) (r restic.Reposi
 	repo := New(be)
 
 	cfg := restic.TestCreateConfig(t, testChunkerPol)
+	cfg.Version = version
 	err := repo.init(context.TODO(), test.TestPassword, cfg)
 	if err != nil {
 		t.Fatalf("TestRepository(): initialize repo failed: %v", err)
@@ -12,11 +12,28 @@ type Blob struct {
 	Length uint
 	ID     ID
 	Offset uint
+
+	// UncompressedLength is the length of the plaintext before compression,
+	// it is zero for blobs which are stored without compression.
+	UncompressedLength uint
 }
 
 func (b Blob) String() string {
-	return fmt.Sprintf("<Blob (%v) %v, offset %v, length %v>",
-		b.Type, b.ID.Str(), b.Offset, b.Length)
+	return fmt.Sprintf("<Blob (%v) %v, offset %v, length %v, uncompressed length %v>",
+		b.Type, b.ID.Str(), b.Offset, b.Length, b.UncompressedLength)
+}
+
+// IsCompressed returns true iff the blob is stored compressed.
+func (b Blob) IsCompressed() bool {
+	return b.UncompressedLength != 0
+}
+
+// DataLength returns the length of the plaintext content of the blob.
+func (b Blob) DataLength() uint {
+	if b.IsCompressed() {
+		return b.UncompressedLength
+	}
+	return uint(PlaintextLength(int(b.Length)))
 }
 
 // PackedBlob is a blob stored within a file.
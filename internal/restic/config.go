@@ -2,6 +2,7 @@ package restic
 
 import (
 	"context"
+	"io"
 	"testing"
 
 	"github.com/restic/restic/internal/errors"
@@ -16,11 +17,50 @@ type Config struct {
 	Version           uint        `json:"version"`
 	ID                string      `json:"id"`
 	ChunkerPolynomial chunker.Pol `json:"chunker_polynomial"`
+
+	// MinChunkSize, AvgChunkSize and MaxChunkSize are the bounds in bytes
+	// for the content defined chunking. When they are zero, the default
+	// sizes are used. Other sizes require ChunkSizesRepoVersion.
+	MinChunkSize uint `json:"min_chunk_size,omitempty"`
+	AvgChunkSize uint `json:"avg_chunk_size,omitempty"`
+	MaxChunkSize uint `json:"max_chunk_size,omitempty"`
+
+	// ParityShards is the number of parity shards written for each new pack
+	// file on backends which support parity data. Older versions of restic
+	// ignore it and write packs without parity data.
+	ParityShards uint `json:"parity_shards,omitempty"`
+
+	// PublicKey is used by write-only keys to seal the master keys for the
+	// data they save, which can be opened with PrivateKey. The config is
+	// encrypted with the master key, so the private key is only available
+	// to those who can read all other data in the repository as well. The
+	// copy of the config in write-only keys does not contain it. Write-only
+	// keys require WriteOnlyRepoVersion.
+	PublicKey  []byte `json:"public_key,omitempty"`
+	PrivateKey []byte `json:"private_key,omitempty"`
 }
 
+// Repository versions supported by this version of restic. Starting with
+// version 2, blobs are stored compressed.
+const (
+	MinRepoVersion = 1
+	MaxRepoVersion = 4
+)
+
+// ChunkSizesRepoVersion is the first repository version which may use chunk
+// sizes other than DefaultChunkSizes. Older versions of restic refuse to open
+// such repositories instead of splitting files with the default sizes.
+const ChunkSizesRepoVersion = 3
+
+// WriteOnlyRepoVersion is the first repository version which may contain
+// write-only keys and the sealed keys saved by write-only clients. Older
+// versions of restic refuse to open such repositories, so that they cannot
+// miss the data saved with sealed keys.
+const WriteOnlyRepoVersion = 4
+
 // RepoVersion is the version that is written to the config when a repository
 // is newly created with Init().
-const RepoVersion = 1
+const RepoVersion = 2
 
 // JSONUnpackedLoader loads unpacked JSON.
 type JSONUnpackedLoader interface {
@@ -28,20 +68,24 @@ type JSONUnpackedLoader interface {
 }
 
 // CreateConfig creates a config file with a randomly selected polynomial and
-// ID.
-func CreateConfig() (Config, error) {
+// ID for the given repository version.
+func CreateConfig(version uint) (Config, error) {
 	var (
 		err error
 		cfg Config
 	)
 
+	if version < MinRepoVersion || version > MaxRepoVersion {
+		return Config{}, errors.Errorf("unsupported repository version %v", version)
+	}
+
 	cfg.ChunkerPolynomial, err = chunker.RandomPolynomial()
 	if err != nil {
 		return Config{}, errors.Wrap(err, "chunker.RandomPolynomial")
 	}
 
 	cfg.ID = NewRandomID().String()
-	cfg.Version = RepoVersion
+	cfg.Version = version
 
 	debug.Log("New config: %#v", cfg)
 	return cfg, nil
@@ -68,13 +112,125 @@ func LoadConfig(ctx context.Context, r JSONUnpackedLoader) (Config, error) {
 		return Config{}, err
 	}
 
-	if cfg.Version != RepoVersion {
-		return Config{}, errors.New("unsupported repository version")
+	if cfg.Version < MinRepoVersion || cfg.Version > MaxRepoVersion {
+		return Config{}, errors.Errorf("unsupported repository version %v", cfg.Version)
 	}
 
 	if !cfg.ChunkerPolynomial.Irreducible() {
 		return Config{}, errors.New("invalid chunker polynomial")
 	}
 
+	if err := cfg.ChunkSizes().Check(); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.ChunkSizes() != DefaultChunkSizes && cfg.Version < ChunkSizesRepoVersion {
+		return Config{}, errors.Errorf("repository version %v does not support custom chunk sizes", cfg.Version)
+	}
+
+	if len(cfg.PublicKey) != 0 && cfg.Version < WriteOnlyRepoVersion {
+		return Config{}, errors.Errorf("repository version %v does not support write-only keys", cfg.Version)
+	}
+
 	return cfg, nil
 }
+
+// ChunkSizes are the bounds for the content defined chunking. Avg must be a
+// power of two, a chunk boundary is found on average after Avg bytes once
+// Min bytes have been read.
+type ChunkSizes struct {
+	Min uint
+	Avg uint
+	Max uint
+}
+
+// DefaultChunkSizes are used for repositories which do not specify the
+// chunk sizes in the config.
+var DefaultChunkSizes = ChunkSizes{
+	Min: chunker.MinSize,
+	Avg: 1 << 20,
+	Max: chunker.MaxSize,
+}
+
+// Limits for the chunk sizes which can be configured for a repository.
+const (
+	MinChunkSizeLimit = 4 << 10
+	MaxChunkSizeLimit = 128 << 20
+)
+
+// Check returns an error if s does not contain valid chunk sizes.
+func (s ChunkSizes) Check() error {
+	if s.Min < MinChunkSizeLimit {
+		return errors.Errorf("minimal chunk size %d is smaller than %d", s.Min, MinChunkSizeLimit)
+	}
+
+	if s.Max > MaxChunkSizeLimit {
+		return errors.Errorf("maximal chunk size %d is larger than %d", s.Max, MaxChunkSizeLimit)
+	}
+
+	if s.Avg&(s.Avg-1) != 0 {
+		return errors.Errorf("average chunk size %d is not a power of two", s.Avg)
+	}
+
+	if s.Min > s.Avg || s.Avg > s.Max {
+		return errors.Errorf("invalid chunk sizes %d/%d/%d, min <= avg <= max is required", s.Min, s.Avg, s.Max)
+	}
+
+	return nil
+}
+
+// averageBits returns the number of bits of the split mask for the chunker.
+func (s ChunkSizes) averageBits() int {
+	bits := 0
+	for avg := s.Avg; avg > 1; avg >>= 1 {
+		bits++
+	}
+	return bits
+}
+
+// ChunkSizes returns the chunk sizes of the repository.
+func (cfg Config) ChunkSizes() ChunkSizes {
+	s := DefaultChunkSizes
+	if cfg.MinChunkSize != 0 {
+		s.Min = cfg.MinChunkSize
+	}
+	if cfg.AvgChunkSize != 0 {
+		s.Avg = cfg.AvgChunkSize
+	}
+	if cfg.MaxChunkSize != 0 {
+		s.Max = cfg.MaxChunkSize
+	}
+	return s
+}
+
+// SetChunkSizes stores the chunk sizes s in the config. The default sizes
+// are not stored, so that the config stays compatible with older versions.
+func (cfg *Config) SetChunkSizes(s ChunkSizes) {
+	cfg.MinChunkSize, cfg.AvgChunkSize, cfg.MaxChunkSize = 0, 0, 0
+	if s != DefaultChunkSizes {
+		cfg.MinChunkSize, cfg.AvgChunkSize, cfg.MaxChunkSize = s.Min, s.Avg, s.Max
+	}
+}
+
+// NewChunker returns a chunker for rd which uses the polynomial and the chunk
+// sizes of the repository.
+func (cfg Config) NewChunker(rd io.Reader) *chunker.Chunker {
+	s := cfg.ChunkSizes()
+	c := chunker.NewWithBoundaries(rd, cfg.ChunkerPolynomial, s.Min, s.Max)
+	c.SetAverageBits(s.averageBits())
+	return c
+}
+
+// ResetChunker reinitializes c for rd with the polynomial and the chunk sizes
+// of the repository.
+func (cfg Config) ResetChunker(c *chunker.Chunker, rd io.Reader) {
+	s := cfg.ChunkSizes()
+	c.ResetWithBoundaries(rd, cfg.ChunkerPolynomial, s.Min, s.Max)
+	c.SetAverageBits(s.averageBits())
+}
+
+// SupportsCompression returns true iff blobs may be stored compressed in a
+// repository with this config.
+func (cfg Config) SupportsCompression() bool {
+	return cfg.Version >= 2
+}
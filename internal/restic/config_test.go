@@ -1,7 +1,9 @@
 package restic_test
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"testing"
 
 	"github.com/restic/restic/internal/restic"
@@ -32,7 +34,7 @@ func TestConfig(t *testing.T) {
 		return restic.ID{}, nil
 	}
 
-	cfg1, err := restic.CreateConfig()
+	cfg1, err := restic.CreateConfig(restic.RepoVersion)
 	rtest.OK(t, err)
 
 	_, err = saver(save).SaveJSONUnpacked(restic.ConfigFile, cfg1)
@@ -50,6 +52,96 @@ func TestConfig(t *testing.T) {
 	cfg2, err := restic.LoadConfig(context.TODO(), loader(load))
 	rtest.OK(t, err)
 
-	rtest.Assert(t, cfg1 == cfg2,
-		"configs aren't equal: %v != %v", cfg1, cfg2)
+	rtest.Equals(t, cfg1, cfg2)
+}
+
+func TestConfigChunkSizes(t *testing.T) {
+	cfg, err := restic.CreateConfig(restic.RepoVersion)
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.DefaultChunkSizes, cfg.ChunkSizes())
+
+	sizes := restic.ChunkSizes{Min: 16 << 10, Avg: 64 << 10, Max: 256 << 10}
+	cfg.SetChunkSizes(sizes)
+	rtest.Equals(t, sizes, cfg.ChunkSizes())
+
+	// the default sizes are not stored in the config
+	cfg.SetChunkSizes(restic.DefaultChunkSizes)
+	rtest.Equals(t, uint(0), cfg.MinChunkSize)
+	rtest.Equals(t, restic.DefaultChunkSizes, cfg.ChunkSizes())
+
+	cfg.SetChunkSizes(sizes)
+	buf := rtest.Random(23, 4<<20)
+	chnker := cfg.NewChunker(bytes.NewReader(buf))
+
+	var total, count uint
+	for {
+		chunk, err := chnker.Next(nil)
+		if err == io.EOF {
+			break
+		}
+		rtest.OK(t, err)
+
+		total += chunk.Length
+		count++
+
+		if chunk.Length > sizes.Max || (total < uint(len(buf)) && chunk.Length < sizes.Min) {
+			t.Fatalf("chunk %d has invalid length %d", count, chunk.Length)
+		}
+	}
+	rtest.Equals(t, uint(len(buf)), total)
+
+	// the average is about min + avg
+	avg := total / count
+	if avg < sizes.Min || avg > sizes.Min+4*sizes.Avg {
+		t.Fatalf("unexpected average chunk size %d for %d chunks", avg, count)
+	}
+}
+
+func TestChunkSizesCheck(t *testing.T) {
+	rtest.OK(t, restic.DefaultChunkSizes.Check())
+
+	for _, sizes := range []restic.ChunkSizes{
+		{},
+		{Min: 1 << 10, Avg: 1 << 20, Max: 8 << 20},
+		{Min: 512 << 10, Avg: 1 << 20, Max: 1 << 30},
+		{Min: 512 << 10, Avg: 1000000, Max: 8 << 20},
+		{Min: 2 << 20, Avg: 1 << 20, Max: 8 << 20},
+		{Min: 512 << 10, Avg: 16 << 20, Max: 8 << 20},
+	} {
+		rtest.Assert(t, sizes.Check() != nil, "expected an error for invalid chunk sizes %v", sizes)
+	}
+}
+
+func TestLoadConfigInvalidChunkSizes(t *testing.T) {
+	cfg, err := restic.CreateConfig(restic.RepoVersion)
+	rtest.OK(t, err)
+	cfg.MinChunkSize = 1 << 30
+
+	load := func(ctx context.Context, tpe restic.FileType, id restic.ID, arg interface{}) error {
+		*arg.(*restic.Config) = cfg
+		return nil
+	}
+
+	_, err = restic.LoadConfig(context.TODO(), loader(load))
+	rtest.Assert(t, err != nil, "expected an error for invalid chunk sizes")
+}
+
+func TestLoadConfigChunkSizesVersion(t *testing.T) {
+	for _, version := range []uint{1, 2, restic.ChunkSizesRepoVersion} {
+		cfg, err := restic.CreateConfig(version)
+		rtest.OK(t, err)
+		cfg.SetChunkSizes(restic.ChunkSizes{Min: 16 << 10, Avg: 64 << 10, Max: 256 << 10})
+
+		load := func(ctx context.Context, tpe restic.FileType, id restic.ID, arg interface{}) error {
+			*arg.(*restic.Config) = cfg
+			return nil
+		}
+
+		_, err = restic.LoadConfig(context.TODO(), loader(load))
+		if version < restic.ChunkSizesRepoVersion {
+			rtest.Assert(t, err != nil, "expected an error for chunk sizes in repository version %v", version)
+		} else {
+			rtest.OK(t, err)
+		}
+	}
 }
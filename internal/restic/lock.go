@@ -41,9 +41,17 @@ type Lock struct {
 // acquire the desired lock.
 type ErrAlreadyLocked struct {
 	otherLock *Lock
+
+	// unreadable is set when the other lock cannot be loaded, e.g. because
+	// it was created by a write-only client.
+	unreadable *ID
 }
 
 func (e ErrAlreadyLocked) Error() string {
+	if e.unreadable != nil {
+		return fmt.Sprintf("repository is already locked by lock %v, which cannot be read with this key", e.unreadable.Str())
+	}
+
 	s := ""
 	if e.otherLock.Exclusive {
 		s = "exclusively "
@@ -137,6 +145,11 @@ func (l *Lock) fillUserInfo() error {
 // if there are any other locks, regardless if exclusive or not. If a
 // non-exclusive lock is to be created, an error is only returned when an
 // exclusive lock is found.
+//
+// Locks of write-only clients cannot be read by other clients, and
+// write-only clients cannot read any other locks. As it is unknown whether
+// such a lock is exclusive, an unreadable lock conflicts with exclusive locks
+// and with all locks of write-only clients.
 func (l *Lock) checkForOtherLocks(ctx context.Context) error {
 	return l.repo.List(ctx, LockFile, func(id ID, size int64) error {
 		if l.lockID != nil && id.Equal(*l.lockID) {
@@ -144,8 +157,20 @@ func (l *Lock) checkForOtherLocks(ctx context.Context) error {
 		}
 
 		lock, err := LoadLock(ctx, l.repo, id)
+		if err != nil && l.repo.Backend().IsNotExist(err) {
+			// the lock has been removed in the meantime
+			debug.Log("ignore removed lock %v: %v", id, err)
+			return nil
+		}
+
 		if err != nil {
-			// ignore locks that cannot be loaded
+			if l.Exclusive || l.repo.WriteOnly() {
+				debug.Log("unable to load lock %v: %v", id, err)
+				return ErrAlreadyLocked{unreadable: &id}
+			}
+
+			// non-exclusive locks don't conflict with non-exclusive locks, and
+			// write-only clients only create non-exclusive locks
 			debug.Log("ignore lock %v: %v", id, err)
 			return nil
 		}
@@ -273,6 +298,8 @@ func LoadLock(ctx context.Context, repo Repository, id ID) (*Lock, error) {
 }
 
 // RemoveStaleLocks deletes all locks detected as stale from the repository.
+// Locks that cannot be loaded, e.g. those of write-only clients, are kept, as
+// it is unknown whether they are stale.
 func RemoveStaleLocks(ctx context.Context, repo Repository) error {
 	return repo.List(ctx, LockFile, func(id ID, size int64) error {
 		lock, err := LoadLock(ctx, repo, id)
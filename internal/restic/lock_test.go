@@ -92,6 +92,67 @@ func TestExclusiveLockOnLockedRepo(t *testing.T) {
 	rtest.OK(t, elock.Unlock())
 }
 
+func openWriteOnly(t testing.TB, repo restic.Repository) restic.Repository {
+	_, err := repository.AddWriteOnlyKey(context.TODO(), repo.(*repository.Repository), "write-only")
+	rtest.OK(t, err)
+
+	wrepo := repository.New(repo.Backend())
+	rtest.OK(t, wrepo.SearchKey(context.TODO(), "write-only", 0))
+	return wrepo
+}
+
+func TestWriteOnlyLockOnLockedRepo(t *testing.T) {
+	repo, cleanup := repository.TestRepositoryWithVersion(t, restic.WriteOnlyRepoVersion)
+	defer cleanup()
+
+	wrepo := openWriteOnly(t, repo)
+
+	lock, err := restic.NewLock(context.TODO(), repo)
+	rtest.OK(t, err)
+
+	wlock, err := restic.NewLock(context.TODO(), wrepo)
+	rtest.Assert(t, restic.IsAlreadyLocked(err),
+		"create write-only lock with locked repo didn't return the correct error, got %v", err)
+
+	rtest.OK(t, lock.Unlock())
+
+	wlock, err = restic.NewLock(context.TODO(), wrepo)
+	rtest.OK(t, err)
+	rtest.OK(t, wlock.Unlock())
+}
+
+func TestExclusiveLockOnWriteOnlyLockedRepo(t *testing.T) {
+	repo, cleanup := repository.TestRepositoryWithVersion(t, restic.WriteOnlyRepoVersion)
+	defer cleanup()
+
+	wrepo := openWriteOnly(t, repo)
+
+	wlock, err := restic.NewLock(context.TODO(), wrepo)
+	rtest.OK(t, err)
+
+	// non-exclusive locks can still be created
+	lock, err := restic.NewLock(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.OK(t, lock.Unlock())
+
+	elock, err := restic.NewExclusiveLock(context.TODO(), repo)
+	rtest.Assert(t, restic.IsAlreadyLocked(err),
+		"create exclusive lock with write-only locked repo didn't return the correct error, got %v", err)
+
+	// the lock of the write-only client is not removed as stale
+	rtest.OK(t, restic.RemoveStaleLocks(context.TODO(), repo))
+
+	elock, err = restic.NewExclusiveLock(context.TODO(), repo)
+	rtest.Assert(t, restic.IsAlreadyLocked(err),
+		"create exclusive lock with write-only locked repo didn't return the correct error, got %v", err)
+
+	rtest.OK(t, wlock.Unlock())
+
+	elock, err = restic.NewExclusiveLock(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.OK(t, elock.Unlock())
+}
+
 func createFakeLock(repo restic.Repository, t time.Time, pid int) (restic.ID, error) {
 	hostname, err := os.Hostname()
 	if err != nil {
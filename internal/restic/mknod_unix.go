@@ -0,0 +1,9 @@
+// +build dragonfly linux netbsd openbsd solaris darwin
+
+package restic
+
+import "syscall"
+
+func mknod(path string, mode uint32, dev uint64) error {
+	return syscall.Mknod(path, mode, int(dev))
+}
@@ -264,7 +264,7 @@ func (node Node) createFileAt(ctx context.Context, path string, repo Repository,
 		return errors.Wrap(err, "OpenFile")
 	}
 
-	err = node.writeNodeContent(ctx, repo, f)
+	err = node.writeNodeContent(ctx, repo, f, true)
 	closeErr := f.Close()
 
 	if err != nil {
@@ -282,14 +282,27 @@ func (node Node) createFileAt(ctx context.Context, path string, repo Repository,
 	return nil
 }
 
-func (node Node) writeNodeContent(ctx context.Context, repo Repository, f *os.File) error {
-	var buf []byte
+// writeNodeContent writes the content of node to f. If sparse is true, f must
+// be empty and blobs which only contain zeros are skipped instead of written,
+// so that the file system can store them as holes.
+func (node Node) writeNodeContent(ctx context.Context, repo Repository, f *os.File, sparse bool) error {
+	var (
+		buf    []byte
+		offset int64
+		zeros  = NewIDSet()
+	)
+
 	for _, id := range node.Content {
 		size, found := repo.LookupBlobSize(id, DataBlob)
 		if !found {
 			return errors.Errorf("id %v not found in repository", id)
 		}
 
+		if sparse && zeros.Has(id) {
+			offset += int64(size)
+			continue
+		}
+
 		buf = buf[:cap(buf)]
 		if len(buf) < CiphertextLength(int(size)) {
 			buf = NewBlobBuffer(int(size))
@@ -301,15 +314,44 @@ func (node Node) writeNodeContent(ctx context.Context, repo Repository, f *os.Fi
 		}
 		buf = buf[:n]
 
-		_, err = f.Write(buf)
+		if sparse && isZero(buf) {
+			zeros.Insert(id)
+			offset += int64(n)
+			continue
+		}
+
+		_, err = f.WriteAt(buf, offset)
 		if err != nil {
 			return errors.Wrap(err, "Write")
 		}
+		offset += int64(n)
+	}
+
+	if sparse {
+		// extend the file if it ends with a hole
+		fi, err := f.Stat()
+		if err != nil {
+			return errors.Wrap(err, "Stat")
+		}
+
+		if fi.Size() < offset {
+			return errors.Wrap(f.Truncate(offset), "Truncate")
+		}
 	}
 
 	return nil
 }
 
+// isZero returns true if buf only contains zeros.
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 func (node Node) createSymlinkAt(path string) error {
 	// Windows does not allow non-admins to create soft links.
 	if runtime.GOOS == "windows" {
@@ -324,11 +366,11 @@ func (node Node) createSymlinkAt(path string) error {
 }
 
 func (node *Node) createDevAt(path string) error {
-	return mknod(path, syscall.S_IFBLK|0600, int(node.Device))
+	return mknod(path, syscall.S_IFBLK|0600, node.Device)
 }
 
 func (node *Node) createCharDevAt(path string) error {
-	return mknod(path, syscall.S_IFCHR|0600, int(node.Device))
+	return mknod(path, syscall.S_IFCHR|0600, node.Device)
 }
 
 func (node *Node) createFifoAt(path string) error {
@@ -2,6 +2,10 @@ package restic
 
 import "syscall"
 
+func mknod(path string, mode uint32, dev uint64) error {
+	return syscall.Mknod(path, mode, dev)
+}
+
 func (node Node) restoreSymlinkTimestamps(path string, utimes [2]syscall.Timespec) error {
 	return nil
 }
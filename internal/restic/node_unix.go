@@ -7,7 +7,6 @@ import (
 	"syscall"
 )
 
-var mknod = syscall.Mknod
 var lchown = os.Lchown
 
 type statUnix syscall.Stat_t
@@ -9,7 +9,7 @@ import (
 // mknod() creates a filesystem node (file, device
 // special file, or named pipe) named pathname, with attributes
 // specified by mode and dev.
-var mknod = func(path string, mode uint32, dev int) (err error) {
+var mknod = func(path string, mode uint32, dev uint64) (err error) {
 	return errors.New("device nodes cannot be created on windows")
 }
 
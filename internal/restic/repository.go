@@ -23,6 +23,11 @@ type Repository interface {
 	LoadIndex(context.Context) error
 
 	Config() Config
+	ReplaceConfig(context.Context, Config) error
+
+	// WriteOnly returns true if the repository was opened with a write-only
+	// key, which cannot read data saved by other clients.
+	WriteOnly() bool
 
 	LookupBlobSize(ID, BlobType) (uint, bool)
 
@@ -2,8 +2,10 @@ package restic
 
 import (
 	"context"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/restic/restic/internal/errors"
 
@@ -156,6 +158,110 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 	return res.restoreTo(ctx, dst, string(filepath.Separator), *res.sn.Tree, idx)
 }
 
+// RestoreFileContents writes the content of the file at path in the snapshot
+// to dst. When dst is a block device, it must be large enough for the
+// content, which is written to the beginning of the device. Otherwise, dst is
+// created or truncated, e.g. to restore the content of a device to an image
+// file. The metadata of the file is not restored.
+func (res *Restorer) RestoreFileContents(ctx context.Context, path, dst string) error {
+	node, err := res.findNode(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	if node.Type != "file" {
+		return errors.Errorf("%v is not a file but a %v", path, node.Type)
+	}
+
+	f, err := fs.OpenFile(dst, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+
+	sparse, err := prepareContentTarget(f, node.Size)
+	if err == nil {
+		err = node.writeNodeContent(ctx, res.repo, f, sparse)
+	}
+	if err == nil {
+		err = errors.Wrap(f.Sync(), "Sync")
+	}
+
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(closeErr, "Close")
+}
+
+// prepareContentTarget truncates f if it is a regular file, which can then be
+// written as a sparse file. Otherwise, f must be a device which is large
+// enough to hold size bytes.
+func prepareContentTarget(f *os.File, size uint64) (sparse bool, err error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return false, errors.Wrap(err, "Stat")
+	}
+
+	if fi.Mode().IsRegular() {
+		return true, errors.Wrap(f.Truncate(0), "Truncate")
+	}
+
+	devSize, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, errors.Wrap(err, "Seek")
+	}
+
+	if uint64(devSize) < size {
+		return false, errors.Errorf("%v is too small, %d bytes are needed but only %d bytes are available", f.Name(), size, devSize)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	return false, errors.Wrap(err, "Seek")
+}
+
+// findNode returns the node at path in the snapshot.
+func (res *Restorer) findNode(ctx context.Context, path string) (*Node, error) {
+	path = filepath.Clean(string(filepath.Separator) + path)
+	if path == string(filepath.Separator) {
+		return nil, errors.New("path must not be empty")
+	}
+
+	components := strings.Split(path[1:], string(filepath.Separator))
+	treeID := *res.sn.Tree
+
+	for i, name := range components {
+		tree, err := res.repo.LoadTree(ctx, treeID)
+		if err != nil {
+			return nil, err
+		}
+
+		var node *Node
+		for _, n := range tree.Nodes {
+			if n.Name == name {
+				node = n
+				break
+			}
+		}
+
+		if node == nil {
+			return nil, errors.Errorf("path %v not found in snapshot", path)
+		}
+
+		if i == len(components)-1 {
+			return node, nil
+		}
+
+		if node.Type != "dir" || node.Subtree == nil {
+			return nil, errors.Errorf("path %v not found in snapshot, %v is not a directory",
+				path, filepath.Join(components[:i+1]...))
+		}
+		treeID = *node.Subtree
+	}
+
+	return nil, errors.Errorf("path %v not found in snapshot", path)
+}
+
 // Snapshot returns the snapshot this restorer is configured to use.
 func (res *Restorer) Snapshot() *Snapshot {
 	return res.sn
@@ -443,3 +443,94 @@ func TestRestorerRelative(t *testing.T) {
 		})
 	}
 }
+
+func TestRestorerFileContents(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dev": Dir{
+				Nodes: map[string]Node{
+					"disk": File{"content of the device\n"},
+				},
+			},
+		},
+	})
+
+	res, err := restic.NewRestorer(repo, id)
+	rtest.OK(t, err)
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	// an existing image file is overwritten
+	image := filepath.Join(tempdir, "disk.img")
+	rtest.OK(t, ioutil.WriteFile(image, bytes.Repeat([]byte("x"), 100), 0600))
+
+	rtest.OK(t, res.RestoreFileContents(context.TODO(), "/dev/disk", image))
+
+	data, err := ioutil.ReadFile(image)
+	rtest.OK(t, err)
+	rtest.Equals(t, "content of the device\n", string(data))
+
+	for _, path := range []string{"/dev", "/dev/missing", "/dev/disk/foo", ""} {
+		err = res.RestoreFileContents(context.TODO(), path, filepath.Join(tempdir, "other.img"))
+		rtest.Assert(t, err != nil, "expected an error for path %q", path)
+	}
+}
+
+func TestNodeCreateAtSparse(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	var tests = []struct {
+		name  string
+		blobs [][]byte
+	}{
+		{"data", [][]byte{rtest.Random(1, 1000), rtest.Random(2, 3000)}},
+		{"zeros", [][]byte{make([]byte, 4096), make([]byte, 4096)}},
+		{"holes", [][]byte{
+			rtest.Random(3, 1000),
+			make([]byte, 4096),
+			rtest.Random(4, 2000),
+			make([]byte, 4096),
+			rtest.Random(5, 10),
+		}},
+		{"trailing-hole", [][]byte{rtest.Random(6, 1000), make([]byte, 2048), make([]byte, 4096)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := restic.Node{
+				Name:    test.name,
+				Type:    "file",
+				Mode:    0600,
+				ModTime: time.Now(),
+			}
+
+			var want []byte
+			for _, blob := range test.blobs {
+				id, err := repo.SaveBlob(context.TODO(), restic.DataBlob, blob, restic.ID{})
+				rtest.OK(t, err)
+				node.Content = append(node.Content, id)
+				node.Size += uint64(len(blob))
+				want = append(want, blob...)
+			}
+			rtest.OK(t, repo.Flush(context.TODO()))
+
+			// an existing file is replaced
+			filename := filepath.Join(tempdir, test.name)
+			rtest.OK(t, ioutil.WriteFile(filename, bytes.Repeat([]byte("x"), 20000), 0600))
+
+			rtest.OK(t, node.CreateAt(context.TODO(), filename, repo, restic.NewHardlinkIndex()))
+
+			got, err := ioutil.ReadFile(filename)
+			rtest.OK(t, err)
+			rtest.Assert(t, bytes.Equal(want, got), "wrong content restored, want %d bytes, got %d bytes", len(want), len(got))
+		})
+	}
+}
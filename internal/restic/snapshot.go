@@ -24,9 +24,38 @@ type Snapshot struct {
 	Tags     []string  `json:"tags,omitempty"`
 	Original *ID       `json:"original,omitempty"`
 
+	Summary *SnapshotSummary `json:"summary,omitempty"`
+
 	id *ID // plaintext ID, used during restore
 }
 
+// SnapshotSummary contains statistics about the backup which created a
+// snapshot. It is not available for snapshots created by older versions of
+// restic.
+type SnapshotSummary struct {
+	BackupStart time.Time `json:"backup_start"`
+	BackupEnd   time.Time `json:"backup_end"`
+
+	// statistics about the regular files in the snapshot, compared to the
+	// parent snapshot
+	FilesNew        uint `json:"files_new"`
+	FilesChanged    uint `json:"files_changed"`
+	FilesUnmodified uint `json:"files_unmodified"`
+
+	// statistics about the blobs added to the repository
+	DataBlobs int    `json:"data_blobs"`
+	TreeBlobs int    `json:"tree_blobs"`
+	DataAdded uint64 `json:"data_added"`
+
+	TotalFilesProcessed uint   `json:"total_files_processed"`
+	TotalBytesProcessed uint64 `json:"total_bytes_processed"`
+}
+
+// Duration returns how long the backup took.
+func (s *SnapshotSummary) Duration() time.Duration {
+	return s.BackupEnd.Sub(s.BackupStart)
+}
+
 // NewSnapshot returns an initialized snapshot struct for the current user and
 // time.
 func NewSnapshot(paths []string, tags []string, hostname string, time time.Time) (*Snapshot, error) {
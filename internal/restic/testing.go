@@ -36,9 +36,9 @@ func (fs *fakeFileSystem) saveFile(ctx context.Context, rd io.Reader) (blobs IDs
 	}
 
 	if fs.chunker == nil {
-		fs.chunker = chunker.New(rd, fs.repo.Config().ChunkerPolynomial)
+		fs.chunker = fs.repo.Config().NewChunker(rd)
 	} else {
-		fs.chunker.Reset(rd, fs.repo.Config().ChunkerPolynomial)
+		fs.repo.Config().ResetChunker(fs.chunker, rd)
 	}
 
 	blobs = IDs{}
@@ -0,0 +1,68 @@
+package restserver
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"os"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Htpasswd holds the users and password hashes read from an htpasswd file.
+type Htpasswd map[string]string
+
+// ReadHtpasswd reads the htpasswd file filename. Passwords must be hashed
+// with bcrypt (htpasswd -B) or SHA1 (htpasswd -s).
+func ReadHtpasswd(filename string) (Htpasswd, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
+	defer f.Close()
+
+	users := make(Htpasswd)
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		data := strings.SplitN(text, ":", 2)
+		if len(data) != 2 || data[0] == "" {
+			return nil, errors.Errorf("%v:%d: invalid line, expected user:hash", filename, line)
+		}
+
+		if !strings.HasPrefix(data[1], "$2") && !strings.HasPrefix(data[1], "{SHA}") {
+			return nil, errors.Errorf("%v:%d: unsupported password hash for user %q, use bcrypt or SHA1", filename, line, data[0])
+		}
+
+		users[data[0]] = data[1]
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrap(err, "Scan")
+	}
+
+	return users, nil
+}
+
+// Check returns true if password is valid for user.
+func (h Htpasswd) Check(user, password string) bool {
+	hash, ok := h[user]
+	if !ok {
+		return false
+	}
+
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
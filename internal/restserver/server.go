@@ -0,0 +1,489 @@
+// Package restserver implements a server for the REST protocol spoken by the
+// rest backend, which stores the data in arbitrary restic backends.
+package restserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+const (
+	contentTypeV1 = "application/vnd.x.restic.rest.v1"
+	contentTypeV2 = "application/vnd.x.restic.rest.v2"
+)
+
+// dirTypes maps the directory names used by the REST protocol to file types.
+var dirTypes = map[string]restic.FileType{
+	"data":      restic.DataFile,
+	"keys":      restic.KeyFile,
+	"locks":     restic.LockFile,
+	"snapshots": restic.SnapshotFile,
+	"index":     restic.IndexFile,
+	"parity":    restic.ParityFile,
+}
+
+// OpenFunc returns the backend for the repository at path, which is empty
+// for the repository at the root of the server. If create is true, the
+// repository is created first.
+type OpenFunc func(ctx context.Context, path string, create bool) (restic.Backend, error)
+
+// Config configures a Server.
+type Config struct {
+	// AppendOnly refuses to remove or overwrite any files except locks. Saves
+	// to the same file are serialised by the server, but the backends must
+	// not be modified by other processes, as a file created by them between
+	// the check and the save would be overwritten.
+	AppendOnly bool
+
+	// Users enables authentication. When it is nil, all requests are allowed.
+	Users Htpasswd
+
+	// PrivateRepos restricts users to the repositories below the path with
+	// their user name.
+	PrivateRepos bool
+}
+
+// Server handles requests for the REST protocol.
+type Server struct {
+	cfg  Config
+	open OpenFunc
+
+	m        sync.Mutex
+	backends map[string]restic.Backend
+	saving   map[saveKey]*saveLock
+}
+
+// saveKey identifies a file in a repository.
+type saveKey struct {
+	repo string
+	h    restic.Handle
+}
+
+// saveLock serialises the saves of a file, refs counts the requests which use
+// it.
+type saveLock struct {
+	sync.Mutex
+	refs int
+}
+
+// New returns a new server which uses open to access the repositories.
+func New(open OpenFunc, cfg Config) (*Server, error) {
+	if cfg.PrivateRepos && cfg.Users == nil {
+		return nil, errors.New("private repositories require authentication")
+	}
+
+	return &Server{
+		cfg:      cfg,
+		open:     open,
+		backends: make(map[string]restic.Backend),
+		saving:   make(map[saveKey]*saveLock),
+	}, nil
+}
+
+// Close closes all backends opened by the server.
+func (s *Server) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var firstErr error
+	for path, be := range s.backends {
+		if err := be.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.backends, path)
+	}
+
+	return firstErr
+}
+
+// backend returns the backend for the repository at path.
+func (s *Server) backend(ctx context.Context, path string, create bool) (restic.Backend, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if be, ok := s.backends[path]; ok {
+		return be, nil
+	}
+
+	be, err := s.open(ctx, path, create)
+	if err != nil {
+		return nil, err
+	}
+
+	s.backends[path] = be
+	return be, nil
+}
+
+// lockSave waits until no other request saves the file h in the repository
+// repo and returns a function which releases the lock again.
+func (s *Server) lockSave(repo string, h restic.Handle) (unlock func()) {
+	k := saveKey{repo: repo, h: h}
+
+	s.m.Lock()
+	l, ok := s.saving[k]
+	if !ok {
+		l = &saveLock{}
+		s.saving[k] = l
+	}
+	l.refs++
+	s.m.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		s.m.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(s.saving, k)
+		}
+		s.m.Unlock()
+	}
+}
+
+// request describes the target of a request.
+type request struct {
+	repo string
+	h    restic.Handle
+	list bool
+}
+
+// parsePath returns the repository and the file or directory addressed by
+// the URL path p.
+func parsePath(p string) (req request, err error) {
+	trailingSlash := strings.HasSuffix(p, "/")
+	p = strings.Trim(p, "/")
+
+	var items []string
+	if p != "" {
+		items = strings.Split(p, "/")
+	}
+
+	for _, item := range items {
+		if item == "" || item == "." || item == ".." || strings.Contains(item, "\\") {
+			return request{}, errors.Errorf("invalid path %q", p)
+		}
+	}
+
+	n := len(items)
+	switch {
+	case n >= 1 && items[n-1] == "config" && !trailingSlash:
+		req.h = restic.Handle{Type: restic.ConfigFile}
+		items = items[:n-1]
+	case n >= 1 && dirTypes[items[n-1]] != "":
+		req.h = restic.Handle{Type: dirTypes[items[n-1]]}
+		req.list = true
+		items = items[:n-1]
+	case n >= 2 && dirTypes[items[n-2]] != "" && !trailingSlash:
+		req.h = restic.Handle{Type: dirTypes[items[n-2]], Name: items[n-1]}
+		items = items[:n-2]
+	}
+
+	req.repo = strings.Join(items, "/")
+	return req, nil
+}
+
+// authenticate checks the credentials of r and returns the user name.
+func (s *Server) authenticate(r *http.Request) (user string, ok bool) {
+	if s.cfg.Users == nil {
+		return "", true
+	}
+
+	user, password, ok := r.BasicAuth()
+	if !ok || !s.cfg.Users.Check(user, password) {
+		return "", false
+	}
+
+	return user, true
+}
+
+// allowed returns true if user may access the repository at path.
+func (s *Server) allowed(user, path string) bool {
+	if !s.cfg.PrivateRepos {
+		return true
+	}
+
+	return path == user || strings.HasPrefix(path, user+"/")
+}
+
+func httpError(w http.ResponseWriter, code int) {
+	http.Error(w, http.StatusText(code), code)
+}
+
+// ServeHTTP handles a request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	debug.Log("%v %v", r.Method, r.URL.Path)
+
+	user, ok := s.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="restic"`)
+		httpError(w, http.StatusUnauthorized)
+		return
+	}
+
+	req, err := parsePath(r.URL.Path)
+	if err != nil {
+		debug.Log("invalid request: %v", err)
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !s.allowed(user, req.repo) {
+		debug.Log("user %q may not access repository %q", user, req.repo)
+		httpError(w, http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case req.h.Type == "":
+		if r.Method != http.MethodPost || r.URL.Query().Get("create") != "true" {
+			httpError(w, http.StatusNotFound)
+			return
+		}
+		s.createRepo(w, r, req)
+	case req.list:
+		if r.Method != http.MethodGet {
+			httpError(w, http.StatusMethodNotAllowed)
+			return
+		}
+		s.list(w, r, req)
+	default:
+		switch r.Method {
+		case http.MethodHead, http.MethodGet:
+			s.load(w, r, req)
+		case http.MethodPost:
+			s.save(w, r, req)
+		case http.MethodDelete:
+			s.remove(w, r, req)
+		default:
+			httpError(w, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleError sends the response for an error returned by the backend.
+func handleError(w http.ResponseWriter, be restic.Backend, err error) {
+	if be != nil && be.IsNotExist(err) {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	debug.Log("error: %v", err)
+	httpError(w, http.StatusInternalServerError)
+}
+
+func (s *Server) createRepo(w http.ResponseWriter, r *http.Request, req request) {
+	_, err := s.backend(r.Context(), req.repo, true)
+	if err != nil {
+		debug.Log("unable to create repository %q: %v", req.repo, err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, req request) {
+	be, err := s.backend(r.Context(), req.repo, false)
+	if err != nil {
+		debug.Log("unable to open repository %q: %v", req.repo, err)
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	type file struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	}
+
+	files := []file{}
+	err = be.List(r.Context(), req.h.Type, func(fi restic.FileInfo) error {
+		files = append(files, file{Name: fi.Name, Size: fi.Size})
+		return nil
+	})
+	if err != nil {
+		handleError(w, be, err)
+		return
+	}
+
+	var result interface{} = files
+	contentType := contentTypeV2
+	if !strings.Contains(r.Header.Get("Accept"), contentTypeV2) {
+		names := make([]string, 0, len(files))
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+		result = names
+		contentType = contentTypeV1
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if err = json.NewEncoder(w).Encode(result); err != nil {
+		debug.Log("unable to send list: %v", err)
+	}
+}
+
+// parseRange parses the value of a Range header with a single range of the
+// form "bytes=start-" or "bytes=start-end".
+func parseRange(s string) (start, end int64, err error) {
+	if !strings.HasPrefix(s, "bytes=") || strings.Contains(s, ",") {
+		return 0, 0, errors.Errorf("unsupported range %q", s)
+	}
+
+	data := strings.SplitN(s[len("bytes="):], "-", 2)
+	if len(data) != 2 {
+		return 0, 0, errors.Errorf("invalid range %q", s)
+	}
+
+	start, err = strconv.ParseInt(data[0], 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errors.Errorf("invalid range %q", s)
+	}
+
+	end = -1
+	if data[1] != "" {
+		end, err = strconv.ParseInt(data[1], 10, 64)
+		if err != nil || end < start {
+			return 0, 0, errors.Errorf("invalid range %q", s)
+		}
+	}
+
+	return start, end, nil
+}
+
+func (s *Server) load(w http.ResponseWriter, r *http.Request, req request) {
+	be, err := s.backend(r.Context(), req.repo, false)
+	if err != nil {
+		debug.Log("unable to open repository %q: %v", req.repo, err)
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	fi, err := be.Stat(r.Context(), req.h)
+	if err != nil {
+		handleError(w, be, err)
+		return
+	}
+
+	start, end := int64(0), fi.Size-1
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		start, end, err = parseRange(rng)
+		if err != nil {
+			debug.Log("%v", err)
+			httpError(w, http.StatusBadRequest)
+			return
+		}
+
+		if start >= fi.Size {
+			w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(fi.Size, 10))
+			httpError(w, http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+
+		if end < 0 || end >= fi.Size {
+			end = fi.Size - 1
+		}
+
+		w.Header().Set("Content-Range", "bytes "+strconv.FormatInt(start, 10)+"-"+
+			strconv.FormatInt(end, 10)+"/"+strconv.FormatInt(fi.Size, 10))
+		status = http.StatusPartialContent
+	}
+
+	length := end - start + 1
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	if r.Method == http.MethodHead || length == 0 {
+		w.WriteHeader(status)
+		return
+	}
+
+	wroteHeader := false
+	err = be.Load(r.Context(), req.h, int(length), start, func(rd io.Reader) error {
+		w.WriteHeader(status)
+		wroteHeader = true
+		_, err := io.Copy(w, rd)
+		return err
+	})
+	if err != nil {
+		debug.Log("Load(%v) failed: %v", req.h, err)
+		if !wroteHeader {
+			handleError(w, be, err)
+		}
+	}
+}
+
+func (s *Server) save(w http.ResponseWriter, r *http.Request, req request) {
+	be, err := s.backend(r.Context(), req.repo, false)
+	if err != nil {
+		debug.Log("unable to open repository %q: %v", req.repo, err)
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		debug.Log("unable to read request body: %v", err)
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+
+	// concurrent requests for the same file must not both pass the check for
+	// an existing file below
+	unlock := s.lockSave(req.repo, req.h)
+	defer unlock()
+
+	if s.cfg.AppendOnly && req.h.Type != restic.LockFile {
+		// existing files must not be replaced
+		_, err := be.Stat(r.Context(), req.h)
+		if err == nil {
+			debug.Log("refusing to overwrite %v in append-only mode", req.h)
+			httpError(w, http.StatusForbidden)
+			return
+		}
+
+		if !be.IsNotExist(err) {
+			debug.Log("Stat(%v) failed: %v", req.h, err)
+			httpError(w, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = be.Save(r.Context(), req.h, restic.NewByteReader(buf))
+	if err != nil {
+		handleError(w, be, err)
+		return
+	}
+}
+
+func (s *Server) remove(w http.ResponseWriter, r *http.Request, req request) {
+	if s.cfg.AppendOnly && req.h.Type != restic.LockFile {
+		debug.Log("refusing to remove %v in append-only mode", req.h)
+		httpError(w, http.StatusForbidden)
+		return
+	}
+
+	be, err := s.backend(r.Context(), req.repo, false)
+	if err != nil {
+		debug.Log("unable to open repository %q: %v", req.repo, err)
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	err = be.Remove(r.Context(), req.h)
+	if err != nil {
+		handleError(w, be, err)
+		return
+	}
+}
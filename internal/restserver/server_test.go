@@ -0,0 +1,259 @@
+package restserver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestParsePath(t *testing.T) {
+	var tests = []struct {
+		path string
+		req  request
+		err  bool
+	}{
+		{path: "/", req: request{}},
+		{path: "/foo/bar", req: request{repo: "foo/bar"}},
+		{path: "/config", req: request{h: restic.Handle{Type: restic.ConfigFile}}},
+		{path: "/foo/config", req: request{repo: "foo", h: restic.Handle{Type: restic.ConfigFile}}},
+		{path: "/data/", req: request{h: restic.Handle{Type: restic.DataFile}, list: true}},
+		{path: "/foo/keys", req: request{repo: "foo", h: restic.Handle{Type: restic.KeyFile}, list: true}},
+		{path: "/foo/snapshots/1234", req: request{repo: "foo", h: restic.Handle{Type: restic.SnapshotFile, Name: "1234"}}},
+		{path: "/a/b/locks/1234", req: request{repo: "a/b", h: restic.Handle{Type: restic.LockFile, Name: "1234"}}},
+		{path: "/foo/../data/1234", err: true},
+		{path: "/foo//data/1234", err: true},
+	}
+
+	for _, test := range tests {
+		req, err := parsePath(test.path)
+		if test.err {
+			rtest.Assert(t, err != nil, "expected an error for %q", test.path)
+			continue
+		}
+
+		rtest.OK(t, err)
+		rtest.Equals(t, test.req, req)
+	}
+}
+
+func writeHtpasswd(t *testing.T, dir string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	rtest.OK(t, err)
+
+	data := "# users\n" +
+		"alice:" + string(hash) + "\n" +
+		"bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"
+
+	filename := filepath.Join(dir, "htpasswd")
+	rtest.OK(t, ioutil.WriteFile(filename, []byte(data), 0600))
+	return filename
+}
+
+func TestHtpasswd(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	users, err := ReadHtpasswd(writeHtpasswd(t, dir))
+	rtest.OK(t, err)
+
+	rtest.Assert(t, users.Check("alice", "secret"), "bcrypt password was not accepted")
+	rtest.Assert(t, !users.Check("alice", "wrong"), "wrong bcrypt password was accepted")
+	rtest.Assert(t, users.Check("bob", "password"), "SHA1 password was not accepted")
+	rtest.Assert(t, !users.Check("bob", "secret"), "wrong SHA1 password was accepted")
+	rtest.Assert(t, !users.Check("carol", "secret"), "unknown user was accepted")
+
+	filename := filepath.Join(dir, "invalid")
+	rtest.OK(t, ioutil.WriteFile(filename, []byte("carol:$apr1$foo$bar\n"), 0600))
+	_, err = ReadHtpasswd(filename)
+	rtest.Assert(t, err != nil, "expected an error for an unsupported hash")
+}
+
+// testServer runs a server which stores the repositories in memory.
+func testServer(t *testing.T, cfg Config) (*httptest.Server, map[string]restic.Backend) {
+	repos := make(map[string]restic.Backend)
+	open := func(ctx context.Context, path string, create bool) (restic.Backend, error) {
+		if _, ok := repos[path]; !ok && create {
+			repos[path] = mem.New()
+		}
+
+		be, ok := repos[path]
+		if !ok {
+			return nil, errors.Errorf("repository %q does not exist", path)
+		}
+		return be, nil
+	}
+
+	srv, err := New(open, cfg)
+	rtest.OK(t, err)
+
+	return httptest.NewServer(srv), repos
+}
+
+func doRequest(t *testing.T, method, url, user, password string, body string) int {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	rtest.OK(t, err)
+
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	rtest.OK(t, err)
+
+	_, err = ioutil.ReadAll(resp.Body)
+	rtest.OK(t, err)
+	rtest.OK(t, resp.Body.Close())
+
+	return resp.StatusCode
+}
+
+func TestServerAppendOnly(t *testing.T) {
+	ts, _ := testServer(t, Config{AppendOnly: true})
+	defer ts.Close()
+
+	var tests = []struct {
+		method, path string
+		status       int
+	}{
+		{"POST", "/repo?create=true", http.StatusOK},
+		{"POST", "/repo/config", http.StatusOK},
+		{"POST", "/repo/config", http.StatusForbidden},
+		{"POST", "/repo/data/1234", http.StatusOK},
+		{"POST", "/repo/data/1234", http.StatusForbidden},
+		{"POST", "/repo/snapshots/abcd", http.StatusOK},
+		{"POST", "/repo/snapshots/abcd", http.StatusForbidden},
+		{"GET", "/repo/snapshots/abcd", http.StatusOK},
+		{"POST", "/repo/locks/5678", http.StatusOK},
+		{"GET", "/repo/data/1234", http.StatusOK},
+		{"DELETE", "/repo/data/1234", http.StatusForbidden},
+		{"DELETE", "/repo/config", http.StatusForbidden},
+		{"DELETE", "/repo/locks/5678", http.StatusOK},
+		{"GET", "/repo/data/1234", http.StatusOK},
+		{"GET", "/repo/locks/5678", http.StatusNotFound},
+		{"GET", "/other/config", http.StatusNotFound},
+	}
+
+	for i, test := range tests {
+		status := doRequest(t, test.method, ts.URL+test.path, "", "", "data")
+		if status != test.status {
+			t.Errorf("test %d: %v %v: want status %v, got %v", i, test.method, test.path, test.status, status)
+		}
+	}
+}
+
+// slowSaveBackend delays all saves, so that concurrent requests overlap.
+type slowSaveBackend struct {
+	restic.Backend
+}
+
+func (be slowSaveBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	time.Sleep(50 * time.Millisecond)
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func TestServerAppendOnlyConcurrentSave(t *testing.T) {
+	be := slowSaveBackend{mem.New()}
+	open := func(ctx context.Context, path string, create bool) (restic.Backend, error) {
+		return be, nil
+	}
+
+	srv, err := New(open, Config{AppendOnly: true})
+	rtest.OK(t, err)
+
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	const requests = 5
+	results := make(chan int, requests)
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		go func(i int) {
+			resp, err := http.Post(ts.URL+"/data/1234", "application/octet-stream",
+				strings.NewReader(fmt.Sprintf("data %d", i)))
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			_ = resp.Body.Close()
+			results <- resp.StatusCode
+		}(i)
+	}
+
+	var ok, forbidden int
+	for i := 0; i < requests; i++ {
+		select {
+		case err := <-errs:
+			t.Fatal(err)
+		case status := <-results:
+			switch status {
+			case http.StatusOK:
+				ok++
+			case http.StatusForbidden:
+				forbidden++
+			default:
+				t.Errorf("unexpected status %v", status)
+			}
+		}
+	}
+
+	rtest.Equals(t, 1, ok)
+	rtest.Equals(t, requests-1, forbidden)
+
+	// a later request is refused as well
+	status := doRequest(t, "POST", ts.URL+"/data/1234", "", "", "data")
+	rtest.Equals(t, http.StatusForbidden, status)
+}
+
+func TestServerPrivateRepos(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	users, err := ReadHtpasswd(writeHtpasswd(t, dir))
+	rtest.OK(t, err)
+
+	_, err = New(nil, Config{PrivateRepos: true})
+	rtest.Assert(t, err != nil, "expected an error for private repositories without users")
+
+	ts, repos := testServer(t, Config{Users: users, PrivateRepos: true})
+	defer ts.Close()
+
+	var tests = []struct {
+		method, path   string
+		user, password string
+		status         int
+	}{
+		{"POST", "/alice?create=true", "", "", http.StatusUnauthorized},
+		{"POST", "/alice?create=true", "alice", "wrong", http.StatusUnauthorized},
+		{"POST", "/alice?create=true", "alice", "secret", http.StatusOK},
+		{"POST", "/alice/work?create=true", "alice", "secret", http.StatusOK},
+		{"POST", "/bob?create=true", "alice", "secret", http.StatusUnauthorized},
+		{"POST", "/?create=true", "alice", "secret", http.StatusUnauthorized},
+		{"POST", "/bob?create=true", "bob", "password", http.StatusOK},
+		{"POST", "/alice/config", "alice", "secret", http.StatusOK},
+		{"GET", "/alice/config", "bob", "password", http.StatusUnauthorized},
+		{"GET", "/alice/config", "alice", "secret", http.StatusOK},
+		{"GET", "/alicex/config", "alice", "secret", http.StatusUnauthorized},
+	}
+
+	for i, test := range tests {
+		status := doRequest(t, test.method, ts.URL+test.path, test.user, test.password, "data")
+		if status != test.status {
+			t.Errorf("test %d: %v %v: want status %v, got %v", i, test.method, test.path, test.status, status)
+		}
+	}
+
+	rtest.Equals(t, 3, len(repos))
+}